@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/gbh007/p2p-chat/internal/entities"
+	"github.com/gbh007/p2p-chat/proto/gen"
+)
+
+// inboxChannel - ящик личных сообщений пользователя, подписка на него есть всегда
+var inboxChannel = entities.DirectChannel("")
+
+// openDirect - загрузка переписки при первом открытии, дальше сообщения приходят через ящик
+func (c *ControllerGRPC) openDirect(name string) {
+	c.oldestMutex.Lock()
+	_, loaded := c.oldest[name]
+	c.oldestMutex.Unlock()
+
+	if loaded {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+	defer cancel()
+
+	_, err := c.loadInitialHistory(ctx, name)
+	if err != nil {
+		slog.Warn("load direct history", "chat", name, "error", err)
+	}
+}
+
+// handleInboxHistory - сообщения ящика раскладываются по перепискам с собеседниками
+func (c *ControllerGRPC) handleInboxHistory(messages []entities.Message) {
+	chats := make(map[string][]entities.Message)
+	order := make([]string, 0)
+
+	for _, msg := range messages {
+		if _, ok := chats[msg.Chat]; !ok {
+			order = append(order, msg.Chat)
+		}
+
+		chats[msg.Chat] = append(chats[msg.Chat], msg)
+	}
+
+	for _, name := range order {
+		c.initOldest(name, chats[name][0].Seq)
+		c.gui.HandleHistory(name, chats[name])
+	}
+}
+
+// initOldest - начало подгрузки истории переписки открытой сообщением из ящика
+func (c *ControllerGRPC) initOldest(name string, seq uint64) {
+	c.oldestMutex.Lock()
+	defer c.oldestMutex.Unlock()
+
+	if _, ok := c.oldest[name]; !ok {
+		c.oldest[name] = seq
+	}
+}
+
+// directChat - переписка к которой относится сообщение из ящика
+func (c *ControllerGRPC) directChat(msg *gen.ReadMessagesResponse) string {
+	if msg.GetLogin() == c.login && msg.GetDomain() == "" {
+		return entities.DirectChannel(msg.GetTo())
+	}
+
+	return entities.DirectChannel(msg.GetLogin())
+}
+
+// signedChannel - личное сообщение подписано с каналом получателя
+func signedChannel(name string, msg *gen.ReadMessagesResponse) string {
+	if msg.GetTo() != "" {
+		return entities.DirectChannel(msg.GetTo())
+	}
+
+	return name
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+
+	"github.com/gbh007/p2p-chat/internal/entities"
+	"github.com/gbh007/p2p-chat/internal/identity"
+	"github.com/gbh007/p2p-chat/proto/gen"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// EditMessage - замена текста собственного сообщения, новый текст подписывается со временем изменения
+// и видом сообщения, вид при изменении не меняется
+func (c *ControllerGRPC) EditMessage(chat, id string, kind entities.MessageKind, text string) error {
+	req := &gen.EditMessageRequest{
+		Channel: chat,
+		Id:      id,
+		Ts:      timestamppb.Now(),
+	}
+
+	var err error
+
+	req.Message, req.Ciphertext, req.KeyEpoch, err = c.seal(chat, id, text)
+	if err != nil {
+		return err
+	}
+
+	req.Signature = identity.SignMessage(
+		c.keystore.PrivateKey(),
+		chat,
+		identity.SignedText(req.GetMessage(), req.GetCiphertext()),
+		kind,
+		req.GetTs().AsTime(),
+		id,
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+	defer cancel()
+
+	// Измененное сообщение придет событием подписки
+	_, err = c.client.EditMessage(ctx, req)
+
+	return err
+}
+
+// DeleteMessage - удаление сообщения, чужие сообщения может удалить только модератор.
+// Подпись удаления позволяет соседним узлам удалить свои копии собственного сообщения
+func (c *ControllerGRPC) DeleteMessage(chat, id string) error {
+	req := &gen.DeleteMessageRequest{
+		Channel: chat,
+		Id:      id,
+		Ts:      timestamppb.Now(),
+	}
+
+	req.Signature = identity.SignDeletion(c.keystore.PrivateKey(), chat, id, req.GetTs().AsTime())
+
+	ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+	defer cancel()
+
+	_, err := c.client.DeleteMessage(ctx, req)
+
+	return err
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdh"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+
+	"github.com/gbh007/p2p-chat/internal/e2e"
+	"github.com/gbh007/p2p-chat/internal/identity"
+	"github.com/gbh007/p2p-chat/proto/gen"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var errNotMember = errors.New("not a channel member")
+
+// channelKeys - групповые ключи зашифрованного канала, доступные пользователю
+type channelKeys struct {
+	owner   string
+	epoch   uint32
+	members []string
+	keys    map[uint32][]byte
+}
+
+func (c *ControllerGRPC) publishKey() error {
+	encryptionKey := c.keystore.EncryptionKey().PublicKey().Bytes()
+
+	_, err := c.client.PublishKey(context.Background(), &gen.PublishKeyRequest{
+		EncryptionKey: encryptionKey,
+		Signature:     identity.SignEncryptionKey(c.keystore.PrivateKey(), c.login, encryptionKey),
+	})
+	// Без учетной записи (только mTLS) шифрованные каналы недоступны
+	if status.Code(err) == codes.FailedPrecondition {
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("publish key: %w", err)
+	}
+
+	return nil
+}
+
+// refreshKeys - загружает ключи канала, каждая выдача проверяется по ключу владельца
+func (c *ControllerGRPC) refreshKeys(ctx context.Context, chat string) error {
+	res, err := c.client.GetChannelKeys(ctx, &gen.GetChannelKeysRequest{
+		Channel: chat,
+	})
+	if err != nil {
+		return err
+	}
+
+	if res.GetEpoch() == 0 {
+		c.keysMutex.Lock()
+		delete(c.channelKeys, chat)
+		c.keysMutex.Unlock()
+
+		return nil
+	}
+
+	ok, err := c.keystore.Pin(res.GetOwner(), res.GetOwnerPublicKey())
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return fmt.Errorf("channel %s owner %s: key mismatch", chat, res.GetOwner())
+	}
+
+	state := &channelKeys{
+		owner:   res.GetOwner(),
+		epoch:   res.GetEpoch(),
+		members: res.GetMembers(),
+		keys:    make(map[uint32][]byte, len(res.GetKeys())),
+	}
+
+	for _, key := range res.GetKeys() {
+		if !identity.VerifyKeyShare(res.GetOwnerPublicKey(), chat, key.GetEpoch(), c.login, key.GetKey(), key.GetSignature()) {
+			continue
+		}
+
+		groupKey, err := e2e.UnwrapKey(key.GetKey(), c.keystore.EncryptionKey(), chat, key.GetEpoch())
+		if err != nil {
+			continue
+		}
+
+		state.keys[key.GetEpoch()] = groupKey
+	}
+
+	c.keysMutex.Lock()
+	c.channelKeys[chat] = state
+	c.keysMutex.Unlock()
+
+	return nil
+}
+
+// currentKey - ключ последней эпохи, false если канал не зашифрован
+func (c *ControllerGRPC) currentKey(chat string) ([]byte, uint32, bool, error) {
+	c.keysMutex.Lock()
+	defer c.keysMutex.Unlock()
+
+	state, ok := c.channelKeys[chat]
+	if !ok {
+		return nil, 0, false, nil
+	}
+
+	key, ok := state.keys[state.epoch]
+	if !ok {
+		return nil, 0, true, errNotMember
+	}
+
+	return key, state.epoch, true, nil
+}
+
+// seal - текст сообщения для отправки, в зашифрованном чате передается только шифротекст
+func (c *ControllerGRPC) seal(chat, id, text string) (string, []byte, uint32, error) {
+	key, epoch, encrypted, err := c.currentKey(chat)
+	if err != nil {
+		return "", nil, 0, err
+	}
+
+	if !encrypted {
+		return text, nil, 0, nil
+	}
+
+	ciphertext, err := e2e.Encrypt(key, chat, id, []byte(text))
+	if err != nil {
+		return "", nil, 0, err
+	}
+
+	return "", ciphertext, epoch, nil
+}
+
+func (c *ControllerGRPC) epochKey(chat string, epoch uint32) ([]byte, bool) {
+	c.keysMutex.Lock()
+	defer c.keysMutex.Unlock()
+
+	state, ok := c.channelKeys[chat]
+	if !ok {
+		return nil, false
+	}
+
+	key, ok := state.keys[epoch]
+
+	return key, ok
+}
+
+// decrypt - расшифровка известным ключом, без ключа эпохи сообщение откладывается до загрузки ключей,
+// сама загрузка не выполняется, чтобы не задерживать прием сообщений
+func (c *ControllerGRPC) decrypt(chat string, msg *gen.ReadMessagesResponse) ([]byte, error) {
+	key, ok := c.epochKey(chat, msg.GetKeyEpoch())
+	if !ok {
+		c.deferDecrypt(chat, msg)
+
+		return nil, e2e.ErrDecrypt
+	}
+
+	return e2e.Decrypt(key, chat, msg.GetId(), msg.GetCiphertext())
+}
+
+// deferDecrypt - ключ мог смениться после подключения, ключи загружаются в фоне один раз на несколько сообщений
+func (c *ControllerGRPC) deferDecrypt(chat string, msg *gen.ReadMessagesResponse) {
+	c.keysMutex.Lock()
+	queued := c.undecrypted[chat]
+	c.undecrypted[chat] = append(queued, msg)
+	c.keysMutex.Unlock()
+
+	if len(queued) == 0 {
+		go c.redecrypt(chat)
+	}
+}
+
+// redecrypt - загрузка ключей канала и повторный показ отложенных сообщений
+func (c *ControllerGRPC) redecrypt(chat string) {
+	ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+	defer cancel()
+
+	err := c.refreshKeys(ctx, chat)
+
+	c.keysMutex.Lock()
+	messages := c.undecrypted[chat]
+	delete(c.undecrypted, chat)
+	c.keysMutex.Unlock()
+
+	if err != nil {
+		slog.Warn("refresh keys", "chan", chat, "error", err)
+
+		return
+	}
+
+	for _, msg := range messages {
+		// Без ключа сообщение снова было бы отложено
+		if _, ok := c.epochKey(chat, msg.GetKeyEpoch()); !ok {
+			continue
+		}
+
+		c.gui.HandleMessageChanged(c.convertMessage(chat, msg))
+	}
+}
+
+func (c *ControllerGRPC) Members(chat string) []string {
+	c.keysMutex.Lock()
+	defer c.keysMutex.Unlock()
+
+	state, ok := c.channelKeys[chat]
+	if !ok {
+		return nil
+	}
+
+	return slices.Clone(state.members)
+}
+
+// SetMembers - включает шифрование канала или меняет его участников,
+// любое изменение выпускает новый групповой ключ, поэтому исключенные
+// участники не смогут читать новые сообщения
+func (c *ControllerGRPC) SetMembers(chat string, members []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+	defer cancel()
+
+	err := c.refreshKeys(ctx, chat)
+	if err != nil {
+		return err
+	}
+
+	var epoch uint32
+
+	c.keysMutex.Lock()
+	if state, ok := c.channelKeys[chat]; ok {
+		epoch = state.epoch
+	}
+	c.keysMutex.Unlock()
+
+	if !slices.Contains(members, c.login) {
+		members = append(members, c.login)
+	}
+
+	slices.Sort(members)
+	members = slices.Compact(members)
+
+	recipients, err := c.encryptionKeys(ctx, members)
+	if err != nil {
+		return err
+	}
+
+	groupKey, err := e2e.NewGroupKey()
+	if err != nil {
+		return err
+	}
+
+	epoch++
+
+	shares := make([]*gen.WrappedKey, 0, len(members))
+
+	for _, login := range members {
+		wrapped, err := e2e.WrapKey(groupKey, recipients[login], chat, epoch)
+		if err != nil {
+			return err
+		}
+
+		shares = append(shares, &gen.WrappedKey{
+			Login:     login,
+			Key:       wrapped,
+			Signature: identity.SignKeyShare(c.keystore.PrivateKey(), chat, epoch, login, wrapped),
+		})
+	}
+
+	_, err = c.client.ShareChannelKey(ctx, &gen.ShareChannelKeyRequest{
+		Channel: chat,
+		Epoch:   epoch,
+		Keys:    shares,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.refreshKeys(ctx, chat)
+}
+
+// encryptionKeys - ключи шифрования участников, подписанные их закрепленными ключами
+func (c *ControllerGRPC) encryptionKeys(ctx context.Context, members []string) (map[string]*ecdh.PublicKey, error) {
+	res, err := c.client.GetKeys(ctx, &gen.GetKeysRequest{
+		Logins: members,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]*ecdh.PublicKey, len(members))
+
+	for _, userKeys := range res.GetKeys() {
+		ok, err := c.keystore.Pin(userKeys.GetLogin(), userKeys.GetPublicKey())
+		if err != nil {
+			return nil, err
+		}
+
+		if !ok {
+			return nil, fmt.Errorf("%s: identity key mismatch", userKeys.GetLogin())
+		}
+
+		if !identity.VerifyEncryptionKey(userKeys.GetPublicKey(), userKeys.GetLogin(), userKeys.GetEncryptionKey(), userKeys.GetSignature()) {
+			return nil, fmt.Errorf("%s: invalid encryption key signature", userKeys.GetLogin())
+		}
+
+		key, err := ecdh.X25519().NewPublicKey(userKeys.GetEncryptionKey())
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", userKeys.GetLogin(), err)
+		}
+
+		keys[userKeys.GetLogin()] = key
+	}
+
+	for _, login := range members {
+		if _, ok := keys[login]; !ok {
+			return nil, fmt.Errorf("%s: no encryption key", login)
+		}
+	}
+
+	return keys, nil
+}
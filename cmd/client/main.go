@@ -2,24 +2,54 @@ package main
 
 import (
 	"context"
-	"math/rand"
-	"strconv"
+	"crypto/ed25519"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
 	"time"
 
+	"github.com/gbh007/p2p-chat/internal/auth"
+	"github.com/gbh007/p2p-chat/internal/certs"
+	"github.com/gbh007/p2p-chat/internal/config"
 	"github.com/gbh007/p2p-chat/internal/entities"
 	"github.com/gbh007/p2p-chat/internal/gui"
+	"github.com/gbh007/p2p-chat/internal/identity"
+	"github.com/gbh007/p2p-chat/internal/outbox"
+	"github.com/gbh007/p2p-chat/internal/p2p"
+	"github.com/gbh007/p2p-chat/internal/protoconv"
+	"github.com/gbh007/p2p-chat/internal/ulid"
 	"github.com/gbh007/p2p-chat/proto/gen"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
+type guiHandler interface {
+	HandleMessage(msg entities.Message)
+	HandleHistory(chat string, messages []entities.Message)
+	HandleConnectionState(chat string, state entities.ConnectionState)
+	HandleDeliveryState(chat, id string, state entities.DeliveryState)
+	HandlePeers(peers []entities.Peer)
+	HandlePresence(chat, user string, presence entities.PresenceStatus)
+	HandleMembers(chat string, members []entities.Member)
+	HandleTyping(chat, user string)
+	HandleMessageChanged(msg entities.Message)
+	HandleThread(chat string, root entities.Message, replies []entities.Message)
+	NewChat(name string)
+}
+
 type ControllerMock struct {
 	ch chan entities.Message
 
-	gui interface {
-		HandleMessage(msg entities.Message)
-		NewChat(name string)
-	}
+	gui guiHandler
 }
 
 func NewControllerMock() *ControllerMock {
@@ -28,8 +58,25 @@ func NewControllerMock() *ControllerMock {
 	}
 }
 
-func (c *ControllerMock) SendMessage(chat, msg string) {
+func (c *ControllerMock) SendMessage(chat, msg string) error {
+	return c.send(chat, msg, entities.MessageText)
+}
+
+func (c *ControllerMock) SendReply(chat, replyTo, msg string) error {
+	return c.SendMessage(chat, msg)
+}
+
+func (c *ControllerMock) SendAction(chat, msg string) error {
+	return c.send(chat, msg, entities.MessageAction)
+}
+
+func (c *ControllerMock) SetTopic(chat, topic string) error {
+	return c.send(chat, topic, entities.MessageTopic)
+}
+
+func (c *ControllerMock) send(chat, msg string, kind entities.MessageKind) error {
 	c.ch <- entities.Message{
+		ID:            ulid.New(),
 		Chat:          chat,
 		User:          "myuser",
 		Domain:        "localhost",
@@ -37,13 +84,13 @@ func (c *ControllerMock) SendMessage(chat, msg string) {
 		TS:            time.Now(),
 		IsOwn:         true,
 		IsLocalDomain: true,
+		Kind:          kind,
 	}
+
+	return nil
 }
 
-func (c *ControllerMock) SetGUI(gui interface {
-	HandleMessage(msg entities.Message)
-	NewChat(name string)
-}) {
+func (c *ControllerMock) SetGUI(gui guiHandler) {
 	c.gui = gui
 }
 
@@ -55,15 +102,142 @@ func (c *ControllerMock) Serve() {
 
 func (c *ControllerMock) Connect(name string) {
 	c.gui.NewChat(name)
+	c.gui.HandleConnectionState(name, entities.ConnectionOnline)
+}
+
+func (c *ControllerMock) LoadHistory(name string) error {
+	return nil
+}
+
+func (c *ControllerMock) Members(chat string) []string {
+	return nil
+}
+
+func (c *ControllerMock) SetMembers(chat string, members []string) error {
+	return nil
+}
+
+func (c *ControllerMock) ConnectPeer(addr string) error {
+	return nil
+}
+
+func (c *ControllerMock) Leave(name string) {}
+
+func (c *ControllerMock) SetAway(away bool) {}
+
+func (c *ControllerMock) Typing(chat string) {}
+
+func (c *ControllerMock) EditMessage(chat, id string, kind entities.MessageKind, text string) error {
+	return nil
+}
+
+func (c *ControllerMock) DeleteMessage(chat, id string) error {
+	return nil
+}
+
+func (c *ControllerMock) AddReaction(chat, id, emoji string) error {
+	return nil
+}
+
+func (c *ControllerMock) RemoveReaction(chat, id, emoji string) error {
+	return nil
+}
+
+func (c *ControllerMock) LoadThread(chat, id string) error {
+	return nil
+}
+
+func (c *ControllerMock) Login() string {
+	return "mock"
 }
 
 func main() {
-	// cm := NewControllerMock()
-	cm, err := NewControllerGRPC()
+	cfg, err := config.LoadClient(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+
+	if err == nil {
+		err = cfg.Validate()
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "invalid configuration:")
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	// Терминал занят интерфейсом, логи пишутся только в файл
+	logOutput := io.Discard
+
+	if cfg.LogFile != "" {
+		f, err := os.OpenFile(cfg.LogFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+		if err != nil {
+			panic(err)
+		}
+
+		defer f.Close()
+
+		logOutput = f
+	}
+
+	slog.SetDefault(slog.New(slog.NewTextHandler(logOutput, &slog.HandlerOptions{
+		Level: config.LogLevel(cfg.LogLevel),
+	})))
+
+	ks, err := identity.Open(cfg.Identity.Keystore)
 	if err != nil {
 		panic(err)
 	}
 
+	var node *p2p.Node
+
+	if cfg.P2P.Enabled {
+		node, cfg, err = startNode(context.Background(), cfg, ks)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "p2p:", err)
+			os.Exit(1)
+		}
+	}
+
+	transport := insecure.NewCredentials()
+
+	if cfg.TLS.UseTLS() {
+		tlsCfg, err := certs.ClientConfig(cfg.TLS.Cert, cfg.TLS.Key, cfg.TLS.CA, cfg.TLS.ServerName)
+		if err != nil {
+			panic(err)
+		}
+
+		transport = credentials.NewTLS(tlsCfg)
+	}
+
+	// При mTLS логином является CN сертификата
+	if cfg.TLS.Cert != "" {
+		cn, err := certs.CommonName(cfg.TLS.Cert)
+		if err != nil {
+			panic(err)
+		}
+
+		if cfg.Identity.Login != "" && cfg.Identity.Login != cn {
+			panic(fmt.Sprintf("login %s does not match client certificate %s", cfg.Identity.Login, cn))
+		}
+
+		cfg.Identity.Login = cn
+	}
+
+	if cfg.Identity.Login == "" {
+		cfg.Identity.Login = identity.Fingerprint(ks.PublicKey())
+	}
+
+	// cm := NewControllerMock()
+	cm, err := NewControllerGRPC(ks, transport, cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "connect:", err)
+		os.Exit(1)
+	}
+
+	cm.SetNode(node)
+
 	gm := gui.New(cm)
 	err = gm.Init()
 	if err != nil {
@@ -83,49 +257,206 @@ func main() {
 type ControllerGRPC struct {
 	client gen.ServerClient
 	conn   *grpc.ClientConn
+	creds  *auth.TokenCredentials
 	login  string
+	// password - для повторного входа если сервер потерял сессию
+	password string
+
+	pageSize int
+
+	keystore *identity.Keystore
+
+	// oldest - номер самого старого загруженного сообщения чата, 0 - история загружена полностью
+	oldest map[string]uint64
+	// cursors - номер последнего полученного сообщения чата, для продолжения чтения после обрыва
+	cursors     map[string]uint64
+	oldestMutex *sync.Mutex
+
+	channelKeys map[string]*channelKeys
+	// undecrypted - сообщения ожидающие загрузки ключей канала, пока они есть загрузка уже идет
+	undecrypted map[string][]*gen.ReadMessagesResponse
+	keysMutex   *sync.Mutex
+
+	outbox *outbox.Outbox
+	// outboxTimeout - время после которого неотправленное сообщение считается потерянным
+	outboxTimeout time.Duration
+
+	session *sessionState
+
+	// node - локальный узел в p2p режиме, nil при работе через сервер
+	node *p2p.Node
 
 	ch chan entities.Message
 
-	gui interface {
-		HandleMessage(msg entities.Message)
-		NewChat(name string)
-	}
+	gui guiHandler
 }
 
-func NewControllerGRPC() (*ControllerGRPC, error) {
+func NewControllerGRPC(
+	keystore *identity.Keystore,
+	transport credentials.TransportCredentials,
+	cfg config.Client,
+) (*ControllerGRPC, error) {
+	// Собственный ключ известен заранее, подмена будет обнаружена
+	_, err := keystore.Pin(cfg.Identity.Login, keystore.PublicKey())
+	if err != nil {
+		return nil, err
+	}
+
+	queue, err := outbox.Open(cfg.OutboxPath())
+	if err != nil {
+		return nil, err
+	}
+
 	c := &ControllerGRPC{
-		ch:    make(chan entities.Message, 10),
-		login: strconv.Itoa(rand.Int()),
+		ch:            make(chan entities.Message, 10),
+		creds:         auth.NewTokenCredentials(),
+		login:         cfg.Identity.Login,
+		password:      cfg.Identity.Password,
+		pageSize:      cfg.Limits.HistoryPageSize,
+		keystore:      keystore,
+		oldest:        make(map[string]uint64),
+		cursors:       make(map[string]uint64),
+		oldestMutex:   &sync.Mutex{},
+		channelKeys:   make(map[string]*channelKeys),
+		undecrypted:   make(map[string][]*gen.ReadMessagesResponse),
+		keysMutex:     &sync.Mutex{},
+		outbox:        queue,
+		outboxTimeout: cfg.Outbox.Timeout,
+		session:       newSessionState(),
+	}
+
+	err = c.connect(cfg.Server, transport)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.authenticate(cfg.Identity.Password, cfg.Identity.Register)
+	if err != nil {
+		return nil, err
 	}
 
-	err := c.connect()
+	err = c.publishKey()
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
 
-	return c, err
+// SendMessage - сообщение ставится в очередь на диске и отправляется в фоне,
+// до подтверждения сервером оно показывается как ожидающее
+func (c *ControllerGRPC) SendMessage(chat, msg string) error {
+	return c.enqueue(outbox.Entry{
+		ID:      ulid.New(),
+		Chat:    chat,
+		Text:    msg,
+		Created: time.Now(),
+	})
+}
+
+// SendReply - ответ на сообщение чата, отправляется через очередь как и обычное сообщение
+func (c *ControllerGRPC) SendReply(chat, replyTo, msg string) error {
+	return c.enqueue(outbox.Entry{
+		ID:      ulid.New(),
+		Chat:    chat,
+		Text:    msg,
+		Created: time.Now(),
+		ReplyTo: replyTo,
+	})
 }
 
-func (c *ControllerGRPC) SendMessage(chat, msg string) {
-	_, err := c.client.SendMessage(context.Background(), &gen.SendMessageRequest{
-		Login:   c.login,
-		Channel: chat,
-		Message: msg,
+// SendAction - действие пользователя (/me), вид сообщения передается отдельно от текста
+func (c *ControllerGRPC) SendAction(chat, msg string) error {
+	return c.enqueue(outbox.Entry{
+		ID:      ulid.New(),
+		Chat:    chat,
+		Text:    msg,
+		Created: time.Now(),
+		Kind:    entities.MessageAction,
 	})
+}
+
+// SetTopic - смена темы чата, тема хранится как сообщение вида MessageTopic
+func (c *ControllerGRPC) SetTopic(chat, topic string) error {
+	return c.enqueue(outbox.Entry{
+		ID:      ulid.New(),
+		Chat:    chat,
+		Text:    topic,
+		Created: time.Now(),
+		Kind:    entities.MessageTopic,
+	})
+}
+
+func (c *ControllerGRPC) enqueue(entry outbox.Entry) error {
+	err := c.outbox.Add(entry)
 	if err != nil {
-		panic(err)
+		return err
 	}
+
+	c.gui.HandleMessage(c.pendingMessage(entry))
+
+	return nil
 }
 
-func (c *ControllerGRPC) SetGUI(gui interface {
-	HandleMessage(msg entities.Message)
-	NewChat(name string)
-}) {
+func (c *ControllerGRPC) sendMessage(ctx context.Context, entry outbox.Entry) error {
+	req := &gen.SendMessageRequest{
+		Channel: entry.Chat,
+		Id:      entry.ID,
+		Ts:      timestamppb.Now(),
+		ReplyTo: entry.ReplyTo,
+		Kind:    protoconv.KindToProto(entry.Kind),
+	}
+
+	var err error
+
+	req.Message, req.Ciphertext, req.KeyEpoch, err = c.seal(entry.Chat, entry.ID, entry.Text)
+	if err != nil {
+		return err
+	}
+
+	req.Signature = identity.SignMessage(
+		c.keystore.PrivateKey(),
+		entry.Chat,
+		identity.SignedText(req.GetMessage(), req.GetCiphertext()),
+		entry.Kind,
+		req.GetTs().AsTime(),
+		req.GetId(),
+	)
+
+	ctx, cancel := context.WithTimeout(ctx, sendTimeout)
+	defer cancel()
+
+	_, err = c.request(ctx, &gen.ClientFrame{Frame: &gen.ClientFrame_Send{Send: req}})
+
+	return err
+}
+
+func (c *ControllerGRPC) SetGUI(gui guiHandler) {
 	c.gui = gui
+
+	if c.node != nil {
+		c.node.SetPeersHandler(gui.HandlePeers)
+	}
 }
 
-func (c *ControllerGRPC) connect() error {
+func (c *ControllerGRPC) SetNode(node *p2p.Node) {
+	c.node = node
+}
+
+func (c *ControllerGRPC) connect(addr string, transport credentials.TransportCredentials) error {
 	conn, err := grpc.NewClient(
-		"localhost:8080",
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		addr,
+		grpc.WithTransportCredentials(transport),
+		grpc.WithConnectParams(grpc.ConnectParams{
+			Backoff: backoff.Config{
+				BaseDelay:  minReconnectDelay,
+				Multiplier: 2,
+				Jitter:     0.2,
+				MaxDelay:   maxReconnectDelay,
+			},
+			MinConnectTimeout: sendTimeout,
+		}),
+		grpc.WithPerRPCCredentials(c.creds),
 	)
 	if err != nil {
 		return err
@@ -137,38 +468,262 @@ func (c *ControllerGRPC) connect() error {
 	return nil
 }
 
-func (c *ControllerGRPC) Serve() {}
+func (c *ControllerGRPC) authenticate(password string, register bool) error {
+	if register {
+		res, err := c.client.Register(context.Background(), &gen.RegisterRequest{
+			Login:     c.login,
+			Password:  password,
+			PublicKey: c.keystore.PublicKey(),
+		})
+		if err != nil {
+			return fmt.Errorf("register: %w", err)
+		}
 
-func (c *ControllerGRPC) Connect(name string) {
-	ctx, cancel := context.WithCancel(context.TODO())
+		c.creds.SetToken(res.GetToken())
 
-	res, err := c.client.ReadMessages(ctx, &gen.ReadMessagesRequest{
-		Channel: name,
-		Login:   c.login,
+		return nil
+	}
+
+	// Без пароля пользователь определяется клиентским сертификатом
+	if password == "" {
+		return nil
+	}
+
+	res, err := c.client.Login(context.Background(), &gen.LoginRequest{
+		Login:    c.login,
+		Password: password,
 	})
-	if err == nil {
-		c.gui.NewChat(name)
-	} else {
-		panic(err)
+	if err != nil {
+		return fmt.Errorf("login: %w", err)
+	}
+
+	c.creds.SetToken(res.GetToken())
+
+	return nil
+}
+
+func (c *ControllerGRPC) Connect(name string) {
+	if name == inboxChannel {
+		return
+	}
+
+	c.gui.NewChat(name)
+
+	for _, entry := range c.outbox.Chat(name) {
+		c.gui.HandleMessage(c.pendingMessage(entry))
+	}
+
+	// Личная переписка приходит через ящик, отдельная подписка не нужна
+	if _, ok := entities.DirectPeer(name); ok {
+		go c.openDirect(name)
+
+		return
 	}
 
+	c.gui.HandleConnectionState(name, entities.ConnectionConnecting)
+	c.addChannel(name)
+
+	go c.joinChannel(name)
+}
+
+// Login - логин пользователя, под которым он отправляет сообщения
+func (c *ControllerGRPC) Login() string {
+	return c.login
+}
+
+// Leave - выход из чата: отписка на сервере, удаление неотправленных сообщений и состояния чата
+func (c *ControllerGRPC) Leave(name string) {
+	_, direct := entities.DirectPeer(name)
+
+	c.removeChannel(name)
+
 	go func() {
+		if direct {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
 		defer cancel()
 
-		for {
-			msg, err := res.Recv()
-			if err != nil {
-				return
-			}
-
-			c.gui.HandleMessage(entities.Message{
-				Chat:          name,
-				User:          msg.GetLogin(),
-				Text:          msg.GetMessage(),
-				TS:            msg.GetTs().AsTime(),
-				IsOwn:         msg.GetLogin() == c.login,
-				IsLocalDomain: true,
-			})
+		// Без сессии подписки на сервере уже нет
+		_, err := c.request(ctx, &gen.ClientFrame{
+			Frame: &gen.ClientFrame_Unsubscribe{Unsubscribe: &gen.UnsubscribeFrame{Channel: name}},
+		})
+		if err != nil && status.Code(err) != codes.Unavailable && status.Code(err) != codes.NotFound {
+			slog.Warn("unsubscribe", "chan", name, "error", err)
 		}
 	}()
+
+	for _, entry := range c.outbox.Chat(name) {
+		err := c.outbox.Remove(entry.ID)
+		if err != nil {
+			slog.Error("remove from outbox", "id", entry.ID, "error", err)
+		}
+	}
+
+	c.oldestMutex.Lock()
+	delete(c.oldest, name)
+	delete(c.cursors, name)
+	c.oldestMutex.Unlock()
+
+	c.keysMutex.Lock()
+	delete(c.channelKeys, name)
+	delete(c.undecrypted, name)
+	c.keysMutex.Unlock()
+
+	if !direct {
+		c.leaveChannel(name)
+	}
+}
+
+// LoadHistory - загрузка страницы сообщений старше уже загруженных, результат передается в интерфейс
+func (c *ControllerGRPC) LoadHistory(name string) error {
+	c.oldestMutex.Lock()
+	before, ok := c.oldest[name]
+	c.oldestMutex.Unlock()
+
+	if !ok || before == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+	defer cancel()
+
+	history, err := c.client.GetHistory(ctx, &gen.GetHistoryRequest{
+		Channel: name,
+		Before:  before,
+		Limit:   uint32(c.pageSize),
+	})
+	if err != nil {
+		return err
+	}
+
+	messages := c.convertMessages(name, history.GetMessages())
+
+	c.setOldest(name, messages)
+	c.gui.HandleHistory(name, messages)
+
+	return nil
+}
+
+func (c *ControllerGRPC) setOldest(name string, messages []entities.Message) {
+	c.oldestMutex.Lock()
+	defer c.oldestMutex.Unlock()
+
+	// Неполная страница означает что более старых сообщений нет
+	if len(messages) < c.pageSize {
+		c.oldest[name] = 0
+
+		return
+	}
+
+	c.oldest[name] = messages[0].Seq
+}
+
+func (c *ControllerGRPC) convertMessages(name string, raw []*gen.ReadMessagesResponse) []entities.Message {
+	messages := make([]entities.Message, 0, len(raw))
+
+	for _, msg := range raw {
+		messages = append(messages, c.convertMessage(name, msg))
+	}
+
+	return messages
+}
+
+func (c *ControllerGRPC) convertMessage(name string, msg *gen.ReadMessagesResponse) entities.Message {
+	chat := name
+	if name == inboxChannel {
+		chat = c.directChat(msg)
+	}
+
+	// Неизвестный вид показывается как текст, подпись такого сообщения не подтверждается
+	kind, _ := protoconv.KindFromProto(msg.GetKind())
+
+	result := entities.Message{
+		ID:            msg.GetId(),
+		Chat:          chat,
+		Seq:           msg.GetSeq(),
+		User:          msg.GetLogin(),
+		Domain:        msg.GetDomain(),
+		Text:          msg.GetMessage(),
+		TS:            msg.GetTs().AsTime(),
+		IsOwn:         msg.GetLogin() == c.login && msg.GetDomain() == "",
+		IsLocalDomain: msg.GetDomain() == "",
+		To:            msg.GetTo(),
+		Deleted:       msg.GetDeleted(),
+		ChangeSeq:     msg.GetChangeSeq(),
+		ReplyTo:       msg.GetReplyTo(),
+		Kind:          kind,
+
+		Signature:       msg.GetSignature(),
+		PublicKey:       msg.GetPublicKey(),
+		SignatureStatus: c.verify(name, msg),
+
+		Ciphertext: msg.GetCiphertext(),
+		KeyEpoch:   msg.GetKeyEpoch(),
+	}
+
+	if msg.Edited != nil {
+		result.Edited = msg.GetEdited().AsTime()
+	}
+
+	for _, reaction := range msg.GetReactions() {
+		result.Reactions = append(result.Reactions, entities.Reaction{
+			Emoji: reaction.GetEmoji(),
+			Users: reaction.GetUsers(),
+		})
+	}
+
+	if len(msg.GetCiphertext()) > 0 {
+		text, err := c.decrypt(name, msg)
+		if err != nil {
+			result.DecryptFailed = true
+			result.Text = "[unable to decrypt]"
+		} else {
+			result.Text = string(text)
+		}
+	}
+
+	return result
+}
+
+// verify - проверка подписи, ключ отправителя запоминается при первой встрече
+func (c *ControllerGRPC) verify(name string, msg *gen.ReadMessagesResponse) entities.SignatureStatus {
+	if len(msg.GetSignature()) == 0 {
+		return entities.SignatureUnsigned
+	}
+
+	if len(msg.GetPublicKey()) != ed25519.PublicKeySize {
+		return entities.SignatureUnknownKey
+	}
+
+	// Пользователи других серверов федерации различаются по домену
+	owner := msg.GetLogin()
+	if msg.GetDomain() != "" {
+		owner += "@" + msg.GetDomain()
+	}
+
+	ok, err := c.keystore.Pin(owner, msg.GetPublicKey())
+	if err != nil || !ok {
+		return entities.SignatureUnknownKey
+	}
+
+	kind, ok := protoconv.KindFromProto(msg.GetKind())
+	if !ok {
+		return entities.SignatureInvalid
+	}
+
+	signedText := identity.SignedText(msg.GetMessage(), msg.GetCiphertext())
+
+	// Измененное сообщение подписано со временем изменения
+	ts := msg.GetTs().AsTime()
+	if msg.Edited != nil {
+		ts = msg.GetEdited().AsTime()
+	}
+
+	if !identity.VerifyMessage(msg.GetPublicKey(), signedChannel(name, msg), signedText, kind, ts, msg.GetId(), msg.GetSignature()) {
+		return entities.SignatureInvalid
+	}
+
+	return entities.SignatureValid
 }
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/gbh007/p2p-chat/internal/entities"
+	"github.com/gbh007/p2p-chat/internal/outbox"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var errSendTimeout = errors.New("send timeout")
+
+// Serve - поддержание сессии и отправка очереди исходящих сообщений по порядку, при недоступности
+// сервера отправка повторяется с тем же идентификатором, поэтому дублей не будет
+func (c *ControllerGRPC) Serve() {
+	go c.runSession()
+
+	var delay reconnectDelay
+
+	for {
+		entry, ok := c.outbox.Head()
+		if !ok {
+			<-c.outbox.Notify()
+
+			continue
+		}
+
+		deadline := entry.Created.Add(c.outboxTimeout)
+
+		if time.Now().After(deadline) {
+			c.failEntry(entry, errSendTimeout)
+
+			continue
+		}
+
+		err := c.deliver(deadline, entry)
+
+		switch {
+		case err == nil:
+			delay.reset()
+
+			err = c.outbox.Remove(entry.ID)
+			if err != nil {
+				slog.Error("remove from outbox", "id", entry.ID, "error", err)
+			}
+		case isTransient(err):
+			slog.Warn("send message", "id", entry.ID, "error", err)
+
+			if status.Code(err) == codes.Unauthenticated {
+				err = c.relogin()
+				if err != nil {
+					slog.Warn("relogin", "error", err)
+				}
+			}
+
+			time.Sleep(min(delay.next(), time.Until(deadline)))
+		default:
+			c.failEntry(entry, err)
+		}
+	}
+}
+
+func (c *ControllerGRPC) deliver(deadline time.Time, entry outbox.Entry) error {
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+
+	err := c.sendMessage(ctx, entry)
+	if status.Code(err) == codes.FailedPrecondition {
+		// Ключ канала сменился, повторяем с актуальным
+		err = c.refreshKeys(ctx, entry.Chat)
+		if err == nil {
+			err = c.sendMessage(ctx, entry)
+		}
+	}
+
+	return err
+}
+
+func (c *ControllerGRPC) failEntry(entry outbox.Entry, err error) {
+	slog.Error("message not sent", "id", entry.ID, "chat", entry.Chat, "error", err)
+
+	removeErr := c.outbox.Remove(entry.ID)
+	if removeErr != nil {
+		slog.Error("remove from outbox", "id", entry.ID, "error", removeErr)
+	}
+
+	c.gui.HandleDeliveryState(entry.Chat, entry.ID, entities.DeliveryFailed)
+}
+
+func (c *ControllerGRPC) pendingMessage(entry outbox.Entry) entities.Message {
+	return entities.Message{
+		ID:            entry.ID,
+		Chat:          entry.Chat,
+		User:          c.login,
+		Text:          entry.Text,
+		TS:            entry.Created,
+		IsOwn:         true,
+		IsLocalDomain: true,
+		Delivery:      entities.DeliveryPending,
+		ReplyTo:       entry.ReplyTo,
+		Kind:          entry.Kind,
+	}
+}
+
+// isTransient - ошибки после которых отправку стоит повторить
+func isTransient(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.Aborted, codes.Unauthenticated:
+		return true
+	default:
+		return false
+	}
+}
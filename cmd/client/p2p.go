@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+
+	"github.com/gbh007/p2p-chat/internal/config"
+	"github.com/gbh007/p2p-chat/internal/identity"
+	"github.com/gbh007/p2p-chat/internal/mdns"
+	"github.com/gbh007/p2p-chat/internal/p2p"
+)
+
+var errP2PDisabled = errors.New("p2p mode disabled")
+
+// startNode - запуск локального узла p2p сети, клиент подключается к нему как к обычному серверу.
+// Учетная запись на узле временная, поэтому пароль генерируется при каждом запуске,
+// а ключи авторов закрепляются в хранилище ключей клиента и переживают перезапуск
+func startNode(ctx context.Context, cfg config.Client, keystore *identity.Keystore) (*p2p.Node, config.Client, error) {
+	lis, err := net.Listen("tcp", cfg.P2P.Listen)
+	if err != nil {
+		return nil, cfg, err
+	}
+
+	port := lis.Addr().(*net.TCPAddr).Port
+
+	advertise := cfg.P2P.AdvertiseAddr()
+
+	// При прослушивании порта 0 порт известен только после запуска
+	if _, advertisePort, _ := net.SplitHostPort(advertise); advertisePort == "0" {
+		host, _, _ := net.SplitHostPort(advertise)
+		advertise = net.JoinHostPort(host, strconv.Itoa(port))
+	}
+
+	node := p2p.New(keystore.PrivateKey(), advertise, keystore)
+
+	go func() {
+		err := node.Serve(ctx, lis)
+		if err != nil {
+			slog.Error("p2p node stopped", "error", err)
+		}
+	}()
+
+	for _, addr := range cfg.P2P.Peers {
+		go func() {
+			err := node.Bootstrap(ctx, addr)
+			if err != nil {
+				slog.Warn("bootstrap", "peer", addr, "error", err)
+			}
+		}()
+	}
+
+	if cfg.P2P.MDNS {
+		discovery := mdns.New(node.ID(), port, func(peer mdns.Peer) {
+			node.Discovered(peer.ID, peer.Addr)
+		})
+
+		if cfg.P2P.MDNSInterface != "" {
+			ifi, err := net.InterfaceByName(cfg.P2P.MDNSInterface)
+			if err != nil {
+				return nil, cfg, fmt.Errorf("mdns interface: %w", err)
+			}
+
+			discovery.SetInterface(ifi)
+		}
+
+		go func() {
+			err := discovery.Run(ctx)
+			if err != nil {
+				slog.Warn("mdns stopped", "error", err)
+			}
+		}()
+	}
+
+	password := make([]byte, 16)
+
+	_, err = rand.Read(password)
+	if err != nil {
+		return nil, cfg, err
+	}
+
+	cfg.Server = net.JoinHostPort("localhost", strconv.Itoa(port))
+	cfg.TLS = config.ClientTLS{}
+	cfg.Identity.Password = hex.EncodeToString(password)
+	cfg.Identity.Register = true
+
+	return node, cfg, nil
+}
+
+// ConnectPeer - подключение к узлу выбранному в панели соседей
+func (c *ControllerGRPC) ConnectPeer(addr string) error {
+	if c.node == nil {
+		return errP2PDisabled
+	}
+
+	return c.node.Connect(context.Background(), addr)
+}
+
+// joinChannel - поиск участников канала через DHT, без узла ничего не делает
+func (c *ControllerGRPC) joinChannel(name string) {
+	if c.node == nil {
+		return
+	}
+
+	err := c.node.Join(context.Background(), name)
+	if err != nil {
+		slog.Warn("join channel", "chan", name, "error", err)
+	}
+}
+
+func (c *ControllerGRPC) leaveChannel(name string) {
+	if c.node != nil {
+		c.node.Leave(name)
+	}
+}
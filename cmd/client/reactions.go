@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+
+	"github.com/gbh007/p2p-chat/proto/gen"
+)
+
+// AddReaction - реакция на сообщение, обновленные реакции придут событием подписки
+func (c *ControllerGRPC) AddReaction(chat, id, emoji string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+	defer cancel()
+
+	_, err := c.client.AddReaction(ctx, &gen.AddReactionRequest{
+		Channel: chat,
+		Id:      id,
+		Emoji:   emoji,
+	})
+
+	return err
+}
+
+func (c *ControllerGRPC) RemoveReaction(chat, id, emoji string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+	defer cancel()
+
+	_, err := c.client.RemoveReaction(ctx, &gen.RemoveReactionRequest{
+		Channel: chat,
+		Id:      id,
+		Emoji:   emoji,
+	})
+
+	return err
+}
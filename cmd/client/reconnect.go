@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+
+	"github.com/gbh007/p2p-chat/proto/gen"
+)
+
+const (
+	minReconnectDelay = 500 * time.Millisecond
+	maxReconnectDelay = 30 * time.Second
+	// sendTimeout - ожидание восстановления соединения при отправке
+	sendTimeout = 5 * time.Second
+)
+
+// reconnectDelay - экспоненциальная задержка переподключения со случайным разбросом,
+// чтобы клиенты не переподключались одновременно после перезапуска сервера
+type reconnectDelay struct {
+	delay time.Duration
+}
+
+func (b *reconnectDelay) next() time.Duration {
+	if b.delay == 0 {
+		b.delay = minReconnectDelay
+	} else {
+		b.delay = min(b.delay*2, maxReconnectDelay)
+	}
+
+	return b.delay/2 + rand.N(b.delay/2+1)
+}
+
+func (b *reconnectDelay) reset() {
+	b.delay = 0
+}
+
+func (c *ControllerGRPC) loadInitialHistory(ctx context.Context, name string) (uint64, error) {
+	history, err := c.client.GetHistory(ctx, &gen.GetHistoryRequest{
+		Channel: name,
+		Limit:   uint32(c.pageSize),
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	messages := c.convertMessages(name, history.GetMessages())
+
+	// История уже содержит изменения загруженных сообщений, они не должны прийти повторно
+	var since uint64
+
+	for _, msg := range messages {
+		since = max(since, msg.Position())
+	}
+
+	c.setCursor(name, since)
+
+	if name == inboxChannel {
+		c.handleInboxHistory(messages)
+
+		return since, nil
+	}
+
+	c.setOldest(name, messages)
+	c.gui.HandleHistory(name, messages)
+
+	return since, nil
+}
+
+// cursor - номер последнего полученного сообщения канала, false если история еще не загружалась
+func (c *ControllerGRPC) cursor(name string) (uint64, bool) {
+	c.oldestMutex.Lock()
+	defer c.oldestMutex.Unlock()
+
+	seq, ok := c.cursors[name]
+
+	return seq, ok
+}
+
+func (c *ControllerGRPC) setCursor(name string, seq uint64) {
+	c.oldestMutex.Lock()
+	defer c.oldestMutex.Unlock()
+
+	if current, ok := c.cursors[name]; !ok || seq > current {
+		c.cursors[name] = seq
+	}
+}
+
+func (c *ControllerGRPC) relogin() error {
+	// Регистрация уже выполнена при запуске
+	return c.authenticate(c.password, false)
+}
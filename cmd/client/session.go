@@ -0,0 +1,454 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/gbh007/p2p-chat/internal/entities"
+	"github.com/gbh007/p2p-chat/proto/gen"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// sessionState - общий поток сессии для всех чатов, запросы сопоставляются ответам по request_id
+type sessionState struct {
+	mutex *sync.Mutex
+	// stream - текущий поток, nil пока сессия не установлена
+	stream gen.Server_SessionClient
+	// ctx - контекст текущего потока, отменяется при его обрыве
+	ctx context.Context
+	// sendMutex - отправка в поток не должна выполняться параллельно
+	sendMutex *sync.Mutex
+
+	// channels - чаты к которым подключен пользователь, подписки восстанавливаются после обрыва
+	channels map[string]struct{}
+	pending  map[uint64]chan *gen.ServerFrame
+	nextID   uint64
+
+	// away - статус пользователя, после переподключения сервер считает его в сети
+	away bool
+}
+
+func newSessionState() *sessionState {
+	return &sessionState{
+		mutex:     &sync.Mutex{},
+		sendMutex: &sync.Mutex{},
+		channels:  map[string]struct{}{inboxChannel: {}},
+		pending:   make(map[uint64]chan *gen.ServerFrame),
+	}
+}
+
+// runSession - поддержание сессии с переподключением
+func (c *ControllerGRPC) runSession() {
+	var delay reconnectDelay
+
+	for {
+		online, err := c.sessionOnce()
+		if online {
+			delay.reset()
+		}
+
+		for _, name := range c.joinedChannels() {
+			c.gui.HandleConnectionState(name, entities.ConnectionReconnecting)
+		}
+
+		slog.Warn("session lost", "error", err)
+
+		if status.Code(err) == codes.Unauthenticated {
+			// Сессия могла быть потеряна сервером
+			err = c.relogin()
+			if err != nil {
+				slog.Warn("relogin", "error", err)
+			}
+		}
+
+		time.Sleep(delay.next())
+	}
+}
+
+// sessionOnce - один поток сессии, online если сессия была установлена
+func (c *ControllerGRPC) sessionOnce() (bool, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stream, err := c.client.Session(ctx, grpc.WaitForReady(true))
+	if err != nil {
+		return false, err
+	}
+
+	// Сервер отправляет заголовки после проверки сессии пользователя
+	_, err = stream.Header()
+	if err != nil {
+		return false, err
+	}
+
+	c.setStream(ctx, stream)
+	defer c.setStream(nil, nil)
+
+	for _, name := range c.joinedChannels() {
+		go c.join(ctx, name)
+	}
+
+	for {
+		frame, err := stream.Recv()
+		if err != nil {
+			return true, err
+		}
+
+		c.dispatch(ctx, frame)
+	}
+}
+
+// join - подписка чата в текущей сессии, при ошибке повторяется пока сессия жива
+func (c *ControllerGRPC) join(ctx context.Context, name string) {
+	var delay reconnectDelay
+
+	for ctx.Err() == nil && c.isJoined(name) {
+		err := c.subscribeChannel(ctx, name)
+		if err == nil {
+			c.gui.HandleConnectionState(name, entities.ConnectionOnline)
+
+			return
+		}
+
+		slog.Warn("subscribe", "chan", name, "error", err)
+		c.gui.HandleConnectionState(name, entities.ConnectionReconnecting)
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(delay.next()):
+		}
+	}
+}
+
+func (c *ControllerGRPC) subscribeChannel(ctx context.Context, name string) error {
+	err := c.refreshKeys(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	since, ok := c.cursor(name)
+	if !ok {
+		since, err = c.loadInitialHistory(ctx, name)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = c.request(ctx, &gen.ClientFrame{
+		Frame: &gen.ClientFrame_Subscribe{Subscribe: &gen.SubscribeFrame{
+			Channel: name,
+			Since:   &since,
+		}},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Пользователь мог выйти из чата пока шла подписка
+	if !c.isJoined(name) {
+		return c.writeFrame(&gen.ClientFrame{
+			Frame: &gen.ClientFrame_Unsubscribe{Unsubscribe: &gen.UnsubscribeFrame{Channel: name}},
+		})
+	}
+
+	if c.isAway() {
+		err = c.sendPresence(name, true)
+		if err != nil {
+			return err
+		}
+	}
+
+	if name == inboxChannel {
+		return nil
+	}
+
+	// Изменения после получения списка придут событиями подписки
+	err = c.loadMembers(ctx, name)
+	if err != nil {
+		slog.Warn("list members", "chan", name, "error", err)
+	}
+
+	return nil
+}
+
+func (c *ControllerGRPC) loadMembers(ctx context.Context, name string) error {
+	res, err := c.client.ListMembers(ctx, &gen.ListMembersRequest{Channel: name})
+	if err != nil {
+		return err
+	}
+
+	members := make([]entities.Member, 0, len(res.GetMembers()))
+
+	for _, member := range res.GetMembers() {
+		members = append(members, entities.Member{
+			User:     member.GetLogin(),
+			Presence: presenceFromProto(member.GetStatus()),
+		})
+	}
+
+	c.gui.HandleMembers(name, members)
+
+	return nil
+}
+
+// SetAway - смена статуса пользователя во всех чатах
+func (c *ControllerGRPC) SetAway(away bool) {
+	c.session.mutex.Lock()
+	c.session.away = away
+	c.session.mutex.Unlock()
+
+	// Без сессии статус будет отправлен при подписке
+	err := c.sendPresence("", away)
+	if err != nil {
+		slog.Debug("presence", "error", err)
+	}
+}
+
+// sendPresence - отправка статуса, пустой канал означает все подписки сессии
+func (c *ControllerGRPC) sendPresence(name string, away bool) error {
+	presence := gen.PresenceStatus_PRESENCE_ONLINE
+	if away {
+		presence = gen.PresenceStatus_PRESENCE_AWAY
+	}
+
+	return c.writeFrame(&gen.ClientFrame{
+		Frame: &gen.ClientFrame_Presence{Presence: &gen.PresenceFrame{
+			Channel: name,
+			Status:  presence,
+		}},
+	})
+}
+
+// Typing - уведомление участников чата о наборе сообщения, событие не сохраняется и может потеряться
+func (c *ControllerGRPC) Typing(chat string) {
+	err := c.writeFrame(&gen.ClientFrame{
+		Frame: &gen.ClientFrame_Typing{Typing: &gen.TypingFrame{Channel: chat}},
+	})
+	if err != nil {
+		slog.Debug("typing", "chan", chat, "error", err)
+	}
+}
+
+func (c *ControllerGRPC) isAway() bool {
+	c.session.mutex.Lock()
+	defer c.session.mutex.Unlock()
+
+	return c.session.away
+}
+
+func (c *ControllerGRPC) dispatch(ctx context.Context, frame *gen.ServerFrame) {
+	if frame.GetRequestId() != 0 && c.resolve(frame) {
+		return
+	}
+
+	switch f := frame.GetFrame().(type) {
+	case *gen.ServerFrame_Message:
+		name := f.Message.GetChannel()
+		msg := f.Message.GetMessage()
+
+		if !c.isJoined(name) {
+			return
+		}
+
+		converted := c.convertMessage(name, msg)
+
+		if name == inboxChannel {
+			c.initOldest(converted.Chat, converted.Seq)
+		}
+
+		c.gui.HandleMessage(converted)
+		c.ack(name, msg)
+	case *gen.ServerFrame_Changed:
+		name := f.Changed.GetChannel()
+
+		if c.isJoined(name) {
+			c.gui.HandleMessageChanged(c.convertMessage(name, f.Changed.GetMessage()))
+			c.ack(name, f.Changed.GetMessage())
+		}
+	case *gen.ServerFrame_Error:
+		// Ошибка без ожидающего запроса - сервер прервал подписку, например медленного читателя
+		name := f.Error.GetChannel()
+		if name == "" || !c.isJoined(name) {
+			slog.Warn("session error", "error", f.Error.GetMessage())
+
+			return
+		}
+
+		slog.Warn("subscription lost", "chan", name, "error", f.Error.GetMessage())
+		c.gui.HandleConnectionState(name, entities.ConnectionReconnecting)
+
+		go c.join(ctx, name)
+	case *gen.ServerFrame_Typing:
+		name := f.Typing.GetChannel()
+		if name == inboxChannel {
+			name = entities.DirectChannel(f.Typing.GetLogin())
+		}
+
+		if c.isJoined(f.Typing.GetChannel()) {
+			c.gui.HandleTyping(name, f.Typing.GetLogin())
+		}
+	case *gen.ServerFrame_Presence:
+		c.gui.HandlePresence(f.Presence.GetChannel(), f.Presence.GetLogin(), presenceFromProto(f.Presence.GetStatus()))
+	}
+}
+
+// ack - сдвиг курсора канала и подтверждение серверу, изменения имеют свои номера в последовательности канала
+func (c *ControllerGRPC) ack(name string, msg *gen.ReadMessagesResponse) {
+	seq := max(msg.GetSeq(), msg.GetChangeSeq())
+
+	c.setCursor(name, seq)
+
+	err := c.writeFrame(&gen.ClientFrame{
+		Frame: &gen.ClientFrame_Ack{Ack: &gen.AckFrame{Channel: name, Seq: seq}},
+	})
+	if err != nil {
+		slog.Debug("ack", "chan", name, "error", err)
+	}
+}
+
+// request - отправка кадра и ожидание ответа на него, кадр ошибки возвращается как статус gRPC
+func (c *ControllerGRPC) request(ctx context.Context, frame *gen.ClientFrame) (*gen.ServerFrame, error) {
+	c.session.mutex.Lock()
+	c.session.nextID++
+	id := c.session.nextID
+	reply := make(chan *gen.ServerFrame, 1)
+	c.session.pending[id] = reply
+	c.session.mutex.Unlock()
+
+	defer func() {
+		c.session.mutex.Lock()
+		delete(c.session.pending, id)
+		c.session.mutex.Unlock()
+	}()
+
+	frame.RequestId = id
+
+	err := c.writeFrame(frame)
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, status.FromContextError(ctx.Err()).Err()
+	case res, ok := <-reply:
+		if !ok {
+			return nil, status.Error(codes.Unavailable, "session closed")
+		}
+
+		if e := res.GetError(); e != nil {
+			return nil, status.Error(codes.Code(e.GetCode()), e.GetMessage())
+		}
+
+		return res, nil
+	}
+}
+
+// resolve - передача ответа ожидающему запросу, false если запрос уже не ждет
+func (c *ControllerGRPC) resolve(frame *gen.ServerFrame) bool {
+	c.session.mutex.Lock()
+	defer c.session.mutex.Unlock()
+
+	reply, ok := c.session.pending[frame.GetRequestId()]
+	if !ok {
+		return false
+	}
+
+	delete(c.session.pending, frame.GetRequestId())
+	reply <- frame
+
+	return true
+}
+
+func (c *ControllerGRPC) writeFrame(frame *gen.ClientFrame) error {
+	c.session.mutex.Lock()
+	stream := c.session.stream
+	c.session.mutex.Unlock()
+
+	if stream == nil {
+		return status.Error(codes.Unavailable, "session is not established")
+	}
+
+	c.session.sendMutex.Lock()
+	defer c.session.sendMutex.Unlock()
+
+	return stream.Send(frame)
+}
+
+// setStream - смена текущего потока, при обрыве ожидающие запросы завершаются
+func (c *ControllerGRPC) setStream(ctx context.Context, stream gen.Server_SessionClient) {
+	c.session.mutex.Lock()
+	defer c.session.mutex.Unlock()
+
+	c.session.stream = stream
+	c.session.ctx = ctx
+
+	if stream != nil {
+		return
+	}
+
+	for id, reply := range c.session.pending {
+		close(reply)
+		delete(c.session.pending, id)
+	}
+}
+
+// addChannel - запоминает чат и подписывается на него если сессия установлена
+func (c *ControllerGRPC) addChannel(name string) {
+	c.session.mutex.Lock()
+	c.session.channels[name] = struct{}{}
+	ctx := c.session.ctx
+	c.session.mutex.Unlock()
+
+	if ctx != nil {
+		go c.join(ctx, name)
+	}
+}
+
+func (c *ControllerGRPC) removeChannel(name string) {
+	c.session.mutex.Lock()
+	defer c.session.mutex.Unlock()
+
+	delete(c.session.channels, name)
+}
+
+func (c *ControllerGRPC) isJoined(name string) bool {
+	c.session.mutex.Lock()
+	defer c.session.mutex.Unlock()
+
+	_, ok := c.session.channels[name]
+
+	return ok
+}
+
+func (c *ControllerGRPC) joinedChannels() []string {
+	c.session.mutex.Lock()
+	defer c.session.mutex.Unlock()
+
+	result := make([]string, 0, len(c.session.channels))
+
+	for name := range c.session.channels {
+		result = append(result, name)
+	}
+
+	return result
+}
+
+func presenceFromProto(presence gen.PresenceStatus) entities.PresenceStatus {
+	switch presence {
+	case gen.PresenceStatus_PRESENCE_AWAY:
+		return entities.PresenceAway
+	case gen.PresenceStatus_PRESENCE_LEFT:
+		return entities.PresenceLeft
+	case gen.PresenceStatus_PRESENCE_JOINED:
+		return entities.PresenceJoined
+	case gen.PresenceStatus_PRESENCE_OFFLINE:
+		return entities.PresenceOffline
+	default:
+		return entities.PresenceOnline
+	}
+}
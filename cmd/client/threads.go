@@ -0,0 +1,25 @@
+package main
+
+import (
+	"context"
+
+	"github.com/gbh007/p2p-chat/proto/gen"
+)
+
+// LoadThread - загрузка сообщения и ответов на него, результат передается в интерфейс
+func (c *ControllerGRPC) LoadThread(chat, id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+	defer cancel()
+
+	res, err := c.client.GetThread(ctx, &gen.GetThreadRequest{
+		Channel: chat,
+		Id:      id,
+	})
+	if err != nil {
+		return err
+	}
+
+	c.gui.HandleThread(chat, c.convertMessage(chat, res.GetRoot()), c.convertMessages(chat, res.GetReplies()))
+
+	return nil
+}
@@ -2,16 +2,64 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
 	"net"
+	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 
+	"github.com/gbh007/p2p-chat/internal/auth"
+	"github.com/gbh007/p2p-chat/internal/certs"
+	"github.com/gbh007/p2p-chat/internal/config"
+	"github.com/gbh007/p2p-chat/internal/federation"
+	"github.com/gbh007/p2p-chat/internal/identity"
 	"github.com/gbh007/p2p-chat/internal/server"
+	"github.com/gbh007/p2p-chat/internal/storage"
 	"github.com/gbh007/p2p-chat/proto/gen"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
 )
 
+type store interface {
+	server.MessageStore
+	server.KeyStore
+	auth.Store
+	Close() error
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "gen-certs" {
+		err := genCerts(os.Args[2:])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		return
+	}
+
+	cfg, err := config.LoadServer(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+
+	if err == nil {
+		err = cfg.Validate()
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "invalid configuration:")
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	slog.SetLogLoggerLevel(config.LogLevel(cfg.LogLevel))
+
 	ctx, cancel := signal.NotifyContext(
 		context.Background(),
 		syscall.SIGHUP,
@@ -21,23 +69,111 @@ func main() {
 	)
 	defer cancel()
 
-	err := Serve(ctx)
+	err = Serve(ctx, cfg)
 	if err != nil {
 		panic(err)
 	}
 }
 
-func Serve(ctx context.Context) error {
-	lis, err := net.Listen("tcp", ":8080")
+func genCerts(args []string) error {
+	fs := flag.NewFlagSet("gen-certs", flag.ExitOnError)
+	dir := fs.String("dir", "certs", "output directory")
+	hosts := fs.String("hosts", "localhost,127.0.0.1", "comma separated server host names and IPs")
+	clients := fs.String("clients", "", "comma separated client logins for mTLS")
+
+	err := fs.Parse(args)
 	if err != nil {
 		return err
 	}
 
-	s := server.New()
+	return certs.Generate(*dir, splitList(*hosts), splitList(*clients))
+}
+
+func splitList(s string) []string {
+	var result []string
 
-	grpcServer := grpc.NewServer()
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v != "" {
+			result = append(result, v)
+		}
+	}
+
+	return result
+}
+
+func Serve(ctx context.Context, cfg config.Server) error {
+	opts := []grpc.ServerOption{
+		grpc.MaxRecvMsgSize(cfg.Limits.MaxMessageSize),
+	}
+
+	if cfg.TLS.Cert != "" {
+		clientAuth, err := certs.ParseClientAuth(cfg.TLS.ClientAuth)
+		if err != nil {
+			return err
+		}
+
+		tlsCfg, err := certs.ServerConfig(cfg.TLS.Cert, cfg.TLS.Key, cfg.TLS.CA, clientAuth)
+		if err != nil {
+			return err
+		}
+
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsCfg)))
+	}
+
+	lis, err := net.Listen("tcp", cfg.Addr)
+	if err != nil {
+		return err
+	}
+
+	store, err := openStore(cfg.Storage)
+	if err != nil {
+		return err
+	}
+
+	defer store.Close()
+
+	accounts := auth.New(store)
+	s := server.New(store, store, accounts, cfg.Limits.Server())
+	s.SetModerators(cfg.Moderators)
+
+	publicMethods := []string{
+		gen.Server_Register_FullMethodName,
+		gen.Server_Login_FullMethodName,
+	}
+
+	var fed *federation.Federation
+
+	// Серверы федерации проверяются по подписи запросов, а не по сессии пользователя
+	if cfg.Federation.Domain != "" {
+		fed, err = startFederation(cfg, s)
+		if err != nil {
+			return err
+		}
+
+		defer fed.Close()
+
+		publicMethods = append(
+			publicMethods,
+			gen.Federation_Forward_FullMethodName,
+			gen.Federation_Subscribe_FullMethodName,
+			gen.Federation_Identify_FullMethodName,
+		)
+	}
+
+	opts = append(
+		opts,
+		grpc.ChainUnaryInterceptor(auth.UnaryServerInterceptor(accounts, publicMethods...)),
+		grpc.ChainStreamInterceptor(auth.StreamServerInterceptor(accounts, publicMethods...)),
+	)
+
+	grpcServer := grpc.NewServer(opts...)
 	gen.RegisterServerServer(grpcServer, s)
 
+	if fed != nil {
+		gen.RegisterFederationServer(grpcServer, fed)
+	}
+
 	go func() {
 		<-ctx.Done()
 		grpcServer.GracefulStop()
@@ -50,3 +186,34 @@ func Serve(ctx context.Context) error {
 
 	return nil
 }
+
+func startFederation(cfg config.Server, s *server.Server) (*federation.Federation, error) {
+	keystore, err := identity.Open(cfg.FederationKeystore())
+	if err != nil {
+		return nil, err
+	}
+
+	transport := insecure.NewCredentials()
+
+	// С включенным TLS другие серверы федерации проверяются тем же CA
+	if cfg.TLS.Cert != "" {
+		tlsCfg, err := certs.ClientConfig("", "", cfg.TLS.CA, "")
+		if err != nil {
+			return nil, err
+		}
+
+		transport = credentials.NewTLS(tlsCfg)
+	}
+
+	slog.Info("federation enabled", "domain", cfg.Federation.Domain, "key", identity.Fingerprint(keystore.PublicKey()))
+
+	return federation.New(cfg.Federation.Domain, keystore, s, cfg.Federation.Addrs(), cfg.Federation.Open, transport), nil
+}
+
+func openStore(cfg config.Storage) (store, error) {
+	if cfg.Backend == config.StorageMemory {
+		return storage.NewMemory(cfg.MemorySize), nil
+	}
+
+	return storage.NewFile(cfg.Dir)
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gbh007/p2p-chat/internal/auth"
+	"github.com/gbh007/p2p-chat/internal/entities"
+	"github.com/gbh007/p2p-chat/internal/server"
+	"github.com/gbh007/p2p-chat/internal/storage"
+	"github.com/gbh007/p2p-chat/proto/gen"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+const channel = "stress"
+
+// trustAccounts - токен считается логином, регистрация тысяч пользователей
+// с хешированием паролей измеряла бы не то
+type trustAccounts struct{}
+
+func (trustAccounts) Authenticate(_ context.Context, token string) (string, error) {
+	return token, nil
+}
+
+func (trustAccounts) Register(context.Context, string, string, []byte) (string, time.Time, error) {
+	return "", time.Time{}, errors.ErrUnsupported
+}
+
+func (trustAccounts) Login(context.Context, string, string) (string, time.Time, error) {
+	return "", time.Time{}, errors.ErrUnsupported
+}
+
+func (trustAccounts) PublicKey(context.Context, string) ([]byte, error) {
+	return nil, nil
+}
+
+func (trustAccounts) User(context.Context, string) (entities.User, error) {
+	return entities.User{}, entities.ErrNotFound
+}
+
+func (trustAccounts) SetEncryptionKey(context.Context, string, []byte, []byte) error {
+	return errors.ErrUnsupported
+}
+
+type readerResult struct {
+	slow     bool
+	received int
+	gaps     int
+	// lastSeq - номер последнего полученного сообщения
+	lastSeq uint64
+	code    codes.Code
+}
+
+type options struct {
+	readers  int
+	slow     float64
+	messages int
+	size     int
+	policy   server.OverflowPolicy
+}
+
+type report struct {
+	slowCount    int
+	sendDuration time.Duration
+	// latencies - время отправки сообщений, отсортировано по возрастанию
+	latencies []time.Duration
+	results   []readerResult
+}
+
+func main() {
+	readersCount := flag.Int("readers", 2000, "readers count")
+	slowPart := flag.Float64("slow", 0.1, "part of readers that stop reading")
+	messagesCount := flag.Int("messages", 500, "messages count")
+	messageSize := flag.Int("size", 1024, "message size in bytes")
+	policyName := flag.String("policy", string(server.OverflowSpill), "overflow policy: drop-oldest, disconnect, spill")
+	flag.Parse()
+
+	// Журнал подключений тысяч читателей только мешает
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError})))
+
+	policy, err := server.ParseOverflowPolicy(*policyName)
+	if err != nil {
+		panic(err)
+	}
+
+	opts := options{
+		readers:  *readersCount,
+		slow:     *slowPart,
+		messages: *messagesCount,
+		size:     *messageSize,
+		policy:   policy,
+	}
+
+	rep, err := run(opts)
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Printf("policy: %s, readers: %d (slow %d), messages: %d\n", policy, opts.readers, rep.slowCount, opts.messages)
+	fmt.Printf("send: total %s, p50 %s, p99 %s, max %s\n",
+		rep.sendDuration,
+		rep.latencies[len(rep.latencies)/2],
+		rep.latencies[len(rep.latencies)*99/100],
+		rep.latencies[len(rep.latencies)-1],
+	)
+
+	printSummary("fast", rep.results, false, opts.messages)
+	printSummary("slow", rep.results, true, opts.messages)
+}
+
+// run - сервер в памяти, читатели канала и отправка сообщений, медленные читатели
+// получают первое сообщение и продолжают чтение только после отправки всех сообщений
+func run(opts options) (report, error) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return report{}, err
+	}
+
+	grpcServer := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(auth.UnaryServerInterceptor(trustAccounts{})),
+		grpc.ChainStreamInterceptor(auth.StreamServerInterceptor(trustAccounts{})),
+	)
+
+	store := storage.NewMemory(opts.messages)
+	limits := server.DefaultLimits()
+	limits.OverflowPolicy = opts.policy
+
+	gen.RegisterServerServer(grpcServer, server.New(store, store, trustAccounts{}, limits))
+
+	go func() {
+		_ = grpcServer.Serve(lis)
+	}()
+
+	defer grpcServer.Stop()
+
+	conn, err := grpc.NewClient(
+		lis.Addr().String(),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		// Фиксированное окно отключает динамическое расширение буфера,
+		// иначе клиент поглощает весь поток и сервер не видит медленного читателя
+		grpc.WithInitialWindowSize(64*1024),
+	)
+	if err != nil {
+		return report{}, err
+	}
+
+	defer conn.Close()
+
+	client := gen.NewServerClient(conn)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rep := report{
+		slowCount: int(float64(opts.readers) * opts.slow),
+		latencies: make([]time.Duration, 0, opts.messages),
+		results:   make([]readerResult, opts.readers),
+	}
+
+	wakeUp := make(chan struct{})
+	ready := &sync.WaitGroup{}
+	done := &sync.WaitGroup{}
+
+	for i := range opts.readers {
+		slow := i < rep.slowCount
+
+		stream, err := client.ReadMessages(ctx, &gen.ReadMessagesRequest{
+			Channel: channel,
+		}, asUser("reader-"+strconv.Itoa(i)))
+		if err != nil {
+			return report{}, fmt.Errorf("read messages: %w", err)
+		}
+
+		ready.Add(1)
+		done.Add(1)
+
+		go func() {
+			defer done.Done()
+
+			rep.results[i] = read(stream, slow, opts.messages, ready, wakeUp)
+		}()
+	}
+
+	ready.Wait()
+
+	text := strings.Repeat("x", opts.size)
+	start := time.Now()
+
+	for range opts.messages {
+		sendStart := time.Now()
+
+		_, err := client.SendMessage(ctx, &gen.SendMessageRequest{
+			Channel: channel,
+			Message: text,
+		}, asUser("writer"))
+		if err != nil {
+			// Читатели завершатся с отменой контекста
+			close(wakeUp)
+
+			return report{}, fmt.Errorf("send message: %w", err)
+		}
+
+		rep.latencies = append(rep.latencies, time.Since(sendStart))
+	}
+
+	rep.sendDuration = time.Since(start)
+
+	// Медленные читатели продолжают чтение, чтобы проверить как они догоняют поток
+	close(wakeUp)
+	done.Wait()
+
+	slices.Sort(rep.latencies)
+
+	return rep, nil
+}
+
+func asUser(login string) grpc.CallOption {
+	creds := auth.NewTokenCredentials()
+	creds.SetToken(login)
+
+	return grpc.PerRPCCredentials(creds)
+}
+
+func read(
+	stream grpc.ServerStreamingClient[gen.ReadMessagesResponse],
+	slow bool,
+	total int,
+	ready *sync.WaitGroup,
+	wakeUp chan struct{},
+) readerResult {
+	result := readerResult{slow: slow, code: codes.OK}
+
+	// Заголовки приходят после регистрации читателя на сервере
+	_, err := stream.Header()
+	ready.Done()
+
+	if err != nil {
+		result.code = status.Code(err)
+
+		return result
+	}
+
+	for result.received < total {
+		if slow && result.received == 1 {
+			<-wakeUp
+		}
+
+		msg, err := stream.Recv()
+		if err != nil {
+			result.code = status.Code(err)
+
+			break
+		}
+
+		if msg.GetSeq() != result.lastSeq+1 && result.lastSeq != 0 {
+			result.gaps++
+		}
+
+		result.lastSeq = msg.GetSeq()
+		result.received++
+
+		// Последнее сообщение получено, дальше ждать нечего
+		if result.lastSeq == uint64(total) {
+			break
+		}
+	}
+
+	return result
+}
+
+func printSummary(name string, results []readerResult, slow bool, total int) {
+	var (
+		count, complete, gaps int
+		codesCount            = make(map[codes.Code]int)
+	)
+
+	for _, r := range results {
+		if r.slow != slow {
+			continue
+		}
+
+		count++
+
+		if r.received == total {
+			complete++
+		}
+
+		gaps += r.gaps
+		codesCount[r.code]++
+	}
+
+	fmt.Printf("%s readers: %d, complete: %d, gaps: %d, statuses: %v\n", name, count, complete, gaps, codesCount)
+}
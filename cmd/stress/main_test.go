@@ -0,0 +1,84 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"testing"
+
+	"github.com/gbh007/p2p-chat/internal/server"
+	"google.golang.org/grpc/codes"
+)
+
+func TestOverflowPolicies(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts thousands of readers")
+	}
+
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError})))
+
+	tests := []struct {
+		policy server.OverflowPolicy
+		// slow - проверка медленного читателя, быстрые читатели при любой политике получают все сообщения
+		slow func(t *testing.T, r readerResult, total int)
+	}{
+		{
+			policy: server.OverflowSpill,
+			slow: func(t *testing.T, r readerResult, total int) {
+				if r.code != codes.OK || r.received != total || r.gaps != 0 {
+					t.Fatalf("slow reader must catch up from history: %+v", r)
+				}
+			},
+		},
+		{
+			policy: server.OverflowDropOldest,
+			slow: func(t *testing.T, r readerResult, total int) {
+				if r.code != codes.OK || r.gaps == 0 || r.lastSeq != uint64(total) {
+					t.Fatalf("slow reader must lose old messages and get the last one: %+v", r)
+				}
+			},
+		},
+		{
+			policy: server.OverflowDisconnect,
+			slow: func(t *testing.T, r readerResult, _ int) {
+				if r.code != codes.ResourceExhausted {
+					t.Fatalf("slow reader must be disconnected: %+v", r)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			// Буфер читателя и окна HTTP/2 вмещают заметно меньше 200 сообщений по 4 КБ,
+			// поэтому медленные читатели гарантированно переполняются
+			opts := options{
+				readers:  2000,
+				slow:     0.1,
+				messages: 200,
+				size:     4096,
+				policy:   tt.policy,
+			}
+
+			rep, err := run(opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if rep.slowCount == 0 {
+				t.Fatal("no slow readers")
+			}
+
+			for _, r := range rep.results {
+				if r.slow {
+					tt.slow(t, r, opts.messages)
+
+					continue
+				}
+
+				if r.code != codes.OK || r.received != opts.messages || r.gaps != 0 {
+					t.Fatalf("fast reader must receive every message: %+v", r)
+				}
+			}
+		})
+	}
+}
@@ -3,18 +3,21 @@ module github.com/gbh007/p2p-chat
 go 1.23.6
 
 require (
+	github.com/BurntSushi/toml v1.6.0
 	github.com/awesome-gocui/gocui v1.1.0
+	github.com/mattn/go-runewidth v0.0.10
+	golang.org/x/crypto v0.32.0
+	golang.org/x/net v0.34.0
 	google.golang.org/grpc v1.71.0
 	google.golang.org/protobuf v1.36.5
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
 	github.com/gdamore/encoding v1.0.0 // indirect
 	github.com/gdamore/tcell/v2 v2.4.0 // indirect
 	github.com/lucasb-eyer/go-colorful v1.0.3 // indirect
-	github.com/mattn/go-runewidth v0.0.10 // indirect
 	github.com/rivo/uniseg v0.1.0 // indirect
-	golang.org/x/net v0.34.0 // indirect
 	golang.org/x/sys v0.29.0 // indirect
 	golang.org/x/term v0.28.0 // indirect
 	golang.org/x/text v0.21.0 // indirect
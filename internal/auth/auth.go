@@ -0,0 +1,214 @@
+package auth
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gbh007/p2p-chat/internal/entities"
+	"github.com/gbh007/p2p-chat/internal/identity"
+)
+
+const (
+	sessionTTL     = 7 * 24 * time.Hour
+	tokenLen       = 32
+	maxLoginLen    = 64
+	minPasswordLen = 8
+
+	encryptionKeySize = 32
+)
+
+var (
+	ErrInvalidLogin       = errors.New("invalid login")
+	ErrInvalidPublicKey   = errors.New("invalid public key")
+	ErrInvalidSignature   = errors.New("invalid signature")
+	ErrMissingIdentity    = errors.New("user has no identity key")
+	ErrWeakPassword       = fmt.Errorf("password must be at least %d characters", minPasswordLen)
+	ErrInvalidCredentials = errors.New("invalid login or password")
+	ErrUnauthenticated    = errors.New("unauthenticated")
+)
+
+type Store interface {
+	AddUser(ctx context.Context, user entities.User) error
+	GetUser(ctx context.Context, login string) (entities.User, error)
+	SetEncryptionKey(ctx context.Context, login string, key, signature []byte) error
+	AddSession(ctx context.Context, session entities.Session) error
+	GetSession(ctx context.Context, tokenHash string) (entities.Session, error)
+}
+
+type Service struct {
+	store Store
+}
+
+func New(store Store) *Service {
+	return &Service{
+		store: store,
+	}
+}
+
+// Register - создает пользователя, публичный ключ необязателен,
+// но после привязки все сообщения пользователя должны быть подписаны им
+func (s *Service) Register(ctx context.Context, login, password string, publicKey []byte) (string, time.Time, error) {
+	err := ValidateLogin(login)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	if len(password) < minPasswordLen {
+		return "", time.Time{}, ErrWeakPassword
+	}
+
+	if len(publicKey) > 0 && len(publicKey) != ed25519.PublicKeySize {
+		return "", time.Time{}, ErrInvalidPublicKey
+	}
+
+	hash, err := hashPassword(password)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	err = s.store.AddUser(ctx, entities.User{
+		Login:        login,
+		PasswordHash: hash,
+		PublicKey:    publicKey,
+		CreatedAt:    time.Now(),
+	})
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("add user: %w", err)
+	}
+
+	return s.newSession(ctx, login)
+}
+
+func (s *Service) Login(ctx context.Context, login, password string) (string, time.Time, error) {
+	user, err := s.store.GetUser(ctx, login)
+	if errors.Is(err, entities.ErrNotFound) {
+		return "", time.Time{}, ErrInvalidCredentials
+	}
+
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("get user: %w", err)
+	}
+
+	ok, err := checkPassword(password, user.PasswordHash)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("check password: %w", err)
+	}
+
+	if !ok {
+		return "", time.Time{}, ErrInvalidCredentials
+	}
+
+	return s.newSession(ctx, login)
+}
+
+func (s *Service) PublicKey(ctx context.Context, login string) ([]byte, error) {
+	user, err := s.store.GetUser(ctx, login)
+	if err != nil {
+		return nil, fmt.Errorf("get user: %w", err)
+	}
+
+	return user.PublicKey, nil
+}
+
+func (s *Service) User(ctx context.Context, login string) (entities.User, error) {
+	user, err := s.store.GetUser(ctx, login)
+	if err != nil {
+		return entities.User{}, fmt.Errorf("get user: %w", err)
+	}
+
+	return user, nil
+}
+
+// SetEncryptionKey - публикует ключ шифрования, подпись проверяется ключом пользователя
+func (s *Service) SetEncryptionKey(ctx context.Context, login string, key, signature []byte) error {
+	if len(key) != encryptionKeySize {
+		return ErrInvalidPublicKey
+	}
+
+	user, err := s.store.GetUser(ctx, login)
+	if err != nil {
+		return fmt.Errorf("get user: %w", err)
+	}
+
+	if len(user.PublicKey) == 0 {
+		return ErrMissingIdentity
+	}
+
+	if !identity.VerifyEncryptionKey(user.PublicKey, login, key, signature) {
+		return ErrInvalidSignature
+	}
+
+	err = s.store.SetEncryptionKey(ctx, login, key, signature)
+	if err != nil {
+		return fmt.Errorf("set encryption key: %w", err)
+	}
+
+	return nil
+}
+
+// Authenticate - возвращает логин владельца токена
+func (s *Service) Authenticate(ctx context.Context, token string) (string, error) {
+	session, err := s.store.GetSession(ctx, hashToken(token))
+	if errors.Is(err, entities.ErrNotFound) {
+		return "", ErrUnauthenticated
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("get session: %w", err)
+	}
+
+	if time.Now().After(session.ExpiresAt) {
+		return "", ErrUnauthenticated
+	}
+
+	return session.Login, nil
+}
+
+func (s *Service) newSession(ctx context.Context, login string) (string, time.Time, error) {
+	raw := make([]byte, tokenLen)
+
+	_, err := rand.Read(raw)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("token: %w", err)
+	}
+
+	token := hex.EncodeToString(raw)
+	expiresAt := time.Now().Add(sessionTTL)
+
+	err = s.store.AddSession(ctx, entities.Session{
+		TokenHash: hashToken(token),
+		Login:     login,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("add session: %w", err)
+	}
+
+	return token, expiresAt, nil
+}
+
+func ValidateLogin(login string) error {
+	if login == "" || len(login) > maxLoginLen {
+		return ErrInvalidLogin
+	}
+
+	// @ зарезервирован для указания домена
+	if strings.ContainsAny(login, "@ \t\n") {
+		return ErrInvalidLogin
+	}
+
+	return nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,115 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gbh007/p2p-chat/internal/entities"
+	"github.com/gbh007/p2p-chat/internal/storage"
+)
+
+func TestPasswordHash(t *testing.T) {
+	hash, err := hashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(hash, "$argon2id$") || strings.Contains(hash, "correct horse") {
+		t.Fatalf("unexpected hash: %s", hash)
+	}
+
+	other, err := hashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if other == hash {
+		t.Fatal("hashes of one password must differ by salt")
+	}
+
+	for password, want := range map[string]bool{"correct horse": true, "wrong horse": false, "": false} {
+		ok, err := checkPassword(password, hash)
+		if err != nil || ok != want {
+			t.Fatalf("check %q: %v %v", password, ok, err)
+		}
+	}
+
+	_, err = checkPassword("correct horse", "$bcrypt$hash")
+	if !errors.Is(err, errInvalidHash) {
+		t.Fatalf("unknown hash format must be rejected: %v", err)
+	}
+}
+
+func TestRegisterAndLogin(t *testing.T) {
+	ctx := context.Background()
+	s := New(storage.NewMemory(10))
+
+	token, expiresAt, err := s.Register(ctx, "alice", "password", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if token == "" || expiresAt.Before(time.Now()) {
+		t.Fatalf("unexpected session: %q %v", token, expiresAt)
+	}
+
+	errs := map[string]struct {
+		login, password string
+		publicKey       []byte
+		err             error
+	}{
+		"duplicate":    {login: "alice", password: "password", err: entities.ErrAlreadyExists},
+		"weak":         {login: "bob", password: "short", err: ErrWeakPassword},
+		"domain login": {login: "bob@host", password: "password", err: ErrInvalidLogin},
+		"empty login":  {login: "", password: "password", err: ErrInvalidLogin},
+		"key size":     {login: "bob", password: "password", publicKey: []byte("short"), err: ErrInvalidPublicKey},
+	}
+
+	for name, tt := range errs {
+		_, _, err := s.Register(ctx, tt.login, tt.password, tt.publicKey)
+		if !errors.Is(err, tt.err) {
+			t.Fatalf("%s: expected %v, got %v", name, tt.err, err)
+		}
+	}
+
+	for _, tt := range []struct{ login, password string }{{"alice", "wrong password"}, {"nobody", "password"}} {
+		_, _, err := s.Login(ctx, tt.login, tt.password)
+		if !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("login %s: expected invalid credentials, got %v", tt.login, err)
+		}
+	}
+
+	second, _, err := s.Login(ctx, "alice", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Сессии независимы, каждый токен определяет владельца
+	for _, tok := range []string{token, second} {
+		login, err := s.Authenticate(ctx, tok)
+		if err != nil || login != "alice" {
+			t.Fatalf("authenticate: %q %v", login, err)
+		}
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemory(10)
+	s := New(store)
+
+	err := store.AddSession(ctx, entities.Session{TokenHash: hashToken("expired"), Login: "alice", ExpiresAt: time.Now().Add(-time.Second)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, token := range []string{"expired", "unknown", ""} {
+		_, err := s.Authenticate(ctx, token)
+		if !errors.Is(err, ErrUnauthenticated) {
+			t.Fatalf("token %q: expected unauthenticated, got %v", token, err)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	authorizationHeader = "authorization"
+	bearerPrefix        = "Bearer "
+)
+
+type loginKey struct{}
+
+type Authenticator interface {
+	Authenticate(ctx context.Context, token string) (string, error)
+}
+
+func WithLogin(ctx context.Context, login string) context.Context {
+	return context.WithValue(ctx, loginKey{}, login)
+}
+
+func LoginFromContext(ctx context.Context) (string, bool) {
+	login, ok := ctx.Value(loginKey{}).(string)
+
+	return login, ok
+}
+
+// UnaryServerInterceptor - проверяет токен сессии или клиентский сертификат,
+// методы из public доступны без них
+func UnaryServerInterceptor(a Authenticator, public ...string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if slices.Contains(public, info.FullMethod) {
+			return handler(withCertLogin(ctx), req)
+		}
+
+		ctx, err := authenticate(ctx, a)
+		if err != nil {
+			return nil, err
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+func StreamServerInterceptor(a Authenticator, public ...string) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if slices.Contains(public, info.FullMethod) {
+			return handler(srv, &authStream{ServerStream: ss, ctx: withCertLogin(ss.Context())})
+		}
+
+		ctx, err := authenticate(ss.Context(), a)
+		if err != nil {
+			return err
+		}
+
+		return handler(srv, &authStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+func authenticate(ctx context.Context, a Authenticator) (context.Context, error) {
+	// Проверенный клиентский сертификат надежнее токена, сессия не нужна
+	if login, ok := CertLogin(ctx); ok {
+		return WithLogin(ctx, login), nil
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	values := md.Get(authorizationHeader)
+	if len(values) == 0 || !strings.HasPrefix(values[0], bearerPrefix) {
+		return nil, status.Error(codes.Unauthenticated, "missing token")
+	}
+
+	login, err := a.Authenticate(ctx, strings.TrimPrefix(values[0], bearerPrefix))
+	if errors.Is(err, ErrUnauthenticated) {
+		return nil, status.Error(codes.Unauthenticated, "invalid token")
+	}
+
+	if err != nil {
+		return nil, status.Error(codes.Internal, "authenticate")
+	}
+
+	return WithLogin(ctx, login), nil
+}
+
+// CertLogin - логин из CN проверенного клиентского сертификата (mTLS)
+func CertLogin(ctx context.Context) (string, bool) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return "", false
+	}
+
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 || len(info.State.VerifiedChains[0]) == 0 {
+		return "", false
+	}
+
+	login := info.State.VerifiedChains[0][0].Subject.CommonName
+
+	if ValidateLogin(login) != nil {
+		return "", false
+	}
+
+	return login, true
+}
+
+// withCertLogin - для публичных методов сертификат необязателен,
+// но если он есть то действия возможны только от его имени
+func withCertLogin(ctx context.Context) context.Context {
+	login, ok := CertLogin(ctx)
+	if !ok {
+		return ctx
+	}
+
+	return WithLogin(ctx, login)
+}
+
+type authStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authStream) Context() context.Context {
+	return s.ctx
+}
+
+// TokenCredentials - клиентская передача токена сессии в метаданных,
+// пока токен не задан запросы уходят без него
+type TokenCredentials struct {
+	token string
+	mutex *sync.RWMutex
+}
+
+func NewTokenCredentials() *TokenCredentials {
+	return &TokenCredentials{
+		mutex: &sync.RWMutex{},
+	}
+}
+
+func (c *TokenCredentials) SetToken(token string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.token = token
+}
+
+func (c *TokenCredentials) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	if c.token == "" {
+		return nil, nil
+	}
+
+	return map[string]string{
+		authorizationHeader: bearerPrefix + c.token,
+	}, nil
+}
+
+func (c *TokenCredentials) RequireTransportSecurity() bool {
+	return false
+}
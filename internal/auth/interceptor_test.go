@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type tokens map[string]string
+
+func (t tokens) Authenticate(_ context.Context, token string) (string, error) {
+	login, ok := t[token]
+	if !ok {
+		return "", ErrUnauthenticated
+	}
+
+	return login, nil
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	interceptor := UnaryServerInterceptor(tokens{"token": "alice"}, "/public")
+
+	call := func(method, authorization string) (string, error) {
+		ctx := context.Background()
+		if authorization != "" {
+			ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(authorizationHeader, authorization))
+		}
+
+		res, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method}, func(ctx context.Context, _ any) (any, error) {
+			login, _ := LoginFromContext(ctx)
+
+			return login, nil
+		})
+		if err != nil {
+			return "", err
+		}
+
+		return res.(string), nil
+	}
+
+	tests := []struct {
+		name          string
+		method        string
+		authorization string
+		login         string
+		code          codes.Code
+	}{
+		{name: "valid token", method: "/private", authorization: "Bearer token", login: "alice"},
+		{name: "missing token", method: "/private", code: codes.Unauthenticated},
+		{name: "invalid token", method: "/private", authorization: "Bearer other", code: codes.Unauthenticated},
+		{name: "not bearer", method: "/private", authorization: "token", code: codes.Unauthenticated},
+		{name: "public", method: "/public"},
+	}
+
+	for _, tt := range tests {
+		login, err := call(tt.method, tt.authorization)
+		if status.Code(err) != tt.code || login != tt.login {
+			t.Fatalf("%s: unexpected result %q %v", tt.name, login, err)
+		}
+	}
+}
+
+func TestTokenCredentials(t *testing.T) {
+	c := NewTokenCredentials()
+
+	md, err := c.GetRequestMetadata(context.Background())
+	if err != nil || md != nil {
+		t.Fatalf("no token must produce no metadata: %v %v", md, err)
+	}
+
+	c.SetToken("token")
+
+	md, err = c.GetRequestMetadata(context.Background())
+	if err != nil || md[authorizationHeader] != "Bearer token" {
+		t.Fatalf("unexpected metadata: %v %v", md, err)
+	}
+}
@@ -0,0 +1,218 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	pemCertificate = "CERTIFICATE"
+	pemPrivateKey  = "PRIVATE KEY"
+
+	caValidity   = 10 * 365 * 24 * time.Hour
+	leafValidity = 365 * 24 * time.Hour
+
+	CAName     = "ca"
+	ServerName = "server"
+)
+
+type issuer struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// Generate - локальный самоподписанный CA для разработки, сертификат сервера
+// на hosts и клиентские сертификаты, CN которых становится логином при mTLS.
+// Существующий CA в dir переиспользуется, чтобы выпускать новые клиентские сертификаты
+func Generate(dir string, hosts, clients []string) error {
+	err := os.MkdirAll(dir, 0o700)
+	if err != nil {
+		return err
+	}
+
+	ca, err := loadIssuer(dir)
+	if err != nil {
+		return err
+	}
+
+	if ca == nil {
+		ca, err = newCA(dir)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(hosts) > 0 {
+		template := leafTemplate(hosts[0], x509.ExtKeyUsageServerAuth)
+
+		for _, host := range hosts {
+			if ip := net.ParseIP(host); ip != nil {
+				template.IPAddresses = append(template.IPAddresses, ip)
+			} else {
+				template.DNSNames = append(template.DNSNames, host)
+			}
+		}
+
+		err = issue(dir, ServerName, template, ca)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, login := range clients {
+		if login == CAName || login == ServerName || filepath.Base(login) != login {
+			return fmt.Errorf("invalid client name %q", login)
+		}
+
+		err = issue(dir, login, leafTemplate(login, x509.ExtKeyUsageClientAuth), ca)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func newCA(dir string) (*issuer, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	serial, err := serialNumber()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "p2p-chat development CA"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, fmt.Errorf("create CA: %w", err)
+	}
+
+	err = writePair(dir, CAName, der, key)
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	return &issuer{cert: cert, key: key}, nil
+}
+
+// loadIssuer - загрузка ранее созданного CA, nil если его нет
+func loadIssuer(dir string) (*issuer, error) {
+	certData, err := os.ReadFile(filepath.Join(dir, CAName+".crt"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	keyData, err := os.ReadFile(filepath.Join(dir, CAName+".key"))
+	if err != nil {
+		return nil, err
+	}
+
+	certBlock, _ := pem.Decode(certData)
+	keyBlock, _ := pem.Decode(keyData)
+
+	if certBlock == nil || keyBlock == nil {
+		return nil, fmt.Errorf("%s: invalid CA files", dir)
+	}
+
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse CA: %w", err)
+	}
+
+	rawKey, err := x509.ParsePKCS8PrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse CA key: %w", err)
+	}
+
+	key, ok := rawKey.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: unsupported CA key", dir)
+	}
+
+	return &issuer{cert: cert, key: key}, nil
+}
+
+func leafTemplate(commonName string, usage x509.ExtKeyUsage) *x509.Certificate {
+	now := time.Now()
+
+	return &x509.Certificate{
+		Subject:     pkix.Name{CommonName: commonName},
+		NotBefore:   now.Add(-time.Hour),
+		NotAfter:    now.Add(leafValidity),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{usage},
+	}
+}
+
+func issue(dir, name string, template *x509.Certificate, ca *issuer) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	template.SerialNumber, err = serialNumber()
+	if err != nil {
+		return err
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		return fmt.Errorf("create %s certificate: %w", name, err)
+	}
+
+	return writePair(dir, name, der, key)
+}
+
+func writePair(dir, name string, der []byte, key *ecdsa.PrivateKey) error {
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	err = writePEM(filepath.Join(dir, name+".crt"), pemCertificate, der, 0o644)
+	if err != nil {
+		return err
+	}
+
+	return writePEM(filepath.Join(dir, name+".key"), pemPrivateKey, keyDER, 0o600)
+}
+
+func writePEM(path, blockType string, der []byte, perm os.FileMode) error {
+	return os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), perm)
+}
+
+func serialNumber() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
@@ -0,0 +1,130 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+)
+
+type ClientAuth string
+
+const (
+	// ClientAuthNone - сертификат клиента не запрашивается
+	ClientAuthNone ClientAuth = "none"
+	// ClientAuthVerify - сертификат проверяется если клиент его предъявил
+	ClientAuthVerify ClientAuth = "verify"
+	// ClientAuthRequire - mTLS, без сертификата подключиться нельзя
+	ClientAuthRequire ClientAuth = "require"
+)
+
+var ErrNoCA = errors.New("client certificate verification requires CA")
+
+func ParseClientAuth(s string) (ClientAuth, error) {
+	switch a := ClientAuth(s); a {
+	case ClientAuthNone, ClientAuthVerify, ClientAuthRequire:
+		return a, nil
+	default:
+		return "", fmt.Errorf("unknown client auth mode %q", s)
+	}
+}
+
+// ServerConfig - TLS сервера, caFile используется для проверки клиентских сертификатов
+func ServerConfig(certFile, keyFile, caFile string, clientAuth ClientAuth) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load server certificate: %w", err)
+	}
+
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if clientAuth == ClientAuthNone || clientAuth == "" {
+		return cfg, nil
+	}
+
+	if caFile == "" {
+		return nil, ErrNoCA
+	}
+
+	cfg.ClientCAs, err = loadPool(caFile)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.ClientAuth = tls.VerifyClientCertIfGiven
+
+	if clientAuth == ClientAuthRequire {
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return cfg, nil
+}
+
+// ClientConfig - TLS клиента, без caFile используются системные корневые сертификаты,
+// сертификат клиента нужен только для mTLS
+func ClientConfig(certFile, keyFile, caFile, serverName string) (*tls.Config, error) {
+	cfg := &tls.Config{
+		ServerName: serverName,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if caFile != "" {
+		pool, err := loadPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+
+		cfg.RootCAs = pool
+	}
+
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %w", err)
+		}
+
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return cfg, nil
+}
+
+// CommonName - CN первого сертификата из файла, для клиента это его логин
+func CommonName(certFile string) (string, error) {
+	data, err := os.ReadFile(certFile)
+	if err != nil {
+		return "", err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != pemCertificate {
+		return "", fmt.Errorf("%s: no certificate", certFile)
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", certFile, err)
+	}
+
+	return cert.Subject.CommonName, nil
+}
+
+func loadPool(caFile string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("read CA: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("%s: no certificates", caFile)
+	}
+
+	return pool, nil
+}
@@ -0,0 +1,164 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"time"
+
+	"github.com/gbh007/p2p-chat/internal/auth"
+	"github.com/gbh007/p2p-chat/internal/identity"
+)
+
+type Client struct {
+	Server   string       `yaml:"server" toml:"server" flag:"server" usage:"server address"`
+	LogLevel string       `yaml:"log_level" toml:"log_level" flag:"log-level" usage:"debug, info, warn or error"`
+	LogFile  string       `yaml:"log_file" toml:"log_file" flag:"log-file" usage:"log file, logs are discarded when empty"`
+	Identity Identity     `yaml:"identity" toml:"identity"`
+	TLS      ClientTLS    `yaml:"tls" toml:"tls"`
+	Outbox   Outbox       `yaml:"outbox" toml:"outbox"`
+	P2P      P2P          `yaml:"p2p" toml:"p2p"`
+	Limits   ClientLimits `yaml:"limits" toml:"limits"`
+}
+
+type Identity struct {
+	Keystore string `yaml:"keystore" toml:"keystore" flag:"keystore" usage:"directory with identity keys"`
+	Login    string `yaml:"login" toml:"login" flag:"login" usage:"user login, key fingerprint or client certificate CN by default"`
+	Password string `yaml:"password" toml:"password" flag:"password" usage:"user password, may be empty with client certificate"`
+	Register bool   `yaml:"register" toml:"register" flag:"register" usage:"register new user before login"`
+}
+
+type ClientTLS struct {
+	Enabled    bool   `yaml:"enabled" toml:"enabled" flag:"tls" usage:"use TLS, enabled automatically by other tls options"`
+	CA         string `yaml:"ca" toml:"ca" flag:"tls-ca" usage:"CA for server certificate, system roots by default"`
+	Cert       string `yaml:"cert" toml:"cert" flag:"tls-cert" usage:"client certificate for mTLS"`
+	Key        string `yaml:"key" toml:"key" flag:"tls-key" usage:"client private key for mTLS"`
+	ServerName string `yaml:"server_name" toml:"server_name" flag:"tls-server-name" usage:"expected server name, host from address by default"`
+}
+
+type Outbox struct {
+	Path    string        `yaml:"path" toml:"path" flag:"outbox" usage:"file with unsent messages, outbox.json in keystore by default"`
+	Timeout time.Duration `yaml:"timeout" toml:"timeout" flag:"outbox-timeout" usage:"unsent messages are marked failed after this time"`
+}
+
+type P2P struct {
+	Enabled       bool     `yaml:"enabled" toml:"enabled" flag:"p2p" usage:"run local node and chat with peers directly, server option is ignored"`
+	Listen        string   `yaml:"listen" toml:"listen" flag:"p2p-listen" usage:"node listen address"`
+	Advertise     string   `yaml:"advertise" toml:"advertise" flag:"p2p-advertise" usage:"node address for peers, listen address by default"`
+	Peers         []string `yaml:"peers" toml:"peers" flag:"p2p-peers" usage:"comma separated addresses of known peers"`
+	MDNS          bool     `yaml:"mdns" toml:"mdns" flag:"p2p-mdns" usage:"announce node and discover peers in local network via mDNS"`
+	MDNSInterface string   `yaml:"mdns_interface" toml:"mdns_interface" flag:"p2p-mdns-interface" usage:"network interface for mDNS, chosen by the system when empty"`
+}
+
+type ClientLimits struct {
+	HistoryPageSize int `yaml:"history_page_size" toml:"history_page_size" flag:"history-page-size" usage:"messages loaded per history page"`
+}
+
+func DefaultClient() Client {
+	return Client{
+		Server:   "localhost:8080",
+		LogLevel: "info",
+		Identity: Identity{
+			Keystore: identity.DefaultDir(),
+		},
+		Outbox: Outbox{
+			Timeout: 2 * time.Minute,
+		},
+		P2P: P2P{
+			Listen: ":9090",
+			MDNS:   true,
+		},
+		Limits: ClientLimits{
+			HistoryPageSize: 50,
+		},
+	}
+}
+
+func LoadClient(args []string) (Client, error) {
+	cfg := DefaultClient()
+
+	err := load("client", &cfg, args)
+	if err != nil {
+		return Client{}, err
+	}
+
+	return cfg, nil
+}
+
+func (cfg Client) Validate() error {
+	var errs []error
+
+	if _, _, err := net.SplitHostPort(cfg.Server); err != nil {
+		errs = append(errs, fmt.Errorf("server: %w", err))
+	}
+
+	if err := validateLogLevel(cfg.LogLevel); err != nil {
+		errs = append(errs, err)
+	}
+
+	if cfg.Identity.Keystore == "" {
+		errs = append(errs, errors.New("keystore: required"))
+	}
+
+	if cfg.Identity.Login != "" {
+		if err := auth.ValidateLogin(cfg.Identity.Login); err != nil {
+			errs = append(errs, fmt.Errorf("login: %w", err))
+		}
+	}
+
+	if (cfg.TLS.Cert == "") != (cfg.TLS.Key == "") {
+		errs = append(errs, errors.New("tls: cert and key must be set together"))
+	}
+
+	errs = append(errs, checkFiles("tls ca", cfg.TLS.CA, "tls cert", cfg.TLS.Cert, "tls key", cfg.TLS.Key)...)
+
+	if cfg.P2P.Enabled {
+		if _, _, err := net.SplitHostPort(cfg.P2P.Listen); err != nil {
+			errs = append(errs, fmt.Errorf("p2p listen: %w", err))
+		}
+
+		for _, addr := range append([]string{cfg.P2P.Advertise}, cfg.P2P.Peers...) {
+			if _, _, err := net.SplitHostPort(addr); addr != "" && err != nil {
+				errs = append(errs, fmt.Errorf("p2p peer: %w", err))
+			}
+		}
+	}
+
+	if cfg.Outbox.Timeout <= 0 {
+		errs = append(errs, errors.New("outbox timeout: must be positive"))
+	}
+
+	if cfg.Limits.HistoryPageSize <= 0 {
+		errs = append(errs, errors.New("history page size: must be positive"))
+	}
+
+	return errors.Join(errs...)
+}
+
+func (cfg Client) OutboxPath() string {
+	if cfg.Outbox.Path != "" {
+		return cfg.Outbox.Path
+	}
+
+	return filepath.Join(cfg.Identity.Keystore, "outbox.json")
+}
+
+// AdvertiseAddr - адрес узла для соседей, пустой хост адреса прослушивания заменяется на localhost
+func (cfg P2P) AdvertiseAddr() string {
+	if cfg.Advertise != "" {
+		return cfg.Advertise
+	}
+
+	host, port, _ := net.SplitHostPort(cfg.Listen)
+	if host == "" {
+		host = "localhost"
+	}
+
+	return net.JoinHostPort(host, port)
+}
+
+// UseTLS - TLS включается явно или заданием сертификатов
+func (cfg ClientTLS) UseTLS() bool {
+	return cfg.Enabled || cfg.CA != "" || cfg.Cert != ""
+}
@@ -0,0 +1,286 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	EnvPrefix = "P2PCHAT_"
+
+	configFlag = "config"
+	configEnv  = EnvPrefix + "CONFIG"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// field - параметр конфигурации, поддерживающий задание флагом и переменной окружения
+type field struct {
+	value reflect.Value
+	flag  string
+	env   string
+	usage string
+}
+
+// flagValue - значение флага откладывается, чтобы флаги применялись поверх файла и окружения
+type flagValue struct {
+	field field
+	raw   string
+	def   string
+}
+
+func (v *flagValue) String() string {
+	if v == nil {
+		return ""
+	}
+
+	return v.def
+}
+
+func (v *flagValue) Set(s string) error {
+	// Проверка сразу, чтобы ошибка была показана рядом с именем флага
+	err := setValue(reflect.New(v.field.value.Type()).Elem(), s)
+	if err != nil {
+		return err
+	}
+
+	v.raw = s
+
+	return nil
+}
+
+func (v *flagValue) IsBoolFlag() bool {
+	return v.field.value.Kind() == reflect.Bool
+}
+
+// load - заполняет cfg в порядке приоритета: значения по умолчанию, файл, переменные окружения, флаги
+func load(name string, cfg any, args []string) error {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	configPath := fs.String(configFlag, os.Getenv(configEnv), "config file, yaml or toml (env "+configEnv+")")
+
+	fields := collect(reflect.ValueOf(cfg).Elem())
+	values := make(map[string]*flagValue, len(fields))
+
+	for _, f := range fields {
+		v := &flagValue{
+			field: f,
+			def:   formatValue(f.value),
+		}
+
+		values[f.flag] = v
+		fs.Var(v, f.flag, f.usage+" (env "+EnvPrefix+f.env+")")
+	}
+
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if *configPath != "" {
+		err = loadFile(*configPath, cfg)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, f := range fields {
+		raw, ok := os.LookupEnv(EnvPrefix + f.env)
+		if !ok {
+			continue
+		}
+
+		err = setValue(f.value, raw)
+		if err != nil {
+			return fmt.Errorf("env %s: %w", EnvPrefix+f.env, err)
+		}
+	}
+
+	fs.Visit(func(fl *flag.Flag) {
+		v, ok := values[fl.Name]
+		if ok {
+			// Значение уже проверено при разборе
+			_ = setValue(v.field.value, v.raw)
+		}
+	})
+
+	return nil
+}
+
+func collect(v reflect.Value) []field {
+	var fields []field
+
+	for i := range v.NumField() {
+		sf := v.Type().Field(i)
+		fv := v.Field(i)
+
+		if fv.Kind() == reflect.Struct && fv.Type() != durationType {
+			fields = append(fields, collect(fv)...)
+
+			continue
+		}
+
+		name := sf.Tag.Get("flag")
+		if name == "" {
+			continue
+		}
+
+		fields = append(fields, field{
+			value: fv,
+			flag:  name,
+			env:   strings.ToUpper(strings.ReplaceAll(name, "-", "_")),
+			usage: sf.Tag.Get("usage"),
+		})
+	}
+
+	return fields
+}
+
+func loadFile(path string, cfg any) error {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+
+		defer f.Close()
+
+		decoder := yaml.NewDecoder(f)
+		decoder.KnownFields(true)
+
+		err = decoder.Decode(cfg)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	case ".toml":
+		md, err := toml.DecodeFile(path, cfg)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return fmt.Errorf("%s: unknown fields %v", path, undecoded)
+		}
+	default:
+		return fmt.Errorf("%s: unsupported config format, use yaml or toml", path)
+	}
+
+	return nil
+}
+
+func setValue(v reflect.Value, raw string) error {
+	switch {
+	case v.Type() == durationType:
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+
+		v.SetInt(int64(d))
+	case v.Kind() == reflect.String:
+		v.SetString(raw)
+	case v.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+
+		v.SetBool(b)
+	case v.CanInt():
+		n, err := strconv.ParseInt(raw, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+
+		v.SetInt(n)
+	case v.CanUint():
+		n, err := strconv.ParseUint(raw, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+
+		v.SetUint(n)
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.String:
+		var items []string
+
+		for _, item := range strings.Split(raw, ",") {
+			item = strings.TrimSpace(item)
+			if item != "" {
+				items = append(items, item)
+			}
+		}
+
+		v.Set(reflect.ValueOf(items).Convert(v.Type()))
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+
+	return nil
+}
+
+func formatValue(v reflect.Value) string {
+	switch {
+	case v.Type() == durationType:
+		return time.Duration(v.Int()).String()
+	case v.Kind() == reflect.Slice:
+		items := make([]string, 0, v.Len())
+
+		for i := range v.Len() {
+			items = append(items, v.Index(i).String())
+		}
+
+		return strings.Join(items, ",")
+	default:
+		return fmt.Sprint(v.Interface())
+	}
+}
+
+func validateLogLevel(level string) error {
+	var l slog.Level
+
+	err := l.UnmarshalText([]byte(level))
+	if err != nil {
+		return fmt.Errorf("log level: %w", err)
+	}
+
+	return nil
+}
+
+// LogLevel - уровень логирования, значение должно быть проверено при валидации
+func LogLevel(level string) slog.Level {
+	var l slog.Level
+
+	_ = l.UnmarshalText([]byte(level))
+
+	return l
+}
+
+// checkFiles - проверка существования файлов, аргументы парами имя-путь
+func checkFiles(namesAndPaths ...string) []error {
+	var errs []error
+
+	for i := 0; i+1 < len(namesAndPaths); i += 2 {
+		if namesAndPaths[i+1] == "" {
+			continue
+		}
+
+		_, err := os.Stat(namesAndPaths[i+1])
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", namesAndPaths[i], err))
+		}
+	}
+
+	return errs
+}
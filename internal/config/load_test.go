@@ -0,0 +1,144 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, name, data string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+
+	err := os.WriteFile(path, []byte(data), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestLoadServerPrecedence(t *testing.T) {
+	path := writeConfig(t, "server.yaml", `
+addr: ":9000"
+log_level: warn
+moderators: [alice, bob]
+storage:
+  dir: file-dir
+  memory_size: 10
+limits:
+  max_history: 50
+`)
+
+	t.Setenv(EnvPrefix+"LOG_LEVEL", "error")
+	t.Setenv(EnvPrefix+"STORAGE_DIR", "env-dir")
+	t.Setenv(EnvPrefix+"MAX_HISTORY", "70")
+
+	cfg, err := LoadServer([]string{"-config", path, "-storage-dir", "flag-dir", "-federation-open"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Значение по умолчанию, файл, окружение и флаг - каждый следующий перекрывает предыдущий
+	checks := map[string]bool{
+		"default backend":  cfg.Storage.Backend == StorageFile,
+		"file addr":        cfg.Addr == ":9000",
+		"file memory size": cfg.Storage.MemorySize == 10,
+		"file moderators":  slices.Equal(cfg.Moderators, []string{"alice", "bob"}),
+		"env log level":    cfg.LogLevel == "error",
+		"env max history":  cfg.Limits.MaxHistory == 70,
+		"flag storage dir": cfg.Storage.Dir == "flag-dir",
+		"flag bool":        cfg.Federation.Open,
+		"default overflow": cfg.Limits.OverflowPolicy == DefaultServer().Limits.OverflowPolicy,
+	}
+
+	for name, ok := range checks {
+		if !ok {
+			t.Fatalf("%s: unexpected config %+v", name, cfg)
+		}
+	}
+}
+
+func TestLoadClientTOML(t *testing.T) {
+	def := DefaultClient()
+
+	path := writeConfig(t, "client.toml", "server = \"chat:8080\"\n")
+
+	// Путь к файлу тоже можно задать окружением
+	t.Setenv(configEnv, path)
+
+	cfg, err := LoadClient(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Server != "chat:8080" || cfg.Identity.Login != def.Identity.Login {
+		t.Fatalf("unexpected config: %+v", cfg)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := map[string]struct {
+		args []string
+		env  map[string]string
+		err  string
+	}{
+		"unknown field": {
+			args: []string{"-config", writeConfig(t, "server.yaml", "unknown: 1\n")},
+			err:  "field unknown not found",
+		},
+		"unknown toml field": {
+			args: []string{"-config", writeConfig(t, "server.toml", "unknown = 1\n")},
+			err:  "unknown fields",
+		},
+		"format": {
+			args: []string{"-config", writeConfig(t, "server.json", "{}")},
+			err:  "unsupported config format",
+		},
+		"env value": {
+			env: map[string]string{EnvPrefix + "MAX_HISTORY": "many"},
+			err: "env " + EnvPrefix + "MAX_HISTORY",
+		},
+		"flag value": {
+			args: []string{"-max-history", "many"},
+			err:  "-max-history",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+
+			var cfg Server
+
+			err := load("server", &cfg, tt.args)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("expected error with %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestSetValue(t *testing.T) {
+	var cfg struct {
+		Timeout time.Duration
+		Peers   []string
+	}
+
+	err := setValue(reflect.ValueOf(&cfg.Timeout).Elem(), "1m30s")
+	if err != nil || cfg.Timeout != 90*time.Second {
+		t.Fatalf("unexpected duration: %v %v", cfg.Timeout, err)
+	}
+
+	err = setValue(reflect.ValueOf(&cfg.Peers).Elem(), " a=1, ,b=2 ")
+	if err != nil || !slices.Equal(cfg.Peers, []string{"a=1", "b=2"}) {
+		t.Fatalf("unexpected list: %v %v", cfg.Peers, err)
+	}
+}
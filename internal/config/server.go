@@ -0,0 +1,213 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+
+	"github.com/gbh007/p2p-chat/internal/certs"
+	"github.com/gbh007/p2p-chat/internal/server"
+)
+
+const (
+	StorageFile   = "file"
+	StorageMemory = "memory"
+)
+
+type Server struct {
+	Addr       string       `yaml:"addr" toml:"addr" flag:"addr" usage:"listen address"`
+	LogLevel   string       `yaml:"log_level" toml:"log_level" flag:"log-level" usage:"debug, info, warn or error"`
+	Storage    Storage      `yaml:"storage" toml:"storage"`
+	TLS        ServerTLS    `yaml:"tls" toml:"tls"`
+	Limits     ServerLimits `yaml:"limits" toml:"limits"`
+	Federation Federation   `yaml:"federation" toml:"federation"`
+	Moderators []string     `yaml:"moderators" toml:"moderators" flag:"moderators" usage:"comma separated logins allowed to delete any channel message"`
+}
+
+type Storage struct {
+	Backend    string `yaml:"backend" toml:"backend" flag:"storage" usage:"message storage: file or memory"`
+	Dir        string `yaml:"dir" toml:"dir" flag:"storage-dir" usage:"data directory of file storage"`
+	MemorySize int    `yaml:"memory_size" toml:"memory_size" flag:"storage-memory-size" usage:"messages per channel kept by memory storage"`
+}
+
+type ServerTLS struct {
+	Cert       string `yaml:"cert" toml:"cert" flag:"tls-cert" usage:"server certificate, TLS is disabled when empty"`
+	Key        string `yaml:"key" toml:"key" flag:"tls-key" usage:"server private key"`
+	CA         string `yaml:"ca" toml:"ca" flag:"tls-ca" usage:"CA for client certificates"`
+	ClientAuth string `yaml:"client_auth" toml:"client_auth" flag:"tls-client-auth" usage:"client certificates: none, verify or require (mTLS)"`
+}
+
+type Federation struct {
+	Domain   string   `yaml:"domain" toml:"domain" flag:"federation-domain" usage:"server name in federation, usually its public address, federation is disabled when empty"`
+	Keystore string   `yaml:"keystore" toml:"keystore" flag:"federation-keystore" usage:"directory with server identity key, federation in storage dir by default"`
+	Peers    []string `yaml:"peers" toml:"peers" flag:"federation-peers" usage:"comma separated domain=address pairs of servers this server exchanges channels with"`
+	Open     bool     `yaml:"open" toml:"open" flag:"federation-open" usage:"exchange channels with servers not listed in peers, their domain is used as address"`
+}
+
+type ServerLimits struct {
+	OverflowPolicy string `yaml:"overflow_policy" toml:"overflow_policy" flag:"overflow-policy" usage:"slow reader policy: drop-oldest, disconnect or spill"`
+	ReaderBuffer   int    `yaml:"reader_buffer" toml:"reader_buffer" flag:"reader-buffer" usage:"live messages buffered per reader"`
+	MaxHistory     int    `yaml:"max_history" toml:"max_history" flag:"max-history" usage:"max messages per history request"`
+	MaxMessageSize int    `yaml:"max_message_size" toml:"max_message_size" flag:"max-message-size" usage:"max gRPC message size in bytes"`
+}
+
+func DefaultServer() Server {
+	limits := server.DefaultLimits()
+
+	return Server{
+		Addr:     ":8080",
+		LogLevel: "info",
+		Storage: Storage{
+			Backend:    StorageFile,
+			Dir:        "data",
+			MemorySize: 1000,
+		},
+		TLS: ServerTLS{
+			ClientAuth: string(certs.ClientAuthNone),
+		},
+		Limits: ServerLimits{
+			OverflowPolicy: string(limits.OverflowPolicy),
+			ReaderBuffer:   limits.ReaderBuffer,
+			MaxHistory:     limits.MaxHistory,
+			MaxMessageSize: 4 << 20,
+		},
+	}
+}
+
+func LoadServer(args []string) (Server, error) {
+	cfg := DefaultServer()
+
+	err := load("server", &cfg, args)
+	if err != nil {
+		return Server{}, err
+	}
+
+	return cfg, nil
+}
+
+// Validate - все ошибки конфигурации сразу, чтобы их можно было исправить за один запуск
+func (cfg Server) Validate() error {
+	var errs []error
+
+	if _, _, err := net.SplitHostPort(cfg.Addr); err != nil {
+		errs = append(errs, fmt.Errorf("addr: %w", err))
+	}
+
+	if err := validateLogLevel(cfg.LogLevel); err != nil {
+		errs = append(errs, err)
+	}
+
+	switch cfg.Storage.Backend {
+	case StorageFile:
+		if cfg.Storage.Dir == "" {
+			errs = append(errs, errors.New("storage dir: required for file storage"))
+		}
+	case StorageMemory:
+		if cfg.Storage.MemorySize <= 0 {
+			errs = append(errs, errors.New("storage memory size: must be positive"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("storage backend: unknown %q", cfg.Storage.Backend))
+	}
+
+	errs = append(errs, cfg.TLS.validate()...)
+
+	if _, err := server.ParseOverflowPolicy(cfg.Limits.OverflowPolicy); err != nil {
+		errs = append(errs, fmt.Errorf("overflow policy: %w", err))
+	}
+
+	if cfg.Limits.ReaderBuffer <= 0 {
+		errs = append(errs, errors.New("reader buffer: must be positive"))
+	}
+
+	if cfg.Limits.MaxHistory <= 0 {
+		errs = append(errs, errors.New("max history: must be positive"))
+	}
+
+	if cfg.Limits.MaxMessageSize <= 0 {
+		errs = append(errs, errors.New("max message size: must be positive"))
+	}
+
+	errs = append(errs, cfg.Federation.validate()...)
+
+	return errors.Join(errs...)
+}
+
+func (cfg Federation) validate() []error {
+	var errs []error
+
+	if strings.Contains(cfg.Domain, "@") {
+		errs = append(errs, errors.New("federation domain: must not contain @"))
+	}
+
+	for _, peer := range cfg.Peers {
+		domain, addr, ok := strings.Cut(peer, "=")
+		if !ok || domain == "" {
+			errs = append(errs, fmt.Errorf("federation peer %q: expected domain=address", peer))
+
+			continue
+		}
+
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			errs = append(errs, fmt.Errorf("federation peer %q: %w", peer, err))
+		}
+	}
+
+	return errs
+}
+
+// Addrs - адреса серверов федерации по доменам, значения должны быть проверены при валидации
+func (cfg Federation) Addrs() map[string]string {
+	addrs := make(map[string]string, len(cfg.Peers))
+
+	for _, peer := range cfg.Peers {
+		domain, addr, _ := strings.Cut(peer, "=")
+		addrs[domain] = addr
+	}
+
+	return addrs
+}
+
+func (cfg Server) FederationKeystore() string {
+	if cfg.Federation.Keystore != "" {
+		return cfg.Federation.Keystore
+	}
+
+	return filepath.Join(cfg.Storage.Dir, "federation")
+}
+
+func (cfg ServerTLS) validate() []error {
+	var errs []error
+
+	clientAuth, err := certs.ParseClientAuth(cfg.ClientAuth)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("tls client auth: %w", err))
+	}
+
+	if (cfg.Cert == "") != (cfg.Key == "") {
+		errs = append(errs, errors.New("tls: cert and key must be set together"))
+	}
+
+	if cfg.Cert == "" && clientAuth != certs.ClientAuthNone && clientAuth != "" {
+		errs = append(errs, errors.New("tls client auth: requires tls cert"))
+	}
+
+	if clientAuth != certs.ClientAuthNone && clientAuth != "" && cfg.CA == "" {
+		errs = append(errs, fmt.Errorf("tls client auth: %w", certs.ErrNoCA))
+	}
+
+	return append(errs, checkFiles("tls cert", cfg.Cert, "tls key", cfg.Key, "tls ca", cfg.CA)...)
+}
+
+// Server - ограничения сервера, значения должны быть проверены при валидации
+func (cfg ServerLimits) Server() server.Limits {
+	policy, _ := server.ParseOverflowPolicy(cfg.OverflowPolicy)
+
+	return server.Limits{
+		OverflowPolicy: policy,
+		ReaderBuffer:   cfg.ReaderBuffer,
+		MaxHistory:     cfg.MaxHistory,
+	}
+}
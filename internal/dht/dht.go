@@ -0,0 +1,571 @@
+package dht
+
+import (
+	"context"
+	"crypto/ed25519"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/gbh007/p2p-chat/internal/identity"
+	"github.com/gbh007/p2p-chat/proto/gen"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	// alpha - количество параллельных запросов при поиске
+	alpha             = 3
+	requestTimeout    = 5 * time.Second
+	providerTTL       = 10 * time.Minute
+	republishInterval = 5 * time.Minute
+	// contactMaxSkew - допустимое расхождение времени подписи отправителя запроса с текущим,
+	// отправитель подписывает свой контакт заново в каждом запросе
+	contactMaxSkew = 5 * time.Minute
+)
+
+type provider struct {
+	contact Contact
+	expires time.Time
+}
+
+// DHT - распределенная таблица в стиле Kademlia: узлы ищутся по идентификатору,
+// по ключу канала хранятся узлы подписанные на канал
+type DHT struct {
+	gen.UnimplementedDHTServer
+	logger *slog.Logger
+
+	self       Contact
+	privateKey ed25519.PrivateKey
+	table      *table
+
+	// providers - ключ -> узел -> запись, хранятся записи ключей близких к узлу
+	providers map[ID]map[ID]provider
+	// announced - ключи объявленные этим узлом, периодически публикуются повторно
+	announced map[ID]struct{}
+	mutex     *sync.Mutex
+
+	conns      map[string]*grpc.ClientConn
+	connsMutex *sync.Mutex
+}
+
+// New - узел DHT, addr - адрес по которому узел доступен другим узлам,
+// ключом подписывается контакт узла
+func New(privateKey ed25519.PrivateKey, addr string) *DHT {
+	publicKey := privateKey.Public().(ed25519.PublicKey)
+
+	self := Contact{
+		ID:        NodeID(publicKey),
+		Addr:      addr,
+		PublicKey: publicKey,
+	}
+
+	return &DHT{
+		logger:     slog.Default(),
+		self:       self,
+		privateKey: privateKey,
+		table:      newTable(self.ID),
+		providers:  make(map[ID]map[ID]provider),
+		announced:  make(map[ID]struct{}),
+		mutex:      &sync.Mutex{},
+		conns:      make(map[string]*grpc.ClientConn),
+		connsMutex: &sync.Mutex{},
+	}
+}
+
+func (d *DHT) Self() Contact {
+	return d.self
+}
+
+// Size - количество узлов в таблице маршрутизации
+func (d *DHT) Size() int {
+	return d.table.size()
+}
+
+// Run - периодическая повторная публикация ключей и очистка устаревших записей до отмены контекста
+func (d *DHT) Run(ctx context.Context) {
+	ticker := time.NewTicker(republishInterval)
+	defer ticker.Stop()
+
+	defer d.closeConns()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		d.expireProviders()
+
+		d.mutex.Lock()
+		keys := make([]ID, 0, len(d.announced))
+
+		for key := range d.announced {
+			keys = append(keys, key)
+		}
+
+		d.mutex.Unlock()
+
+		for _, key := range keys {
+			err := d.publish(ctx, key)
+			if err != nil {
+				d.logger.Warn("dht republish", "key", key.String(), "error", err)
+			}
+		}
+
+		// Обновление таблицы маршрутизации
+		d.lookup(ctx, d.self.ID, nil)
+	}
+}
+
+// Bootstrap - вход в сеть через один известный адрес
+func (d *DHT) Bootstrap(ctx context.Context, addr string) error {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	client, err := d.client(addr)
+	if err != nil {
+		return err
+	}
+
+	res, err := client.FindNode(ctx, &gen.FindNodeRequest{
+		Sender: contactToProto(d.signedSelf()),
+		Target: d.self.ID[:],
+	})
+	if err != nil {
+		return err
+	}
+
+	d.observe(res.GetSender())
+
+	for _, c := range res.GetContacts() {
+		d.observe(c)
+	}
+
+	d.lookup(ctx, d.self.ID, nil)
+
+	return nil
+}
+
+// FindNodes - узлы ближайшие к target
+func (d *DHT) FindNodes(ctx context.Context, target ID) []Contact {
+	return d.lookup(ctx, target, nil)
+}
+
+// Announce - объявление узла подписчиком ключа, объявление поддерживается до Withdraw
+func (d *DHT) Announce(ctx context.Context, key ID) error {
+	d.mutex.Lock()
+	d.announced[key] = struct{}{}
+	d.mutex.Unlock()
+
+	return d.publish(ctx, key)
+}
+
+// Withdraw - прекращение публикации ключа, записи на других узлах истекают сами
+func (d *DHT) Withdraw(key ID) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	delete(d.announced, key)
+
+	if providers, ok := d.providers[key]; ok {
+		delete(providers, d.self.ID)
+	}
+}
+
+// Providers - узлы объявившие ключ, без собственного узла
+func (d *DHT) Providers(ctx context.Context, key ID) []Contact {
+	found := make(map[ID]Contact)
+
+	for _, c := range d.localProviders(key) {
+		found[c.ID] = c
+	}
+
+	d.lookup(ctx, key, func(providers []*gen.Contact) {
+		for _, raw := range providers {
+			c, ok := contactFromProto(raw)
+			if ok {
+				found[c.ID] = c
+			}
+		}
+	})
+
+	delete(found, d.self.ID)
+
+	result := make([]Contact, 0, len(found))
+
+	for _, c := range found {
+		result = append(result, c)
+	}
+
+	sortByDistance(key, result)
+
+	return result
+}
+
+func (d *DHT) FindNode(ctx context.Context, req *gen.FindNodeRequest) (*gen.FindNodeResponse, error) {
+	target, err := ParseID(req.GetTarget())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid target")
+	}
+
+	d.observe(req.GetSender())
+
+	return &gen.FindNodeResponse{
+		Sender:   contactToProto(d.signedSelf()),
+		Contacts: contactsToProto(d.table.closest(target, bucketSize)),
+	}, nil
+}
+
+func (d *DHT) FindProviders(ctx context.Context, req *gen.FindProvidersRequest) (*gen.FindProvidersResponse, error) {
+	key, err := ParseID(req.GetKey())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid key")
+	}
+
+	d.observe(req.GetSender())
+
+	return &gen.FindProvidersResponse{
+		Sender:    contactToProto(d.signedSelf()),
+		Providers: contactsToProto(d.localProviders(key)),
+		Contacts:  contactsToProto(d.table.closest(key, bucketSize)),
+	}, nil
+}
+
+func (d *DHT) AddProvider(ctx context.Context, req *gen.AddProviderRequest) (*gen.AddProviderResponse, error) {
+	key, err := ParseID(req.GetKey())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid key")
+	}
+
+	sender, ok := senderFromProto(req.GetSender())
+	if !ok {
+		return nil, status.Error(codes.PermissionDenied, "invalid sender")
+	}
+
+	d.table.add(sender)
+	d.addProvider(key, sender)
+
+	return &gen.AddProviderResponse{}, nil
+}
+
+// publish - запись о себе сохраняется на узлах ближайших к ключу
+func (d *DHT) publish(ctx context.Context, key ID) error {
+	d.addProvider(key, d.signedSelf())
+
+	closest := d.lookup(ctx, key, nil)
+
+	var (
+		lastErr error
+		stored  bool
+	)
+
+	for _, c := range closest {
+		client, err := d.client(c.Addr)
+		if err != nil {
+			lastErr = err
+
+			continue
+		}
+
+		reqCtx, cancel := context.WithTimeout(ctx, requestTimeout)
+		_, err = client.AddProvider(reqCtx, &gen.AddProviderRequest{
+			Sender: contactToProto(d.signedSelf()),
+			Key:    key[:],
+		})
+		cancel()
+
+		if err != nil {
+			lastErr = err
+
+			continue
+		}
+
+		stored = true
+	}
+
+	// Пустая сеть не ошибка, запись сохранена локально
+	if !stored && lastErr != nil {
+		return lastErr
+	}
+
+	return nil
+}
+
+// lookup - итеративный поиск узлов ближайших к target, onProviders получает найденные записи ключа
+func (d *DHT) lookup(ctx context.Context, target ID, onProviders func([]*gen.Contact)) []Contact {
+	shortlist := d.table.closest(target, bucketSize)
+	queried := map[ID]bool{d.self.ID: true}
+	seen := map[ID]bool{d.self.ID: true}
+
+	for _, c := range shortlist {
+		seen[c.ID] = true
+	}
+
+	type result struct {
+		from      Contact
+		contacts  []*gen.Contact
+		providers []*gen.Contact
+		err       error
+	}
+
+	for {
+		var batch []Contact
+
+		for _, c := range shortlist {
+			if len(batch) == alpha {
+				break
+			}
+
+			if !queried[c.ID] {
+				batch = append(batch, c)
+				queried[c.ID] = true
+			}
+		}
+
+		if len(batch) == 0 || ctx.Err() != nil {
+			return shortlist
+		}
+
+		results := make(chan result, len(batch))
+
+		for _, c := range batch {
+			go func() {
+				reqCtx, cancel := context.WithTimeout(ctx, requestTimeout)
+				defer cancel()
+
+				res := result{from: c}
+
+				client, err := d.client(c.Addr)
+				if err != nil {
+					res.err = err
+					results <- res
+
+					return
+				}
+
+				if onProviders == nil {
+					r, err := client.FindNode(reqCtx, &gen.FindNodeRequest{
+						Sender: contactToProto(d.signedSelf()),
+						Target: target[:],
+					})
+					res.contacts, res.err = r.GetContacts(), err
+				} else {
+					r, err := client.FindProviders(reqCtx, &gen.FindProvidersRequest{
+						Sender: contactToProto(d.signedSelf()),
+						Key:    target[:],
+					})
+					res.contacts, res.providers, res.err = r.GetContacts(), r.GetProviders(), err
+				}
+
+				results <- res
+			}()
+		}
+
+		for range batch {
+			res := <-results
+
+			if res.err != nil {
+				d.logger.Debug("dht request", "peer", res.from.Addr, "error", res.err)
+				d.table.remove(res.from.ID)
+
+				shortlist = removeContact(shortlist, res.from.ID)
+
+				continue
+			}
+
+			d.table.add(res.from)
+
+			if onProviders != nil {
+				onProviders(res.providers)
+			}
+
+			for _, raw := range res.contacts {
+				c, ok := contactFromProto(raw)
+				if !ok || seen[c.ID] {
+					continue
+				}
+
+				seen[c.ID] = true
+				shortlist = append(shortlist, c)
+			}
+		}
+
+		sortByDistance(target, shortlist)
+
+		if len(shortlist) > bucketSize {
+			shortlist = shortlist[:bucketSize]
+		}
+	}
+}
+
+// observe - отправитель запроса или ответа попадает в таблицу только с подписью сделанной недавно
+func (d *DHT) observe(raw *gen.Contact) {
+	c, ok := senderFromProto(raw)
+	if ok && c.ID != d.self.ID {
+		d.table.add(c)
+	}
+}
+
+// signedSelf - собственный контакт с подписью на текущий момент
+func (d *DHT) signedSelf() Contact {
+	c := d.self
+	c.TS = time.Now()
+	c.Signature = identity.SignContact(d.privateKey, c.Addr, c.TS)
+
+	return c
+}
+
+func (d *DHT) addProvider(key ID, c Contact) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	providers, ok := d.providers[key]
+	if !ok {
+		providers = make(map[ID]provider)
+		d.providers[key] = providers
+	}
+
+	providers[c.ID] = provider{
+		contact: c,
+		expires: time.Now().Add(providerTTL),
+	}
+}
+
+func (d *DHT) localProviders(key ID) []Contact {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	now := time.Now()
+	result := make([]Contact, 0, len(d.providers[key]))
+
+	for _, p := range d.providers[key] {
+		if p.expires.After(now) {
+			result = append(result, p.contact)
+		}
+	}
+
+	return result
+}
+
+func (d *DHT) expireProviders() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	now := time.Now()
+
+	for key, providers := range d.providers {
+		for id, p := range providers {
+			if !p.expires.After(now) {
+				delete(providers, id)
+			}
+		}
+
+		if len(providers) == 0 {
+			delete(d.providers, key)
+		}
+	}
+}
+
+func (d *DHT) client(addr string) (gen.DHTClient, error) {
+	d.connsMutex.Lock()
+	defer d.connsMutex.Unlock()
+
+	conn, ok := d.conns[addr]
+	if !ok {
+		var err error
+
+		conn, err = grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		if err != nil {
+			return nil, err
+		}
+
+		d.conns[addr] = conn
+	}
+
+	return gen.NewDHTClient(conn), nil
+}
+
+func (d *DHT) closeConns() {
+	d.connsMutex.Lock()
+	defer d.connsMutex.Unlock()
+
+	for addr, conn := range d.conns {
+		_ = conn.Close()
+		delete(d.conns, addr)
+	}
+}
+
+func removeContact(contacts []Contact, id ID) []Contact {
+	result := contacts[:0]
+
+	for _, c := range contacts {
+		if c.ID != id {
+			result = append(result, c)
+		}
+	}
+
+	return result
+}
+
+// contactFromProto - идентификатор контакта должен соответствовать его ключу,
+// адрес подписан ключом узла, поэтому чужой адрес нельзя выдать за адрес узла.
+// Переданные другими узлами контакты сохраняют исходную подпись
+func contactFromProto(raw *gen.Contact) (Contact, bool) {
+	if raw == nil || raw.GetAddr() == "" || len(raw.GetPublicKey()) != ed25519.PublicKeySize || raw.GetTs() == nil {
+		return Contact{}, false
+	}
+
+	id, err := ParseID(raw.GetId())
+	if err != nil || id != NodeID(raw.GetPublicKey()) {
+		return Contact{}, false
+	}
+
+	ts := raw.GetTs().AsTime()
+	if ts.After(time.Now().Add(contactMaxSkew)) ||
+		!identity.VerifyContact(raw.GetPublicKey(), raw.GetAddr(), ts, raw.GetSignature()) {
+		return Contact{}, false
+	}
+
+	return Contact{
+		ID:        id,
+		Addr:      raw.GetAddr(),
+		PublicKey: raw.GetPublicKey(),
+		TS:        ts,
+		Signature: raw.GetSignature(),
+	}, true
+}
+
+// senderFromProto - контакт отправителя, подписанный им к этому запросу, а не повторенный чужой
+func senderFromProto(raw *gen.Contact) (Contact, bool) {
+	c, ok := contactFromProto(raw)
+	if !ok || c.TS.Before(time.Now().Add(-contactMaxSkew)) {
+		return Contact{}, false
+	}
+
+	return c, true
+}
+
+func contactToProto(c Contact) *gen.Contact {
+	return &gen.Contact{
+		Id:        c.ID[:],
+		Addr:      c.Addr,
+		PublicKey: c.PublicKey,
+		Ts:        timestamppb.New(c.TS),
+		Signature: c.Signature,
+	}
+}
+
+func contactsToProto(contacts []Contact) []*gen.Contact {
+	result := make([]*gen.Contact, 0, len(contacts))
+
+	for _, c := range contacts {
+		result = append(result, contactToProto(c))
+	}
+
+	return result
+}
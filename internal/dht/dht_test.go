@@ -0,0 +1,132 @@
+package dht
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gbh007/p2p-chat/internal/identity"
+	"github.com/gbh007/p2p-chat/proto/gen"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func newKey(t *testing.T) ed25519.PrivateKey {
+	t.Helper()
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key
+}
+
+func TestAddProviderRequiresSignedSender(t *testing.T) {
+	ctx := context.Background()
+	d := New(newKey(t), "127.0.0.1:9000")
+	key := ChannelKey("room")
+
+	peer := New(newKey(t), "127.0.0.1:9001")
+	sender := peer.signedSelf()
+
+	forged := sender
+	forged.Addr = "127.0.0.1:9666"
+
+	stale := sender
+	stale.TS = time.Now().Add(-2 * contactMaxSkew)
+	stale.Signature = identity.SignContact(peer.privateKey, stale.Addr, stale.TS)
+
+	unsigned := sender
+	unsigned.Signature = nil
+
+	for name, c := range map[string]Contact{"forged": forged, "stale": stale, "unsigned": unsigned} {
+		_, err := d.AddProvider(ctx, &gen.AddProviderRequest{Sender: contactToProto(c), Key: key[:]})
+		if status.Code(err) != codes.PermissionDenied {
+			t.Fatalf("%s sender must be rejected, got %v", name, err)
+		}
+	}
+
+	if d.Size() != 0 || len(d.localProviders(key)) != 0 {
+		t.Fatal("rejected sender must not be stored")
+	}
+
+	_, err := d.AddProvider(ctx, &gen.AddProviderRequest{Sender: contactToProto(sender), Key: key[:]})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	providers := d.localProviders(key)
+	if len(providers) != 1 || providers[0].Addr != sender.Addr {
+		t.Fatalf("unexpected providers: %+v", providers)
+	}
+}
+
+func TestObserveIgnoresReplayedContact(t *testing.T) {
+	d := New(newKey(t), "127.0.0.1:9000")
+	peer := New(newKey(t), "127.0.0.1:9001")
+
+	old := peer.signedSelf()
+	old.TS = time.Now().Add(-time.Minute)
+	old.Signature = identity.SignContact(peer.privateKey, old.Addr, old.TS)
+
+	fresh := peer.signedSelf()
+	d.observe(contactToProto(fresh))
+
+	// Подпись старого адреса действительна, но не заменяет более новый
+	old.Addr = "127.0.0.1:9666"
+	old.Signature = identity.SignContact(peer.privateKey, old.Addr, old.TS)
+	d.observe(contactToProto(old))
+
+	contacts := d.table.closest(peer.self.ID, bucketSize)
+	if len(contacts) != 1 || contacts[0].Addr != fresh.Addr {
+		t.Fatalf("unexpected contacts: %+v", contacts)
+	}
+}
+
+func TestProvidersOverNetwork(t *testing.T) {
+	ctx := context.Background()
+
+	start := func() *DHT {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		d := New(newKey(t), lis.Addr().String())
+
+		grpcServer := grpc.NewServer()
+		gen.RegisterDHTServer(grpcServer, d)
+
+		go func() {
+			_ = grpcServer.Serve(lis)
+		}()
+
+		t.Cleanup(grpcServer.Stop)
+		t.Cleanup(d.closeConns)
+
+		return d
+	}
+
+	a, b := start(), start()
+	key := ChannelKey("room")
+
+	err := b.Bootstrap(ctx, a.self.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = b.Announce(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	providers := a.Providers(ctx, key)
+	if len(providers) != 1 || providers[0].ID != b.self.ID || providers[0].Addr != b.self.Addr {
+		t.Fatalf("unexpected providers: %+v", providers)
+	}
+}
@@ -0,0 +1,84 @@
+package dht
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"math/bits"
+)
+
+// IDLength - длина идентификатора в байтах, 160 бит как в Kademlia
+const IDLength = 20
+
+var ErrInvalidID = errors.New("invalid id")
+
+// ID - идентификатор узла или ключа, расстояние между идентификаторами - XOR
+type ID [IDLength]byte
+
+// NodeID - идентификатор узла выводится из его публичного ключа
+func NodeID(publicKey ed25519.PublicKey) ID {
+	return hashID([]byte(publicKey))
+}
+
+// ChannelKey - ключ канала, по нему хранятся подписанные на канал узлы
+func ChannelKey(channel string) ID {
+	return hashID([]byte("channel:" + channel))
+}
+
+func ParseID(raw []byte) (ID, error) {
+	var id ID
+
+	if len(raw) != IDLength {
+		return id, ErrInvalidID
+	}
+
+	copy(id[:], raw)
+
+	return id, nil
+}
+
+func hashID(data []byte) ID {
+	sum := sha256.Sum256(data)
+
+	var id ID
+
+	copy(id[:], sum[:IDLength])
+
+	return id
+}
+
+func (id ID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+func (id ID) xor(other ID) ID {
+	var result ID
+
+	for i := range id {
+		result[i] = id[i] ^ other[i]
+	}
+
+	return result
+}
+
+// closer - a ближе к target чем b
+func closer(target, a, b ID) bool {
+	da, db := target.xor(a), target.xor(b)
+
+	return bytes.Compare(da[:], db[:]) < 0
+}
+
+// bucketIndex - номер корзины по длине общего префикса, -1 для совпадающих идентификаторов
+func bucketIndex(self, other ID) int {
+	distance := self.xor(other)
+
+	for i, b := range distance {
+		if b != 0 {
+			return IDLength*8 - 1 - (i*8 + bits.LeadingZeros8(b))
+		}
+	}
+
+	return -1
+}
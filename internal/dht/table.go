@@ -0,0 +1,123 @@
+package dht
+
+import (
+	"crypto/ed25519"
+	"slices"
+	"sync"
+	"time"
+)
+
+// bucketSize - количество контактов в корзине, k в Kademlia
+const bucketSize = 20
+
+type Contact struct {
+	ID        ID
+	Addr      string
+	PublicKey ed25519.PublicKey
+	// TS, Signature - подпись узла над адресом, см. identity.SignContact
+	TS        time.Time
+	Signature []byte
+}
+
+// table - таблица маршрутизации из корзин по расстоянию до собственного идентификатора,
+// в начале корзины давно известные контакты, они считаются более надежными
+type table struct {
+	self ID
+
+	buckets [IDLength * 8][]Contact
+	mutex   *sync.RWMutex
+}
+
+func newTable(self ID) *table {
+	return &table{
+		self:  self,
+		mutex: &sync.RWMutex{},
+	}
+}
+
+// add - добавление или обновление контакта, в заполненную корзину новые контакты не попадают
+func (t *table) add(c Contact) {
+	index := bucketIndex(t.self, c.ID)
+	if index < 0 {
+		return
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	bucket := t.buckets[index]
+
+	i := slices.IndexFunc(bucket, func(known Contact) bool { return known.ID == c.ID })
+	if i >= 0 {
+		// Более старая подпись не заменяет известный адрес
+		if bucket[i].TS.After(c.TS) {
+			c = bucket[i]
+		}
+
+		// Контакт перемещается в конец как недавно активный
+		bucket = append(slices.Delete(bucket, i, i+1), c)
+	} else if len(bucket) < bucketSize {
+		bucket = append(bucket, c)
+	}
+
+	t.buckets[index] = bucket
+}
+
+func (t *table) remove(id ID) {
+	index := bucketIndex(t.self, id)
+	if index < 0 {
+		return
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.buckets[index] = slices.DeleteFunc(t.buckets[index], func(c Contact) bool { return c.ID == id })
+}
+
+// closest - до count контактов ближайших к target
+func (t *table) closest(target ID, count int) []Contact {
+	t.mutex.RLock()
+
+	var result []Contact
+
+	for _, bucket := range t.buckets {
+		result = append(result, bucket...)
+	}
+
+	t.mutex.RUnlock()
+
+	sortByDistance(target, result)
+
+	if len(result) > count {
+		result = result[:count]
+	}
+
+	return result
+}
+
+func (t *table) size() int {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	count := 0
+
+	for _, bucket := range t.buckets {
+		count += len(bucket)
+	}
+
+	return count
+}
+
+func sortByDistance(target ID, contacts []Contact) {
+	slices.SortFunc(contacts, func(a, b Contact) int {
+		switch {
+		case a.ID == b.ID:
+			return 0
+		case closer(target, a.ID, b.ID):
+			return -1
+		default:
+			return 1
+		}
+	})
+}
@@ -0,0 +1,169 @@
+package e2e
+
+import (
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	GroupKeySize = chacha20poly1305.KeySize
+
+	wrapInfo = "p2p-chat/channel-key/v1"
+)
+
+var ErrDecrypt = errors.New("decrypt failed")
+
+func NewGroupKey() ([]byte, error) {
+	key := make([]byte, GroupKeySize)
+
+	_, err := rand.Read(key)
+	if err != nil {
+		return nil, fmt.Errorf("group key: %w", err)
+	}
+
+	return key, nil
+}
+
+// Encrypt - шифрует сообщение групповым ключом, канал и идентификатор
+// привязываются к шифротексту чтобы его нельзя было переслать в другое место
+func Encrypt(groupKey []byte, channel, id string, plaintext []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.NewX(groupKey)
+	if err != nil {
+		return nil, fmt.Errorf("aead: %w", err)
+	}
+
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, fmt.Errorf("nonce: %w", err)
+	}
+
+	return aead.Seal(nonce, nonce, plaintext, messageAD(channel, id)), nil
+}
+
+func Decrypt(groupKey []byte, channel, id string, ciphertext []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.NewX(groupKey)
+	if err != nil {
+		return nil, fmt.Errorf("aead: %w", err)
+	}
+
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, ErrDecrypt
+	}
+
+	plaintext, err := aead.Open(nil, ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():], messageAD(channel, id))
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+
+	return plaintext, nil
+}
+
+// WrapKey - шифрует групповой ключ для участника: эфемерный X25519 обмен,
+// HKDF для ключа обертки и AEAD, результат - эфемерный ключ, nonce и шифротекст
+func WrapKey(groupKey []byte, recipient *ecdh.PublicKey, channel string, epoch uint32) ([]byte, error) {
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("ephemeral key: %w", err)
+	}
+
+	shared, err := ephemeral.ECDH(recipient)
+	if err != nil {
+		return nil, fmt.Errorf("ecdh: %w", err)
+	}
+
+	ephemeralPublic := ephemeral.PublicKey().Bytes()
+
+	aead, err := wrapAEAD(shared, ephemeralPublic, recipient.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, fmt.Errorf("nonce: %w", err)
+	}
+
+	result := make([]byte, 0, len(ephemeralPublic)+len(nonce)+len(groupKey)+aead.Overhead())
+	result = append(result, ephemeralPublic...)
+	result = append(result, nonce...)
+
+	return aead.Seal(result, nonce, groupKey, wrapAD(channel, epoch)), nil
+}
+
+func UnwrapKey(wrapped []byte, private *ecdh.PrivateKey, channel string, epoch uint32) ([]byte, error) {
+	const publicKeySize = 32
+
+	if len(wrapped) < publicKeySize+chacha20poly1305.NonceSizeX {
+		return nil, ErrDecrypt
+	}
+
+	ephemeralPublic, err := ecdh.X25519().NewPublicKey(wrapped[:publicKeySize])
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+
+	shared, err := private.ECDH(ephemeralPublic)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+
+	aead, err := wrapAEAD(shared, wrapped[:publicKeySize], private.PublicKey().Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := wrapped[publicKeySize : publicKeySize+aead.NonceSize()]
+
+	groupKey, err := aead.Open(nil, nonce, wrapped[publicKeySize+aead.NonceSize():], wrapAD(channel, epoch))
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+
+	return groupKey, nil
+}
+
+func wrapAEAD(shared, ephemeralPublic, recipientPublic []byte) (cipher.AEAD, error) {
+	salt := make([]byte, 0, len(ephemeralPublic)+len(recipientPublic))
+	salt = append(salt, ephemeralPublic...)
+	salt = append(salt, recipientPublic...)
+
+	key := make([]byte, chacha20poly1305.KeySize)
+
+	_, err := io.ReadFull(hkdf.New(sha256.New, shared, salt, []byte(wrapInfo)), key)
+	if err != nil {
+		return nil, fmt.Errorf("hkdf: %w", err)
+	}
+
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, fmt.Errorf("aead: %w", err)
+	}
+
+	return aead, nil
+}
+
+func messageAD(channel, id string) []byte {
+	ad := binary.BigEndian.AppendUint32(nil, uint32(len(channel)))
+	ad = append(ad, channel...)
+
+	return append(ad, id...)
+}
+
+func wrapAD(channel string, epoch uint32) []byte {
+	ad := binary.BigEndian.AppendUint32(nil, epoch)
+
+	return append(ad, channel...)
+}
@@ -0,0 +1,85 @@
+package e2e
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"testing"
+)
+
+func TestEncrypt(t *testing.T) {
+	key, err := NewGroupKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ciphertext, err := Encrypt(key, "room", "m1", []byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plaintext, err := Decrypt(key, "room", "m1", ciphertext)
+	if err != nil || string(plaintext) != "hello" {
+		t.Fatalf("unexpected plaintext: %q %v", plaintext, err)
+	}
+
+	other, err := NewGroupKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Шифротекст привязан к ключу, каналу и идентификатору сообщения
+	for name, decrypt := range map[string]func() ([]byte, error){
+		"key":       func() ([]byte, error) { return Decrypt(other, "room", "m1", ciphertext) },
+		"channel":   func() ([]byte, error) { return Decrypt(key, "other", "m1", ciphertext) },
+		"id":        func() ([]byte, error) { return Decrypt(key, "room", "m2", ciphertext) },
+		"shifted":   func() ([]byte, error) { return Decrypt(key, "roomm", "1", ciphertext) },
+		"truncated": func() ([]byte, error) { return Decrypt(key, "room", "m1", ciphertext[:10]) },
+	} {
+		_, err := decrypt()
+		if !errors.Is(err, ErrDecrypt) {
+			t.Fatalf("decrypt with other %s: %v", name, err)
+		}
+	}
+}
+
+func TestWrapKey(t *testing.T) {
+	groupKey, err := NewGroupKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	private, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wrapped, err := WrapKey(groupKey, private.PublicKey(), "room", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unwrapped, err := UnwrapKey(wrapped, private, "room", 2)
+	if err != nil || !bytes.Equal(unwrapped, groupKey) {
+		t.Fatalf("unexpected group key: %v", err)
+	}
+
+	other, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Ключ одной эпохи нельзя выдать за ключ другой эпохи или канала
+	for name, unwrap := range map[string]func() ([]byte, error){
+		"recipient": func() ([]byte, error) { return UnwrapKey(wrapped, other, "room", 2) },
+		"channel":   func() ([]byte, error) { return UnwrapKey(wrapped, private, "other", 2) },
+		"epoch":     func() ([]byte, error) { return UnwrapKey(wrapped, private, "room", 1) },
+		"truncated": func() ([]byte, error) { return UnwrapKey(wrapped[:40], private, "room", 2) },
+	} {
+		_, err := unwrap()
+		if !errors.Is(err, ErrDecrypt) {
+			t.Fatalf("unwrap with other %s: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package entities
+
+import "strings"
+
+// ChannelKeyShare - групповой ключ эпохи, зашифрованный для одного участника
+type ChannelKeyShare struct {
+	Chat      string
+	Epoch     uint32
+	Owner     string
+	Login     string
+	Key       []byte
+	Signature []byte
+}
+
+// ChannelEncryption - текущее состояние шифрования канала
+type ChannelEncryption struct {
+	Owner   string
+	Epoch   uint32
+	Members []string
+}
+
+// SplitChannel - канал name@domain размещен на сервере domain, для каналов без домена domain пуст
+func SplitChannel(channel string) (name, domain string) {
+	i := strings.LastIndex(channel, "@")
+	if i < 0 {
+		return channel, ""
+	}
+
+	return channel[:i], channel[i+1:]
+}
+
+// directPrefix - личная переписка адресуется как канал "dm:login", канал "dm:" - собственный ящик личных сообщений
+const directPrefix = "dm:"
+
+// DirectChannel - канал личной переписки с пользователем
+func DirectChannel(login string) string {
+	return directPrefix + login
+}
+
+// DirectPeer - собеседник личной переписки, false для обычных каналов
+func DirectPeer(channel string) (string, bool) {
+	return strings.CutPrefix(channel, directPrefix)
+}
@@ -0,0 +1,22 @@
+package entities
+
+type ConnectionState int
+
+const (
+	ConnectionConnecting ConnectionState = iota
+	ConnectionOnline
+	ConnectionReconnecting
+)
+
+func (s ConnectionState) String() string {
+	switch s {
+	case ConnectionConnecting:
+		return "connecting"
+	case ConnectionOnline:
+		return "online"
+	case ConnectionReconnecting:
+		return "reconnecting"
+	default:
+		return "unknown"
+	}
+}
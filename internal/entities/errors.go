@@ -0,0 +1,9 @@
+package entities
+
+import "errors"
+
+var (
+	ErrNotFound      = errors.New("not found")
+	ErrAlreadyExists = errors.New("already exists")
+	ErrTooLarge      = errors.New("too large")
+)
@@ -0,0 +1,55 @@
+package entities
+
+import "time"
+
+// EventKind - вид эфемерного события канала, такие события не сохраняются
+type EventKind int
+
+const (
+	EventTyping EventKind = iota
+	EventPresence
+)
+
+type PresenceStatus int
+
+const (
+	PresenceOnline PresenceStatus = iota
+	PresenceAway
+	// PresenceLeft - пользователь покинул канал
+	PresenceLeft
+	// PresenceJoined - пользователь подписался на канал
+	PresenceJoined
+	// PresenceOffline - подписка пользователя прервалась без выхода из канала
+	PresenceOffline
+)
+
+func (s PresenceStatus) String() string {
+	switch s {
+	case PresenceOnline:
+		return "online"
+	case PresenceAway:
+		return "away"
+	case PresenceLeft:
+		return "left"
+	case PresenceJoined:
+		return "joined"
+	case PresenceOffline:
+		return "offline"
+	default:
+		return "unknown"
+	}
+}
+
+type Event struct {
+	Kind     EventKind
+	Chat     string
+	User     string
+	Presence PresenceStatus
+	TS       time.Time
+}
+
+// Member - участник канала, подписанный на него в данный момент
+type Member struct {
+	User     string
+	Presence PresenceStatus
+}
@@ -3,11 +3,86 @@ package entities
 import "time"
 
 type Message struct {
+	ID            string
 	Chat          string
+	Seq           uint64
 	User          string
 	Domain        string
 	Text          string
 	TS            time.Time
 	IsOwn         bool
 	IsLocalDomain bool
+	// To - получатель личного сообщения, пусто для сообщений каналов
+	To string
+	// Edited - время изменения текста автором, после изменения подпись сделана с этим временем
+	Edited time.Time
+	// ChangeSeq - номер последнего изменения сообщения, изменения получают номера из той же
+	// последовательности канала что и новые сообщения, 0 если сообщение не изменялось
+	ChangeSeq uint64
+	// Deleted - сообщение удалено, текст и подпись не сохраняются
+	Deleted bool
+	// Reactions - реакции в порядке появления, не входят в подпись сообщения
+	Reactions []Reaction
+	// ReplyTo - идентификатор сообщения того же чата, на которое это сообщение является ответом
+	ReplyTo string
+	// Kind - вид сообщения, задается при отправке и не меняется при изменении текста
+	Kind MessageKind
+
+	Signature       []byte
+	PublicKey       []byte
+	SignatureStatus SignatureStatus
+
+	// Ciphertext - содержимое сообщения зашифрованного канала, Text при этом пуст
+	Ciphertext []byte
+	KeyEpoch   uint32
+	// DecryptFailed - клиент не смог расшифровать сообщение
+	DecryptFailed bool
+
+	Delivery DeliveryState
+
+	// System - служебная запись чата (например выход участника), не является сообщением пользователя
+	System bool
+}
+
+// Position - последний номер канала относящийся к сообщению: номер отправки или последнего изменения
+func (m Message) Position() uint64 {
+	return max(m.Seq, m.ChangeSeq)
+}
+
+// Reaction - реакция на сообщение (эмодзи или короткая строка) и поставившие ее пользователи
+type Reaction struct {
+	Emoji string
+	Users []string
 }
+
+// MessageKind - вид сообщения, определяет как оно показывается в чате
+type MessageKind int
+
+const (
+	MessageText MessageKind = iota
+	// MessageAction - действие автора, команда /me
+	MessageAction
+	// MessageTopic - смена темы чата, команда /topic
+	MessageTopic
+)
+
+// DeliveryState - состояние отправки собственного сообщения
+type DeliveryState int
+
+const (
+	DeliveryDelivered DeliveryState = iota
+	// DeliveryPending - сообщение в очереди на отправку
+	DeliveryPending
+	// DeliveryFailed - сообщение не удалось отправить за отведенное время
+	DeliveryFailed
+)
+
+type SignatureStatus int
+
+const (
+	SignatureUnsigned SignatureStatus = iota
+	SignatureValid
+	SignatureInvalid
+	// SignatureUnknownKey - ключ отправителя не совпадает с ранее известным
+	SignatureUnknownKey
+)
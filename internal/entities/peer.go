@@ -0,0 +1,8 @@
+package entities
+
+// Peer - узел p2p сети, найденный в локальной сети или подключенный напрямую
+type Peer struct {
+	ID        string
+	Addr      string
+	Connected bool
+}
@@ -0,0 +1,21 @@
+package entities
+
+import "time"
+
+type User struct {
+	Login        string
+	PasswordHash string
+	PublicKey    []byte
+	CreatedAt    time.Time
+
+	// EncryptionKey - публичный X25519 ключ, подписанный ключом PublicKey
+	EncryptionKey          []byte
+	EncryptionKeySignature []byte
+}
+
+type Session struct {
+	// TokenHash - хеш токена, сам токен на сервере не хранится
+	TokenHash string
+	Login     string
+	ExpiresAt time.Time
+}
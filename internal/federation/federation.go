@@ -0,0 +1,557 @@
+package federation
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gbh007/p2p-chat/internal/entities"
+	"github.com/gbh007/p2p-chat/internal/identity"
+	"github.com/gbh007/p2p-chat/internal/protoconv"
+	"github.com/gbh007/p2p-chat/internal/server"
+	"github.com/gbh007/p2p-chat/proto/gen"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	maxClockSkew    = 5 * time.Minute
+	requestTimeout  = 10 * time.Second
+	minFollowDelay  = 500 * time.Millisecond
+	maxFollowDelay  = 30 * time.Second
+	readerKeyPrefix = "@"
+	nonceSize       = 32
+)
+
+// Federation - обмен сообщениями каналов между серверами. Канал name@domain размещен
+// на сервере domain: остальные серверы пересылают ему сообщения своих пользователей
+// и получают от него поток сообщений канала. Сообщения пересылаются только серверу канала
+// и никогда дальше, поэтому петли невозможны, маршрут в запросе защищает от ошибок конфигурации
+type Federation struct {
+	gen.UnimplementedFederationServer
+	logger *slog.Logger
+
+	domain string
+	// keystore - ключ сервера и закрепленные при первом обращении ключи других серверов
+	keystore *identity.Keystore
+	server   *server.Server
+
+	// addrs - адреса серверов по доменам
+	addrs map[string]string
+	// open - подключение к серверам не указанным в addrs, доменом служит адрес
+	open      bool
+	transport credentials.TransportCredentials
+
+	// following - остановка чтения каналов других серверов
+	following map[string]context.CancelFunc
+	conns     map[string]*grpc.ClientConn
+	mutex     *sync.Mutex
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func New(
+	domain string,
+	keystore *identity.Keystore,
+	srv *server.Server,
+	addrs map[string]string,
+	open bool,
+	transport credentials.TransportCredentials,
+) *Federation {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	f := &Federation{
+		logger:    slog.Default(),
+		domain:    domain,
+		keystore:  keystore,
+		server:    srv,
+		addrs:     addrs,
+		open:      open,
+		transport: transport,
+		following: make(map[string]context.CancelFunc),
+		conns:     make(map[string]*grpc.ClientConn),
+		mutex:     &sync.Mutex{},
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+
+	srv.SetFederation(domain, f)
+
+	return f
+}
+
+// Close - остановка подписок на каналы других серверов
+func (f *Federation) Close() {
+	f.cancel()
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	for domain, conn := range f.conns {
+		_ = conn.Close()
+		delete(f.conns, domain)
+	}
+}
+
+func (f *Federation) SendRemote(ctx context.Context, msg entities.Message) (uint64, error) {
+	name, domain := entities.SplitChannel(msg.Chat)
+
+	client, err := f.client(domain)
+	if err != nil {
+		return 0, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	digest := identity.ForwardDigest(name, msg.User, msg.Text, msg.Kind, msg.TS, msg.ReplyTo, msg.ID, msg.PublicKey, msg.Signature)
+
+	res, err := client.Forward(ctx, &gen.ForwardRequest{
+		Auth:    f.sign(gen.Federation_Forward_FullMethodName, name, digest),
+		Channel: name,
+		Message: &gen.ReadMessagesResponse{
+			Login:     msg.User,
+			Message:   msg.Text,
+			Ts:        timestamppb.New(msg.TS),
+			Id:        msg.ID,
+			Signature: msg.Signature,
+			PublicKey: msg.PublicKey,
+			ReplyTo:   msg.ReplyTo,
+			Kind:      protoconv.KindToProto(msg.Kind),
+		},
+		Route: []string{f.domain},
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return res.GetSeq(), nil
+}
+
+func (f *Federation) Follow(chat string) error {
+	_, domain := entities.SplitChannel(chat)
+
+	err := f.checkPeer(domain)
+	if err != nil {
+		return err
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if _, ok := f.following[chat]; ok {
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(f.ctx)
+	f.following[chat] = cancel
+
+	go f.follow(ctx, chat)
+
+	return nil
+}
+
+// Unfollow - остановка чтения канала, соединение закрывается когда с сервером не осталось подписок
+func (f *Federation) Unfollow(chat string) {
+	_, domain := entities.SplitChannel(chat)
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	cancel, ok := f.following[chat]
+	if !ok {
+		return
+	}
+
+	cancel()
+	delete(f.following, chat)
+
+	for following := range f.following {
+		if _, d := entities.SplitChannel(following); d == domain {
+			return
+		}
+	}
+
+	if conn, ok := f.conns[domain]; ok {
+		_ = conn.Close()
+		delete(f.conns, domain)
+	}
+}
+
+// follow - чтение канала с его сервера с переподключением, продолжается с последнего сохраненного номера
+func (f *Federation) follow(ctx context.Context, chat string) {
+	name, domain := entities.SplitChannel(chat)
+	delay := minFollowDelay
+
+	for ctx.Err() == nil {
+		received, err := f.readRemote(ctx, chat, name, domain)
+		if received {
+			delay = minFollowDelay
+		}
+
+		if err != nil && ctx.Err() == nil {
+			f.logger.Warn("follow remote channel", "chan", chat, "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+
+		delay = min(delay*2, maxFollowDelay)
+	}
+}
+
+func (f *Federation) readRemote(ctx context.Context, chat, name, domain string) (bool, error) {
+	since, err := f.server.LastSeq(ctx, chat)
+	if err != nil {
+		return false, err
+	}
+
+	client, err := f.client(domain)
+	if err != nil {
+		return false, err
+	}
+
+	stream, err := client.Subscribe(ctx, &gen.SubscribeRequest{
+		Auth:    f.sign(gen.Federation_Subscribe_FullMethodName, name, strconv.FormatUint(since, 10)),
+		Channel: name,
+		Since:   since,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	received := false
+
+	for {
+		raw, err := stream.Recv()
+		if err != nil {
+			return received, err
+		}
+
+		received = true
+
+		msg := entities.Message{
+			ID:        raw.GetId(),
+			Chat:      chat,
+			Seq:       raw.GetSeq(),
+			User:      raw.GetLogin(),
+			Domain:    raw.GetDomain(),
+			Text:      raw.GetMessage(),
+			TS:        raw.GetTs().AsTime(),
+			Signature: raw.GetSignature(),
+			PublicKey: raw.GetPublicKey(),
+			ReplyTo:   raw.GetReplyTo(),
+			ChangeSeq: raw.GetChangeSeq(),
+			Deleted:   raw.GetDeleted(),
+
+			Ciphertext: raw.GetCiphertext(),
+			KeyEpoch:   raw.GetKeyEpoch(),
+		}
+
+		if raw.Edited != nil {
+			msg.Edited = raw.GetEdited().AsTime()
+		}
+
+		// Неизвестный вид показывается как обычный текст, подпись такого сообщения клиент не подтвердит
+		msg.Kind, _ = protoconv.KindFromProto(raw.GetKind())
+
+		for _, reaction := range raw.GetReactions() {
+			msg.Reactions = append(msg.Reactions, entities.Reaction{Emoji: reaction.GetEmoji(), Users: reaction.GetUsers()})
+		}
+
+		// Пользователи этого сервера показываются как локальные
+		if msg.Domain == f.domain {
+			msg.Domain = ""
+		}
+
+		err = f.server.DeliverSeq(ctx, msg)
+		if err != nil {
+			return received, err
+		}
+	}
+}
+
+func (f *Federation) Forward(ctx context.Context, req *gen.ForwardRequest) (*gen.ForwardResponse, error) {
+	raw := req.GetMessage()
+
+	kind, ok := protoconv.KindFromProto(raw.GetKind())
+	if !ok {
+		return nil, status.Error(codes.InvalidArgument, "unknown message kind")
+	}
+
+	// Подпись сервера покрывает все поля сообщения, а не только идентификатор
+	digest := identity.ForwardDigest(
+		req.GetChannel(), raw.GetLogin(), raw.GetMessage(), kind, raw.GetTs().AsTime(),
+		raw.GetReplyTo(), raw.GetId(), raw.GetPublicKey(), raw.GetSignature(),
+	)
+
+	origin, err := f.verify(ctx, req.GetAuth(), gen.Federation_Forward_FullMethodName, req.GetChannel(), digest)
+	if err != nil {
+		return nil, err
+	}
+
+	if slices.Contains(req.GetRoute(), f.domain) {
+		return nil, status.Error(codes.FailedPrecondition, "routing loop")
+	}
+
+	err = f.checkHome(req.GetChannel())
+	if err != nil {
+		return nil, err
+	}
+
+	if raw.GetId() == "" || raw.GetLogin() == "" {
+		return nil, status.Error(codes.InvalidArgument, "incomplete message")
+	}
+
+	// Подпись автора проверяется если она есть, неподписанные сообщения подтверждает сервер отправителя
+	if len(raw.GetSignature()) > 0 {
+		signedText := identity.SignedText(raw.GetMessage(), nil)
+
+		if !identity.VerifyMessage(raw.GetPublicKey(), req.GetChannel(), signedText, kind, raw.GetTs().AsTime(), raw.GetId(), raw.GetSignature()) {
+			return nil, status.Error(codes.PermissionDenied, "invalid signature")
+		}
+	}
+
+	msg, err := f.server.Accept(ctx, entities.Message{
+		ID:        raw.GetId(),
+		Chat:      req.GetChannel(),
+		User:      raw.GetLogin(),
+		Domain:    origin,
+		Text:      raw.GetMessage(),
+		TS:        raw.GetTs().AsTime(),
+		Signature: raw.GetSignature(),
+		PublicKey: raw.GetPublicKey(),
+		ReplyTo:   raw.GetReplyTo(),
+		Kind:      kind,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	f.logger.Debug("accept forwarded message", "chan", req.GetChannel(), "origin", origin)
+
+	return &gen.ForwardResponse{Seq: msg.Seq}, nil
+}
+
+func (f *Federation) Subscribe(req *gen.SubscribeRequest, stream grpc.ServerStreamingServer[gen.ReadMessagesResponse]) error {
+	origin, err := f.verify(stream.Context(), req.GetAuth(), gen.Federation_Subscribe_FullMethodName, req.GetChannel(), strconv.FormatUint(req.GetSince(), 10))
+	if err != nil {
+		return err
+	}
+
+	err = f.checkHome(req.GetChannel())
+	if err != nil {
+		return err
+	}
+
+	f.logger.Info("remote server subscribed", "chan", req.GetChannel(), "origin", origin)
+
+	return f.server.Listen(stream.Context(), req.GetChannel(), readerKeyPrefix+origin, req.GetSince(), func(msg entities.Message) error {
+		domain := msg.Domain
+		if domain == "" {
+			domain = f.domain
+		}
+
+		res := &gen.ReadMessagesResponse{
+			Login:     msg.User,
+			Message:   msg.Text,
+			Ts:        timestamppb.New(msg.TS),
+			Seq:       msg.Seq,
+			Id:        msg.ID,
+			Signature: msg.Signature,
+			PublicKey: msg.PublicKey,
+			Domain:    domain,
+			ReplyTo:   msg.ReplyTo,
+			ChangeSeq: msg.ChangeSeq,
+			Kind:      protoconv.KindToProto(msg.Kind),
+			Deleted:   msg.Deleted,
+
+			// Ключи канала участники получают с сервера канала, серверы пересылают только шифротекст
+			Ciphertext: msg.Ciphertext,
+			KeyEpoch:   msg.KeyEpoch,
+		}
+
+		if !msg.Edited.IsZero() {
+			res.Edited = timestamppb.New(msg.Edited)
+		}
+
+		for _, reaction := range msg.Reactions {
+			res.Reactions = append(res.Reactions, &gen.Reaction{Emoji: reaction.Emoji, Users: reaction.Users})
+		}
+
+		return stream.Send(res)
+	})
+}
+
+// checkHome - серверы принимают запросы только для своих каналов
+func (f *Federation) checkHome(channel string) error {
+	_, domain := entities.SplitChannel(channel)
+	if domain != "" {
+		return status.Error(codes.InvalidArgument, "channel is not hosted by this server")
+	}
+
+	return nil
+}
+
+func (f *Federation) sign(method, channel, ref string) *gen.ServerAuth {
+	ts := time.Now()
+
+	return &gen.ServerAuth{
+		Domain:    f.domain,
+		PublicKey: f.keystore.PublicKey(),
+		Ts:        timestamppb.New(ts),
+		Signature: identity.SignServerRequest(f.keystore.PrivateKey(), f.domain, method, channel, ref, ts),
+	}
+}
+
+// Identify - подпись случайной строки ключом этого сервера, по ответу другие серверы
+// убеждаются что домен обслуживается сервером с этим ключом
+func (f *Federation) Identify(_ context.Context, req *gen.IdentifyRequest) (*gen.IdentifyResponse, error) {
+	if len(req.GetNonce()) != nonceSize {
+		return nil, status.Error(codes.InvalidArgument, "invalid nonce")
+	}
+
+	return &gen.IdentifyResponse{
+		Auth: f.sign(gen.Federation_Identify_FullMethodName, "", hex.EncodeToString(req.GetNonce())),
+	}, nil
+}
+
+// verify - проверка подписи сервера, ключ домена закрепляется при первом обращении
+// после подтверждения сервером, который отвечает по адресу домена
+func (f *Federation) verify(ctx context.Context, auth *gen.ServerAuth, method, channel, ref string) (string, error) {
+	domain := auth.GetDomain()
+
+	if domain == "" || domain == f.domain {
+		return "", status.Error(codes.InvalidArgument, "invalid server domain")
+	}
+
+	ts := auth.GetTs().AsTime()
+	if time.Since(ts).Abs() > maxClockSkew {
+		return "", status.Error(codes.InvalidArgument, "request time too far from server time")
+	}
+
+	if !identity.VerifyServerRequest(auth.GetPublicKey(), domain, method, channel, ref, ts, auth.GetSignature()) {
+		return "", status.Error(codes.Unauthenticated, "invalid server signature")
+	}
+
+	known, ok := f.keystore.KnownKey(domain)
+	if ok {
+		if !bytes.Equal(known, auth.GetPublicKey()) {
+			return "", status.Error(codes.PermissionDenied, "server key changed")
+		}
+
+		return domain, nil
+	}
+
+	err := f.confirm(ctx, domain, auth.GetPublicKey())
+	if err != nil {
+		f.logger.Warn("confirm server domain", "domain", domain, "error", err)
+		return "", status.Error(codes.PermissionDenied, "server domain not confirmed")
+	}
+
+	ok, err = f.keystore.Pin(domain, ed25519.PublicKey(auth.GetPublicKey()))
+	if err != nil {
+		f.logger.Error("pin server key", "domain", domain, "error", err)
+		return "", status.Error(codes.Internal, "pin server key")
+	}
+
+	if !ok {
+		return "", status.Error(codes.PermissionDenied, "server key changed")
+	}
+
+	return domain, nil
+}
+
+// confirm - запрос к серверу по адресу домена, иначе любой сервер мог бы первым
+// назваться чужим доменом и закрепить за ним свой ключ
+func (f *Federation) confirm(ctx context.Context, domain string, key []byte) error {
+	client, err := f.client(domain)
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, nonceSize)
+
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return fmt.Errorf("nonce: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	res, err := client.Identify(ctx, &gen.IdentifyRequest{Nonce: nonce})
+	if err != nil {
+		return fmt.Errorf("identify: %w", err)
+	}
+
+	auth := res.GetAuth()
+
+	if auth.GetDomain() != domain || !bytes.Equal(auth.GetPublicKey(), key) {
+		return errors.New("domain is served with another key")
+	}
+
+	// Время подписи не проверяется, повтор ответа исключает случайная строка
+	if !identity.VerifyServerRequest(key, domain, gen.Federation_Identify_FullMethodName, "", hex.EncodeToString(nonce), auth.GetTs().AsTime(), auth.GetSignature()) {
+		return errors.New("invalid identify signature")
+	}
+
+	return nil
+}
+
+// checkPeer - с сервером можно связаться если он указан в addrs или разрешены любые серверы,
+// иначе пользователи могли бы заставить сервер подключаться к произвольным адресам
+func (f *Federation) checkPeer(domain string) error {
+	if domain == "" || domain == f.domain {
+		return status.Error(codes.InvalidArgument, "invalid remote domain")
+	}
+
+	if _, ok := f.addrs[domain]; !ok && !f.open {
+		return status.Errorf(codes.PermissionDenied, "server %s is not a federation peer", domain)
+	}
+
+	return nil
+}
+
+func (f *Federation) client(domain string) (gen.FederationClient, error) {
+	err := f.checkPeer(domain)
+	if err != nil {
+		return nil, err
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	conn, ok := f.conns[domain]
+	if !ok {
+		addr, ok := f.addrs[domain]
+		if !ok {
+			addr = domain
+		}
+
+		conn, err = grpc.NewClient(addr, grpc.WithTransportCredentials(f.transport))
+		if err != nil {
+			return nil, err
+		}
+
+		f.conns[domain] = conn
+	}
+
+	return gen.NewFederationClient(conn), nil
+}
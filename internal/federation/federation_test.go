@@ -0,0 +1,339 @@
+package federation_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gbh007/p2p-chat/internal/auth"
+	"github.com/gbh007/p2p-chat/internal/entities"
+	"github.com/gbh007/p2p-chat/internal/federation"
+	"github.com/gbh007/p2p-chat/internal/identity"
+	"github.com/gbh007/p2p-chat/internal/server"
+	"github.com/gbh007/p2p-chat/internal/storage"
+	"github.com/gbh007/p2p-chat/internal/ulid"
+	"github.com/gbh007/p2p-chat/proto/gen"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const waitTimeout = 5 * time.Second
+
+type testServer struct {
+	domain   string
+	keystore *identity.Keystore
+	server   *server.Server
+	store    *storage.Memory
+	fed      *federation.Federation
+}
+
+// startServer - сервер федерации на свободном порту localhost, доменом служит его адрес
+func startServer(t *testing.T) *testServer {
+	t.Helper()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := newServer(t, lis.Addr().String())
+
+	grpcServer := grpc.NewServer()
+	gen.RegisterFederationServer(grpcServer, ts.fed)
+
+	go func() {
+		_ = grpcServer.Serve(lis)
+	}()
+
+	t.Cleanup(grpcServer.Stop)
+
+	return ts
+}
+
+// newServer - сервер федерации без входящих подключений
+func newServer(t *testing.T, domain string) *testServer {
+	t.Helper()
+
+	keystore, err := identity.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := storage.NewMemory(100)
+	srv := server.New(store, store, auth.New(store), server.DefaultLimits())
+	fed := federation.New(domain, keystore, srv, nil, true, insecure.NewCredentials())
+
+	t.Cleanup(fed.Close)
+
+	return &testServer{
+		domain:   domain,
+		keystore: keystore,
+		server:   srv,
+		store:    store,
+		fed:      fed,
+	}
+}
+
+// waitMessages - ожидание пока check не примет сохраненные сообщения канала
+func waitMessages(t *testing.T, ts *testServer, chat string, check func([]entities.Message) bool) []entities.Message {
+	t.Helper()
+
+	deadline := time.Now().Add(waitTimeout)
+
+	for {
+		messages, err := ts.store.MessagesAfter(context.Background(), chat, 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if check(messages) {
+			return messages
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("unexpected messages of %s: %+v", chat, messages)
+		}
+
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestFollowRemoteChannel(t *testing.T) {
+	ctx := context.Background()
+	origin := startServer(t)
+	follower := startServer(t)
+	remote := "room@" + origin.domain
+
+	plain := entities.Message{ID: ulid.New(), Chat: "room", User: "alice", Text: "hello", Kind: entities.MessageAction, TS: time.Now(), PublicKey: []byte("alice key")}
+	encrypted := entities.Message{ID: ulid.New(), Chat: "room", User: "alice", TS: time.Now(), Ciphertext: []byte("sealed"), KeyEpoch: 2}
+
+	for _, msg := range []entities.Message{plain, encrypted} {
+		_, err := origin.server.Deliver(ctx, msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := follower.fed.Follow(remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messages := waitMessages(t, follower, remote, func(messages []entities.Message) bool { return len(messages) == 2 })
+
+	if messages[0].Text != "hello" || messages[0].Kind != entities.MessageAction || messages[0].Domain != origin.domain {
+		t.Fatalf("unexpected message: %+v", messages[0])
+	}
+
+	if string(messages[1].Ciphertext) != "sealed" || messages[1].KeyEpoch != 2 {
+		t.Fatalf("ciphertext is not federated: %+v", messages[1])
+	}
+
+	// Сообщение пользователя сервера-подписчика проходит через сервер канала и возвращается в поток
+	_, err = follower.server.SendMessage(auth.WithLogin(ctx, "bob"), &gen.SendMessageRequest{
+		Channel: remote,
+		Id:      ulid.New(),
+		Message: "hi",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waitMessages(t, follower, remote, func(messages []entities.Message) bool {
+		return len(messages) == 3 && messages[2].User == "bob" && messages[2].Domain == ""
+	})
+
+	stored := waitMessages(t, origin, "room", func(messages []entities.Message) bool { return len(messages) == 3 })
+	if stored[2].Domain != follower.domain {
+		t.Fatalf("forwarded message must keep the origin domain: %+v", stored[2])
+	}
+
+	// Удаление на сервере канала доходит до подписчика
+	deleted, err := origin.server.DeliverChange(ctx, entities.Message{
+		ID:        plain.ID,
+		Chat:      "room",
+		User:      "alice",
+		PublicKey: plain.PublicKey,
+		Deleted:   true,
+		Edited:    time.Now(),
+	})
+	if err != nil || !deleted {
+		t.Fatalf("delete: %v %v", deleted, err)
+	}
+
+	waitMessages(t, follower, remote, func(messages []entities.Message) bool {
+		return messages[0].Deleted && messages[0].Text == ""
+	})
+}
+
+func TestDomainSquatting(t *testing.T) {
+	ctx := context.Background()
+	origin := startServer(t)
+	owner := startServer(t)
+	// Сервер с другим ключом называет себя доменом owner раньше самого owner
+	squatter := newServer(t, owner.domain)
+
+	send := func(fed *federation.Federation) error {
+		_, err := fed.SendRemote(ctx, entities.Message{
+			ID:   ulid.New(),
+			Chat: "room@" + origin.domain,
+			User: "mallory",
+			Text: "hello",
+			TS:   time.Now(),
+		})
+
+		return err
+	}
+
+	err := send(squatter.fed)
+	if status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("squatter must be rejected, got %v", err)
+	}
+
+	err = send(owner.fed)
+	if err != nil {
+		t.Fatalf("domain owner must be accepted after the squatting attempt: %v", err)
+	}
+
+	err = send(squatter.fed)
+	if status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("squatter must be rejected after the owner key is pinned, got %v", err)
+	}
+}
+
+func TestForwardRejectsTamperedMessage(t *testing.T) {
+	ctx := context.Background()
+	origin := startServer(t)
+	sender := startServer(t)
+
+	raw := &gen.ReadMessagesResponse{
+		Login:   "bob",
+		Message: "hello",
+		Ts:      timestamppb.Now(),
+		Id:      ulid.New(),
+	}
+
+	digest := identity.ForwardDigest("room", raw.GetLogin(), raw.GetMessage(), entities.MessageText, raw.GetTs().AsTime(), "", raw.GetId(), nil, nil)
+	ts := time.Now()
+	auth := &gen.ServerAuth{
+		Domain:    sender.domain,
+		PublicKey: sender.keystore.PublicKey(),
+		Ts:        timestamppb.New(ts),
+		Signature: identity.SignServerRequest(sender.keystore.PrivateKey(), sender.domain, gen.Federation_Forward_FullMethodName, "room", digest, ts),
+	}
+
+	forward := func(change func(raw *gen.ReadMessagesResponse)) error {
+		msg := proto.Clone(raw).(*gen.ReadMessagesResponse)
+		change(msg)
+
+		_, err := origin.fed.Forward(ctx, &gen.ForwardRequest{Auth: auth, Channel: "room", Message: msg, Route: []string{sender.domain}})
+
+		return err
+	}
+
+	// Промежуточный узел меняет поля сообщения, подпись сервера отправителя остается прежней
+	tampered := map[string]func(raw *gen.ReadMessagesResponse){
+		"login":    func(raw *gen.ReadMessagesResponse) { raw.Login = "alice" },
+		"text":     func(raw *gen.ReadMessagesResponse) { raw.Message = "bye" },
+		"ts":       func(raw *gen.ReadMessagesResponse) { raw.Ts = timestamppb.New(time.Now().Add(time.Hour)) },
+		"kind":     func(raw *gen.ReadMessagesResponse) { raw.Kind = gen.MessageKind_MESSAGE_TOPIC },
+		"reply_to": func(raw *gen.ReadMessagesResponse) { raw.ReplyTo = ulid.New() },
+	}
+
+	for name, change := range tampered {
+		err := forward(change)
+		if status.Code(err) != codes.Unauthenticated {
+			t.Fatalf("tampered %s must be rejected, got %v", name, err)
+		}
+	}
+
+	err := forward(func(*gen.ReadMessagesResponse) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messages := waitMessages(t, origin, "room", func(messages []entities.Message) bool { return len(messages) == 1 })
+	if messages[0].User != "bob" || messages[0].Text != "hello" {
+		t.Fatalf("unexpected message: %+v", messages[0])
+	}
+}
+
+func TestFollowOnlyPeers(t *testing.T) {
+	origin := startServer(t)
+
+	keystore, err := identity.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := storage.NewMemory(100)
+	srv := server.New(store, store, auth.New(store), server.DefaultLimits())
+	fed := federation.New("closed.example", keystore, srv, map[string]string{"peer.example": origin.domain}, false, insecure.NewCredentials())
+
+	t.Cleanup(fed.Close)
+
+	err = fed.Follow("room@" + origin.domain)
+	if status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("unknown server must be rejected, got %v", err)
+	}
+
+	_, err = fed.SendRemote(context.Background(), entities.Message{ID: ulid.New(), Chat: "room@" + origin.domain, User: "alice", TS: time.Now()})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("unknown server must be rejected, got %v", err)
+	}
+
+	err = fed.Follow("room@peer.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFollowStopsWithoutReaders(t *testing.T) {
+	ctx := context.Background()
+	origin := startServer(t)
+	follower := startServer(t)
+	remote := "room@" + origin.domain
+
+	deliver := func(text string) {
+		_, err := origin.server.Deliver(ctx, entities.Message{ID: ulid.New(), Chat: "room", User: "alice", Text: text, TS: time.Now()})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deliver("first")
+
+	// Локальный читатель канала запускает чтение с сервера канала
+	readCtx, cancel := context.WithCancel(ctx)
+	done := make(chan error, 1)
+
+	go func() {
+		done <- follower.server.Listen(readCtx, remote, "reader", 0, func(entities.Message) error { return nil })
+	}()
+
+	waitMessages(t, follower, remote, func(messages []entities.Message) bool { return len(messages) == 1 })
+
+	cancel()
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	deliver("second")
+
+	time.Sleep(300 * time.Millisecond)
+
+	messages, err := follower.store.MessagesAfter(ctx, remote, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(messages) != 1 {
+		t.Fatalf("channel is still followed without readers: %+v", messages)
+	}
+}
@@ -0,0 +1,116 @@
+package gui
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/awesome-gocui/gocui"
+	"github.com/gbh007/p2p-chat/internal/entities"
+)
+
+var errNotOwnMessage = errors.New("only your own messages can be edited")
+
+// Действия с выбранным сообщением подставляют команду в редактор, выполняется она по Enter
+
+func (gm *Manager) replySelected(g *gocui.Gui, v *gocui.View) error {
+	return gm.selectedAction(g, func(msg entities.Message) (string, error) {
+		return commandPrefix + "reply ", nil
+	})
+}
+
+func (gm *Manager) reactSelected(g *gocui.Gui, v *gocui.View) error {
+	return gm.selectedAction(g, func(msg entities.Message) (string, error) {
+		return commandPrefix + "react ", nil
+	})
+}
+
+// copySelected - текст сообщения копируется в редактор, например для цитирования
+func (gm *Manager) copySelected(g *gocui.Gui, v *gocui.View) error {
+	return gm.selectedAction(g, func(msg entities.Message) (string, error) {
+		if msg.Deleted {
+			return "", nil
+		}
+
+		// Текст похожий на команду отправится как обычный
+		if strings.HasPrefix(msg.Text, commandPrefix) {
+			return commandPrefix + msg.Text, nil
+		}
+
+		return msg.Text, nil
+	})
+}
+
+func (gm *Manager) editSelected(g *gocui.Gui, v *gocui.View) error {
+	return gm.selectedAction(g, func(msg entities.Message) (string, error) {
+		if !msg.IsOwn {
+			return "", errNotOwnMessage
+		}
+
+		return commandPrefix + "edit " + msg.Text, nil
+	})
+}
+
+func (gm *Manager) deleteSelected(g *gocui.Gui, v *gocui.View) error {
+	return gm.selectedAction(g, func(msg entities.Message) (string, error) {
+		return commandPrefix + "delete", nil
+	})
+}
+
+// selectedAction - текст для редактора по выбранному сообщению, ошибка показывается в чате
+func (gm *Manager) selectedAction(g *gocui.Gui, action func(msg entities.Message) (string, error)) error {
+	chat := gm.currentChatName
+
+	msg, ok := gm.selectedMessage(chat)
+	if !ok {
+		gm.notice(g, chat, "error: "+errNoMessage.Error())
+
+		return nil
+	}
+
+	text, err := action(msg)
+	if err != nil {
+		gm.notice(g, chat, "error: "+err.Error())
+
+		return nil
+	}
+
+	return gm.fillMessage(g, text)
+}
+
+// fillMessage - замена текста редактора и переход в него
+func (gm *Manager) fillMessage(g *gocui.Gui, text string) error {
+	v, err := g.SetCurrentView(chatMessageViewName)
+	if err != nil {
+		return err
+	}
+
+	g.Cursor = true
+
+	v.Clear()
+
+	err = v.SetCursor(0, 0)
+	if err != nil {
+		return err
+	}
+
+	// Редактор однострочный, переносы строк заменяются пробелами
+	for _, ch := range strings.ReplaceAll(text, "\n", " ") {
+		v.EditWrite(ch)
+	}
+
+	return nil
+}
+
+// changeTarget - сообщение для /edit и /delete: выбранное в истории или последнее собственное
+func (gm *Manager) changeTarget(chat string) (entities.Message, error) {
+	if index := gm.selectedIndex(chat); index >= 0 {
+		return gm.messages[chat][index], nil
+	}
+
+	msg, ok := gm.lastOwnMessage(chat)
+	if !ok {
+		return entities.Message{}, errNoOwnMessage
+	}
+
+	return msg, nil
+}
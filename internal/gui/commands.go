@@ -0,0 +1,316 @@
+package gui
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/awesome-gocui/gocui"
+	"github.com/gbh007/p2p-chat/internal/entities"
+)
+
+const commandPrefix = "/"
+
+var (
+	errNoChat         = errors.New("no chat selected")
+	errNoMessage      = errors.New("no message in this chat")
+	errNickUnchanging = errors.New("nick is the account login and cannot be changed")
+)
+
+// command - команда редактора сообщений, аргументы проверяются до вызова run
+type command struct {
+	name  string
+	usage string
+	help  string
+	// minArgs, maxArgs - количество слов в аргументах, maxArgs 0 - без ограничения
+	minArgs int
+	maxArgs int
+	run     func(g *gocui.Gui, args string) error
+}
+
+func (gm *Manager) registerCommands() {
+	commands := []command{
+		{name: "join", usage: "/join channel", help: "open a channel", minArgs: 1, maxArgs: 1, run: gm.cmdJoin},
+		{name: "leave", usage: "/leave [channel]", help: "leave the current or given chat", maxArgs: 1, run: gm.cmdLeave},
+		{name: "nick", usage: "/nick", help: "show your login", maxArgs: 1, run: gm.cmdNick},
+		{name: "me", usage: "/me action", help: "send an action", minArgs: 1, run: gm.cmdMe},
+		{name: "msg", usage: "/msg user text", help: "send a direct message", minArgs: 2, run: gm.cmdMsg},
+		{name: "topic", usage: "/topic [text]", help: "show or set the chat topic", run: gm.cmdTopic},
+		{name: "clear", usage: "/clear", help: "clear the chat view", maxArgs: -1, run: gm.cmdClear},
+		{name: "help", usage: "/help [command]", help: "list commands", maxArgs: 1, run: gm.cmdHelp},
+		{name: "edit", usage: "/edit text", help: "replace the text of the selected or your last message", minArgs: 1, run: gm.cmdEdit},
+		{name: "delete", usage: "/delete", help: "delete the selected or your last message", maxArgs: -1, run: gm.cmdDelete},
+		{name: "react", usage: "/react emoji", help: "react to the selected or last message", minArgs: 1, maxArgs: 1, run: gm.cmdReact},
+		{name: "unreact", usage: "/unreact emoji", help: "remove your reaction from the selected or last message", minArgs: 1, maxArgs: 1, run: gm.cmdUnreact},
+		{name: "reply", usage: "/reply text", help: "reply to the selected or last message in its thread", minArgs: 1, run: gm.cmdReply},
+		{name: "search", usage: "/search text", help: "find loaded messages of the chat", minArgs: 1, run: gm.cmdSearch},
+	}
+
+	gm.commands = make(map[string]command, len(commands))
+
+	for _, cmd := range commands {
+		gm.commands[cmd.name] = cmd
+	}
+}
+
+// parseCommand - строка вида "/name args", "//" экранирует обычный текст начинающийся с "/"
+func parseCommand(text string) (name, args string, ok bool) {
+	text = strings.TrimRight(text, "\n")
+
+	if !strings.HasPrefix(text, commandPrefix) || strings.HasPrefix(text, commandPrefix+commandPrefix) {
+		return "", "", false
+	}
+
+	name, args, _ = strings.Cut(text[len(commandPrefix):], " ")
+
+	return name, strings.TrimSpace(args), true
+}
+
+// runCommand - проверка аргументов и вызов обработчика команды
+func (gm *Manager) runCommand(g *gocui.Gui, name, args string) error {
+	cmd, ok := gm.commands[name]
+	if !ok {
+		return fmt.Errorf("unknown command %s%s, see /help", commandPrefix, name)
+	}
+
+	count := len(strings.Fields(args))
+
+	if count < cmd.minArgs ||
+		(cmd.maxArgs > 0 && count > cmd.maxArgs) ||
+		(cmd.maxArgs < 0 && count > 0) {
+		return fmt.Errorf("usage: %s", cmd.usage)
+	}
+
+	return cmd.run(g, args)
+}
+
+// completeCommand - дополнение имени команды по Tab, без команды в редакторе Tab переключает окно
+func (gm *Manager) completeCommand(g *gocui.Gui, v *gocui.View) error {
+	text := strings.TrimRight(v.Buffer(), "\n")
+
+	prefix, ok := strings.CutPrefix(text, commandPrefix)
+	if !ok || strings.Contains(prefix, " ") {
+		return gm.nextView(g, v)
+	}
+
+	matches := make([]string, 0)
+
+	for name := range gm.commands {
+		if strings.HasPrefix(name, prefix) {
+			matches = append(matches, name)
+		}
+	}
+
+	slices.Sort(matches)
+
+	switch len(matches) {
+	case 0:
+		return nil
+	case 1:
+		text = commandPrefix + matches[0] + " "
+	default:
+		text = commandPrefix + commonPrefix(matches)
+
+		gm.notice(g, gm.currentChatName, "commands: "+commandPrefix+strings.Join(matches, " "+commandPrefix))
+	}
+
+	v.Clear()
+	v.WriteString(text)
+
+	return v.SetCursor(len(text), 0)
+}
+
+func commonPrefix(values []string) string {
+	prefix := values[0]
+
+	for _, value := range values[1:] {
+		for !strings.HasPrefix(value, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+
+	return prefix
+}
+
+// notice - служебная строка в истории чата, на сервер не отправляется
+func (gm *Manager) notice(g *gocui.Gui, chat, text string) {
+	v, err := g.View(chatHistoryViewName + chat)
+	if err != nil {
+		return
+	}
+
+	msg := entities.Message{
+		Chat:   chat,
+		Text:   text,
+		TS:     time.Now(),
+		System: true,
+	}
+
+	gm.messages[chat] = append(gm.messages[chat], msg)
+
+	_ = gm.writeMessage(v, msg)
+}
+
+// openChat - открытие чата и переход к нему
+func (gm *Manager) openChat(g *gocui.Gui, name string) error {
+	err := gm.newChat(g, name)
+	if err != nil {
+		return err
+	}
+
+	gm.callbacker.Connect(name)
+
+	return gm.selectChat(g, name)
+}
+
+func (gm *Manager) currentChat() (string, error) {
+	if !slices.Contains(gm.chats, gm.currentChatName) {
+		return "", errNoChat
+	}
+
+	return gm.currentChatName, nil
+}
+
+func (gm *Manager) cmdJoin(g *gocui.Gui, args string) error {
+	if _, ok := entities.DirectPeer(args); ok {
+		return errors.New("use /msg for direct messages")
+	}
+
+	return gm.openChat(g, args)
+}
+
+func (gm *Manager) cmdLeave(g *gocui.Gui, args string) error {
+	if args == "" {
+		chat, err := gm.currentChat()
+		if err != nil {
+			return err
+		}
+
+		return gm.leave(g, chat)
+	}
+
+	if !slices.Contains(gm.chats, args) {
+		return fmt.Errorf("not in chat %s", args)
+	}
+
+	return gm.leave(g, args)
+}
+
+func (gm *Manager) cmdNick(g *gocui.Gui, args string) error {
+	if args != "" {
+		return errNickUnchanging
+	}
+
+	gm.notice(g, gm.currentChatName, "you are "+gm.callbacker.Login())
+
+	return nil
+}
+
+func (gm *Manager) cmdMe(g *gocui.Gui, args string) error {
+	chat, err := gm.currentChat()
+	if err != nil {
+		return err
+	}
+
+	return gm.callbacker.SendAction(chat, args)
+}
+
+func (gm *Manager) cmdMsg(g *gocui.Gui, args string) error {
+	user, text, _ := strings.Cut(args, " ")
+
+	return gm.sendDirect(g, user, strings.TrimSpace(text))
+}
+
+func (gm *Manager) cmdTopic(g *gocui.Gui, args string) error {
+	chat, err := gm.currentChat()
+	if err != nil {
+		return err
+	}
+
+	if args != "" {
+		return gm.callbacker.SetTopic(chat, args)
+	}
+
+	v, err := g.View(chatHistoryViewName + chat)
+	if err != nil {
+		return err
+	}
+
+	if v.Subtitle == "" {
+		gm.notice(g, chat, "no topic")
+	} else {
+		gm.notice(g, chat, "topic: "+v.Subtitle)
+	}
+
+	return nil
+}
+
+func (gm *Manager) cmdClear(g *gocui.Gui, args string) error {
+	chat, err := gm.currentChat()
+	if err != nil {
+		return err
+	}
+
+	v, err := g.View(chatHistoryViewName + chat)
+	if err != nil {
+		return err
+	}
+
+	// Показанные сообщения остаются известными, чтобы не появиться снова
+	gm.messages[chat] = nil
+	v.Clear()
+
+	return nil
+}
+
+func (gm *Manager) cmdHelp(g *gocui.Gui, args string) error {
+	if args != "" {
+		cmd, ok := gm.commands[strings.TrimPrefix(args, commandPrefix)]
+		if !ok {
+			return fmt.Errorf("unknown command %s", args)
+		}
+
+		gm.notice(g, gm.currentChatName, cmd.usage+" - "+cmd.help)
+
+		return nil
+	}
+
+	names := make([]string, 0, len(gm.commands))
+	for name := range gm.commands {
+		names = append(names, name)
+	}
+
+	slices.Sort(names)
+
+	for _, name := range names {
+		gm.notice(g, gm.currentChatName, gm.commands[name].usage+" - "+gm.commands[name].help)
+	}
+
+	return nil
+}
+
+func (gm *Manager) cmdSearch(g *gocui.Gui, args string) error {
+	chat, err := gm.currentChat()
+	if err != nil {
+		return err
+	}
+
+	query := strings.ToLower(args)
+	found := make([]entities.Message, 0)
+
+	for _, msg := range gm.messages[chat] {
+		if !msg.System && strings.Contains(strings.ToLower(msg.Text), query) {
+			found = append(found, msg)
+		}
+	}
+
+	gm.notice(g, chat, fmt.Sprintf("search %q: %d found", args, len(found)))
+
+	for _, msg := range found {
+		gm.notice(g, chat, msg.TS.Format("15:04:05")+" "+messageAuthor(msg)+": "+msg.Text)
+	}
+
+	return nil
+}
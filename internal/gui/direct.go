@@ -0,0 +1,18 @@
+package gui
+
+import (
+	"github.com/awesome-gocui/gocui"
+	"github.com/gbh007/p2p-chat/internal/entities"
+)
+
+// sendDirect - личное сообщение, переписка открывается и становится текущим чатом
+func (gm *Manager) sendDirect(g *gocui.Gui, user, text string) error {
+	chat := entities.DirectChannel(user)
+
+	err := gm.openChat(g, chat)
+	if err != nil {
+		return err
+	}
+
+	return gm.callbacker.SendMessage(chat, text)
+}
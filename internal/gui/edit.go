@@ -0,0 +1,97 @@
+package gui
+
+import (
+	"errors"
+
+	"github.com/awesome-gocui/gocui"
+	"github.com/gbh007/p2p-chat/internal/entities"
+)
+
+var errNoOwnMessage = errors.New("no sent message of yours in this chat")
+
+// HandleMessageChanged - сообщение изменено или удалено, история чата перерисовывается с новым состоянием
+func (gm *Manager) HandleMessageChanged(msg entities.Message) {
+	gm.g.Update(func(g *gocui.Gui) error {
+		v, err := g.View(chatHistoryViewName + msg.Chat)
+		// Чат мог быть закрыт до получения события
+		if errors.Is(err, gocui.ErrUnknownView) {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		err = gm.updateThread(g, msg)
+		if err != nil {
+			return err
+		}
+
+		for i, old := range gm.messages[msg.Chat] {
+			if old.ID != msg.ID || old.System {
+				continue
+			}
+
+			// Изменение могло прийти раньше подтверждения отправки
+			if old.Delivery != entities.DeliveryDelivered {
+				return nil
+			}
+
+			gm.messages[msg.Chat][i] = msg
+			setTopic(v, msg)
+
+			return gm.renderChat(v, msg.Chat)
+		}
+
+		// Сообщение еще не загружено, новое состояние придет вместе с историей
+		return nil
+	})
+}
+
+// lastOwnMessage - последнее отправленное пользователем и не удаленное сообщение чата
+func (gm *Manager) lastOwnMessage(chat string) (entities.Message, bool) {
+	messages := gm.messages[chat]
+
+	for i := len(messages) - 1; i >= 0; i-- {
+		msg := messages[i]
+
+		if msg.IsOwn && !msg.System && !msg.Deleted && msg.Delivery == entities.DeliveryDelivered {
+			return msg, true
+		}
+	}
+
+	return entities.Message{}, false
+}
+
+func (gm *Manager) cmdEdit(g *gocui.Gui, args string) error {
+	chat, err := gm.currentChat()
+	if err != nil {
+		return err
+	}
+
+	msg, err := gm.changeTarget(chat)
+	if err != nil {
+		return err
+	}
+
+	if !msg.IsOwn {
+		return errNotOwnMessage
+	}
+
+	return gm.callbacker.EditMessage(chat, msg.ID, msg.Kind, args)
+}
+
+func (gm *Manager) cmdDelete(g *gocui.Gui, args string) error {
+	chat, err := gm.currentChat()
+	if err != nil {
+		return err
+	}
+
+	// Права на удаление чужого сообщения проверяет сервер
+	msg, err := gm.changeTarget(chat)
+	if err != nil {
+		return err
+	}
+
+	return gm.callbacker.DeleteMessage(chat, msg.ID)
+}
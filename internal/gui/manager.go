@@ -3,6 +3,8 @@ package gui
 import (
 	"errors"
 	"slices"
+	"strings"
+	"time"
 
 	"github.com/awesome-gocui/gocui"
 	"github.com/gbh007/p2p-chat/internal/entities"
@@ -13,11 +15,31 @@ const (
 	chatListViewName        = "list"
 	chatHistoryViewName     = "chat/"
 	chatMessageViewName     = "message"
+	membersViewName         = "members"
+	peersViewName           = "peers"
+	presenceViewName        = "presence"
+	typingViewName          = "typing"
 )
 
 type callbacker interface {
-	SendMessage(chat, msg string)
+	SendMessage(chat, msg string) error
 	Connect(name string)
+	LoadHistory(name string) error
+	Members(chat string) []string
+	SetMembers(chat string, members []string) error
+	ConnectPeer(addr string) error
+	Leave(name string)
+	SetAway(away bool)
+	Typing(chat string)
+	EditMessage(chat, id string, kind entities.MessageKind, text string) error
+	DeleteMessage(chat, id string) error
+	AddReaction(chat, id, emoji string) error
+	RemoveReaction(chat, id, emoji string) error
+	SendReply(chat, replyTo, msg string) error
+	SendAction(chat, msg string) error
+	SetTopic(chat, topic string) error
+	LoadThread(chat, id string) error
+	Login() string
 }
 
 type Manager struct {
@@ -26,13 +48,56 @@ type Manager struct {
 	callbacker callbacker
 
 	currentChatName string
+
+	// chats - открытые чаты в порядке списка
+	chats []string
+
+	// messages - сообщения чатов, изменяются только в основном цикле gocui
+	messages map[string][]entities.Message
+	// knownIDs - идентификаторы уже показанных сообщений, для исключения дублей
+	knownIDs map[string]struct{}
+
+	// peers - соседи в p2p режиме, панель показывается только если они известны
+	peers        []entities.Peer
+	selectedPeer int
+	showPeers    bool
+
+	// presence - участники чатов и их статус, presenceChat - чат показанный в панели участников
+	presence     map[string]map[string]entities.PresenceStatus
+	presenceChat string
+	away         bool
+
+	// typing - кто набирает сообщение в чатах и до какого времени это показывать,
+	// typingSent - время отправки собственного события по чатам
+	typing     map[string]map[string]time.Time
+	typingSent map[string]time.Time
+
+	commands map[string]command
+
+	// selected - идентификатор выбранного в истории сообщения по чатам,
+	// selectedLines - строки буфера выбранного сообщения при последней отрисовке
+	selected      map[string]string
+	selectedLines [2]int
+
+	// thread - открытый тред, показывается только вместе со своим чатом
+	thread *thread
 }
 
 func New(callbacker callbacker) *Manager {
-	return &Manager{
+	gm := &Manager{
 		callbacker:      callbacker,
 		currentChatName: "chat 3",
+		messages:        make(map[string][]entities.Message),
+		knownIDs:        make(map[string]struct{}),
+		presence:        make(map[string]map[string]entities.PresenceStatus),
+		typing:          make(map[string]map[string]time.Time),
+		typingSent:      make(map[string]time.Time),
+		selected:        make(map[string]string),
 	}
+
+	gm.registerCommands()
+
+	return gm
 }
 
 func (gm *Manager) Init() error {
@@ -114,7 +179,18 @@ func (gm *Manager) Layout(g *gocui.Gui) error {
 		g.Cursor = true
 	}
 
-	if v, err := g.SetView(chatListViewName, 0, 3, chatSelectorX, maxY-1, 0); err != nil {
+	chatListY := maxY - 1
+
+	if gm.showPeers {
+		chatListY = maxY / 2
+
+		err := gm.layoutPeers(g, 0, chatListY+1, chatSelectorX, maxY-1)
+		if err != nil {
+			return err
+		}
+	}
+
+	if v, err := g.SetView(chatListViewName, 0, 3, chatSelectorX, chatListY, 0); err != nil {
 		if !errors.Is(err, gocui.ErrUnknownView) {
 			return err
 		}
@@ -138,6 +214,18 @@ func (gm *Manager) Layout(g *gocui.Gui) error {
 		if err := gm.g.SetKeybinding(chatListViewName, 'j', gocui.ModNone, gm.nextChat); err != nil {
 			return err
 		}
+
+		if err := gm.g.SetKeybinding(chatListViewName, gocui.KeyCtrlE, gocui.ModNone, gm.openMembers); err != nil {
+			return err
+		}
+
+		if err := gm.g.SetKeybinding(chatListViewName, 'x', gocui.ModNone, gm.leaveChat); err != nil {
+			return err
+		}
+
+		if err := gm.g.SetKeybinding(chatListViewName, gocui.KeyDelete, gocui.ModNone, gm.leaveChat); err != nil {
+			return err
+		}
 	}
 
 	if v, err := g.SetView(chatMessageViewName, chatSelectorX+2, maxY-3, maxX-1, maxY-1, 0); err != nil {
@@ -149,21 +237,67 @@ func (gm *Manager) Layout(g *gocui.Gui) error {
 		v.Editor = gocui.EditorFunc(gm.editMessage)
 		v.Editable = true
 		v.Visible = false
+
+		if err := gm.g.SetKeybinding(chatMessageViewName, gocui.KeyCtrlE, gocui.ModNone, gm.openMembers); err != nil {
+			return err
+		}
+
+		if err := gm.g.SetKeybinding(chatMessageViewName, gocui.KeyCtrlA, gocui.ModNone, gm.toggleAway); err != nil {
+			return err
+		}
+
+		if err := gm.g.SetKeybinding(chatMessageViewName, gocui.KeyTab, gocui.ModNone, gm.completeCommand); err != nil {
+			return err
+		}
+	}
+
+	err := gm.layoutThread(g, chatSelectorX+2, 0, maxX-presencePanelWidth-2, maxY-5)
+	if err != nil {
+		return err
+	}
+
+	err = gm.layoutPresence(g, maxX-presencePanelWidth-1, 0, maxX-1, maxY-5)
+	if err != nil {
+		return err
+	}
+
+	err = gm.layoutTyping(g, chatSelectorX+2, maxY-5, maxX-presencePanelWidth-2, maxY-3)
+	if err != nil {
+		return err
 	}
 
 	return nil
 }
 
 func (gm *Manager) nextView(g *gocui.Gui, v *gocui.View) error {
-	if gm.currentChatName == "" {
-		return nil
-	}
+	var viewNames map[string]string
+
+	switch {
+	case gm.currentChatName != "":
+		viewNames = map[string]string{
+			chatConnectNameViewName:                  chatListViewName,
+			chatListViewName:                         chatHistoryViewName + gm.currentChatName,
+			chatHistoryViewName + gm.currentChatName: chatMessageViewName,
+			chatMessageViewName:                      chatConnectNameViewName,
+		}
 
-	viewNames := map[string]string{
-		chatConnectNameViewName:                  chatListViewName,
-		chatListViewName:                         chatHistoryViewName + gm.currentChatName,
-		chatHistoryViewName + gm.currentChatName: chatMessageViewName,
-		chatMessageViewName:                      chatConnectNameViewName,
+		if gm.threadOpen() {
+			viewNames[chatHistoryViewName+gm.currentChatName] = threadViewName
+			viewNames[threadViewName] = chatMessageViewName
+		}
+
+		if gm.showPeers {
+			viewNames[chatListViewName] = peersViewName
+			viewNames[peersViewName] = chatHistoryViewName + gm.currentChatName
+		}
+	case gm.showPeers:
+		// До входа в чат доступны только подключение к чату и соседи
+		viewNames = map[string]string{
+			chatConnectNameViewName: peersViewName,
+			peersViewName:           chatConnectNameViewName,
+		}
+	default:
+		return nil
 	}
 
 	next, ok := viewNames[v.Name()]
@@ -188,12 +322,35 @@ func (gm *Manager) nextView(g *gocui.Gui, v *gocui.View) error {
 
 func (gm *Manager) HandleMessage(msg entities.Message) {
 	gm.g.Update(func(g *gocui.Gui) error {
-		v, err := g.View(chatHistoryViewName + msg.Chat)
+		v, err := gm.chatView(g, msg.Chat)
+		// Чат мог быть закрыт до получения события
+		if errors.Is(err, gocui.ErrUnknownView) {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		err = gm.updateThread(g, msg)
 		if err != nil {
 			return err
 		}
 
-		err = writeMessage(v, msg)
+		if !gm.remember(msg) {
+			// Подтвержденное сервером сообщение заменяет ожидающее отправки
+			if msg.Delivery == entities.DeliveryDelivered {
+				return gm.replaceMessage(v, msg)
+			}
+
+			return nil
+		}
+
+		gm.messages[msg.Chat] = append(gm.messages[msg.Chat], msg)
+		gm.stopTyping(msg.Chat, msg.User)
+		setTopic(v, msg)
+
+		err = gm.writeMessage(v, msg)
 		if err != nil {
 			return err
 		}
@@ -202,15 +359,226 @@ func (gm *Manager) HandleMessage(msg entities.Message) {
 	})
 }
 
+func (gm *Manager) HandleDeliveryState(chat, id string, state entities.DeliveryState) {
+	gm.g.Update(func(g *gocui.Gui) error {
+		v, err := g.View(chatHistoryViewName + chat)
+		if errors.Is(err, gocui.ErrUnknownView) {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		err = gm.setThreadDelivery(g, chat, id, state)
+		if err != nil {
+			return err
+		}
+
+		for i, msg := range gm.messages[chat] {
+			if msg.ID == id && msg.Delivery != entities.DeliveryDelivered {
+				gm.messages[chat][i].Delivery = state
+
+				return gm.renderChat(v, chat)
+			}
+		}
+
+		return nil
+	})
+}
+
+// replaceMessage - обновляет ранее показанное неподтвержденное сообщение
+func (gm *Manager) replaceMessage(v *gocui.View, msg entities.Message) error {
+	for i, old := range gm.messages[msg.Chat] {
+		if old.ID != msg.ID {
+			continue
+		}
+
+		if old.Delivery == entities.DeliveryDelivered {
+			return nil
+		}
+
+		gm.messages[msg.Chat][i] = msg
+
+		return gm.renderChat(v, msg.Chat)
+	}
+
+	return nil
+}
+
+// HandleHistory - добавляет более старые сообщения в начало чата
+func (gm *Manager) HandleHistory(chat string, messages []entities.Message) {
+	if len(messages) == 0 {
+		return
+	}
+
+	gm.g.Update(func(g *gocui.Gui) error {
+		v, err := gm.chatView(g, chat)
+		// Чат мог быть закрыт до получения события
+		if errors.Is(err, gocui.ErrUnknownView) {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		older := make([]entities.Message, 0, len(messages))
+
+		for _, msg := range messages {
+			if gm.remember(msg) {
+				older = append(older, msg)
+			}
+		}
+
+		gm.messages[chat] = append(older, gm.messages[chat]...)
+
+		// Более старая тема не заменяет уже известную
+		if v.Subtitle == "" {
+			for _, msg := range older {
+				setTopic(v, msg)
+			}
+		}
+
+		linesBefore := v.ViewLinesHeight()
+
+		err = gm.renderChat(v, chat)
+		if err != nil {
+			return err
+		}
+
+		// Сохраняем позицию пользователя при подгрузке старых сообщений
+		if !v.Autoscroll {
+			err = v.SetOrigin(0, v.ViewLinesHeight()-linesBefore)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// HandleConnectionState - состояние подключения чата отображается в его заголовке
+func (gm *Manager) HandleConnectionState(chat string, state entities.ConnectionState) {
+	gm.g.Update(func(g *gocui.Gui) error {
+		v, err := g.View(chatHistoryViewName + chat)
+		// Чат мог быть закрыт до получения события
+		if errors.Is(err, gocui.ErrUnknownView) {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		v.Title = chatTitle(chat) + " [" + state.String() + "]"
+
+		return nil
+	})
+}
+
+// remember - запоминает сообщение, false если оно уже было показано
+func (gm *Manager) remember(msg entities.Message) bool {
+	if msg.ID == "" {
+		return true
+	}
+
+	if _, ok := gm.knownIDs[msg.ID]; ok {
+		return false
+	}
+
+	gm.knownIDs[msg.ID] = struct{}{}
+
+	return true
+}
+
+func (gm *Manager) scrollUp(g *gocui.Gui, v *gocui.View) error {
+	v.Autoscroll = false
+
+	ox, oy := v.Origin()
+	if oy > 0 {
+		return v.SetOrigin(ox, oy-1)
+	}
+
+	gm.loadHistory(g, gm.currentChatName)
+
+	return nil
+}
+
+// loadHistory - подгрузка более старых сообщений, ошибка показывается в чате
+func (gm *Manager) loadHistory(g *gocui.Gui, chat string) {
+	err := gm.callbacker.LoadHistory(chat)
+	if err != nil {
+		gm.notice(g, chat, "error: load history: "+err.Error())
+	}
+}
+
+func (gm *Manager) scrollDown(g *gocui.Gui, v *gocui.View) error {
+	_, maxY := v.Size()
+	ox, oy := v.Origin()
+
+	if oy+maxY >= v.ViewLinesHeight()-1 {
+		v.Autoscroll = true
+
+		return nil
+	}
+
+	return v.SetOrigin(ox, oy+1)
+}
+
 func (gm *Manager) editMessage(v *gocui.View, key gocui.Key, ch rune, mod gocui.Modifier) {
 	if key == gocui.KeyEnter {
 		msg := v.Buffer()
-		gm.callbacker.SendMessage(gm.currentChatName, msg)
+
+		if name, args, ok := parseCommand(msg); ok {
+			// Ошибка команды показывается в чате, текст остается в редакторе для исправления
+			err := gm.runCommand(gm.g, name, args)
+			if err != nil {
+				gm.notice(gm.g, gm.currentChatName, "error: "+err.Error())
+
+				return
+			}
+
+			v.Clear()
+			_ = v.SetCursor(0, 0)
+
+			return
+		}
+
+		// Экранированный текст отправляется без первого "/"
+		if strings.HasPrefix(msg, commandPrefix+commandPrefix) {
+			msg = msg[len(commandPrefix):]
+		}
+
+		var err error
+
+		// При ошибке текст остается в редакторе для повторной отправки
+		if gm.threadOpen() {
+			err = gm.callbacker.SendReply(gm.currentChatName, gm.thread.root.ID, msg)
+		} else {
+			err = gm.callbacker.SendMessage(gm.currentChatName, msg)
+		}
+
+		if err != nil {
+			v.Title = gm.messageTitle() + " (send failed: " + err.Error() + ")"
+
+			return
+		}
+
+		v.Title = gm.messageTitle()
 		v.Clear()
 
+		// Сообщение отправлено, следующий набор снова виден сразу
+		delete(gm.typingSent, gm.currentChatName)
+
 		return
 	}
 
+	if ch != 0 {
+		gm.notifyTyping(gm.currentChatName)
+	}
+
 	gocui.DefaultEditor.Edit(v, key, ch, mod)
 }
 
@@ -229,24 +597,84 @@ func (gm *Manager) editChatName(v *gocui.View, key gocui.Key, ch rune, mod gocui
 	gocui.DefaultEditor.Edit(v, key, ch, mod)
 }
 
-func writeMessage(v *gocui.View, msg entities.Message) error {
-	v.WriteString(msg.TS.Format("15:04:05"))
-	v.WriteString(" (")
+// renderChat - перерисовка истории чата, при просмотре истории позиция сохраняется
+func (gm *Manager) renderChat(v *gocui.View, chat string) error {
+	ox, oy := v.Origin()
 
-	if !msg.IsOwn {
-		v.WriteString(msg.User)
+	v.Clear()
+
+	for _, msg := range gm.messages[chat] {
+		err := gm.writeMessage(v, msg)
+		if err != nil {
+			return err
+		}
 	}
 
-	if !msg.IsLocalDomain || msg.IsOwn {
-		v.WriteString("@")
+	if v.Autoscroll {
+		return nil
 	}
 
-	if !msg.IsLocalDomain {
-		v.WriteString(msg.Domain)
+	return v.SetOrigin(ox, oy)
+}
+
+func (gm *Manager) writeMessage(v *gocui.View, msg entities.Message) error {
+	// В треде выбор не показывается, а исходное сообщение стоит первым
+	inThread := v.Name() == threadViewName
+
+	selected := !inThread && msg.ID != "" && gm.selected[msg.Chat] == msg.ID
+	if selected {
+		_, gm.selectedLines[0] = v.WritePos()
+	}
+
+	if msg.ReplyTo != "" && !msg.System && !inThread {
+		gm.writeReplyPreview(v, msg)
+	}
+
+	if selected {
+		v.WriteString("> ")
+	}
+
+	if msg.System {
+		if msg.User != "" {
+			v.WriteString(msg.TS.Format("15:04:05") + " * " + msg.User + " " + msg.Text + "\n")
+		} else {
+			v.WriteString(msg.TS.Format("15:04:05") + " * " + msg.Text + "\n")
+		}
+
+		return nil
+	}
+
+	switch msg.SignatureStatus {
+	case entities.SignatureInvalid:
+		v.WriteString("[!] ")
+	case entities.SignatureUnknownKey:
+		v.WriteString("[?] ")
+	}
+
+	switch msg.Delivery {
+	case entities.DeliveryPending:
+		v.WriteString("[pending] ")
+	case entities.DeliveryFailed:
+		v.WriteString("[failed] ")
+	}
+
+	v.WriteString(msg.TS.Format("15:04:05"))
+
+	switch {
+	case msg.Deleted:
+		v.WriteString(" " + messageAuthor(msg) + ": [message deleted]")
+	case msg.Kind == entities.MessageAction:
+		v.WriteString(" * " + messageAuthor(msg) + " " + msg.Text)
+	case msg.Kind == entities.MessageTopic:
+		v.WriteString(" * " + messageAuthor(msg) + " set topic: " + msg.Text)
+	default:
+		v.WriteString(" " + messageAuthor(msg) + ": " + msg.Text)
+	}
+
+	if !msg.Edited.IsZero() && !msg.Deleted {
+		v.WriteString(" (edited)")
 	}
 
-	v.WriteString("): ")
-	v.WriteString(msg.Text)
 	v.WriteString("\n")
 
 	err := v.SetHighlight(v.LinesHeight()-2, msg.IsOwn)
@@ -254,142 +682,315 @@ func writeMessage(v *gocui.View, msg entities.Message) error {
 		return err
 	}
 
+	if len(msg.Reactions) > 0 {
+		v.WriteString("    " + reactionSummary(msg.Reactions, gm.callbacker.Login()) + "\n")
+	}
+
+	if selected {
+		_, gm.selectedLines[1] = v.WritePos()
+	}
+
 	return nil
 }
 
+// messageAuthor - автор в виде (user@domain), собственные сообщения показываются как (@)
+func messageAuthor(msg entities.Message) string {
+	var author strings.Builder
+
+	author.WriteString("(")
+
+	if !msg.IsOwn {
+		author.WriteString(msg.User)
+	}
+
+	if !msg.IsLocalDomain || msg.IsOwn {
+		author.WriteString("@")
+	}
+
+	if !msg.IsLocalDomain {
+		author.WriteString(msg.Domain)
+	}
+
+	author.WriteString(")")
+
+	return author.String()
+}
+
+// setTopic - тема чата показывается в заголовке его истории
+func setTopic(v *gocui.View, msg entities.Message) {
+	if msg.Kind == entities.MessageTopic && !msg.System && !msg.Deleted {
+		v.Subtitle = msg.Text
+	}
+}
+
 func (gm *Manager) nextChat(g *gocui.Gui, v *gocui.View) error {
-	chats := v.BufferLines()
+	if len(gm.chats) == 0 {
+		return nil
+	}
+
+	index := slices.Index(gm.chats, gm.currentChatName)
+
+	return gm.selectChat(g, gm.chats[(index+1)%len(gm.chats)])
+}
 
-	if len(chats) == 0 {
+func (gm *Manager) prevChat(g *gocui.Gui, v *gocui.View) error {
+	if len(gm.chats) == 0 {
 		return nil
 	}
 
-	index := slices.Index(chats, gm.currentChatName)
-	nextIndex := (index + 1) % len(chats)
+	index := slices.Index(gm.chats, gm.currentChatName)
 
-	if index > -1 {
-		err := v.SetHighlight(index, false)
-		if err != nil {
-			return err
-		}
+	return gm.selectChat(g, gm.chats[(len(gm.chats)+index-1)%len(gm.chats)])
+}
 
-		cv, err := g.View(chatHistoryViewName + gm.currentChatName)
+// selectChat - показ истории выбранного чата вместо текущего
+func (gm *Manager) selectChat(g *gocui.Gui, name string) error {
+	if cv, err := g.View(chatHistoryViewName + gm.currentChatName); err == nil {
+		cv.Visible = false
+	}
+
+	gm.currentChatName = name
+
+	// Тред показывается только вместе со своим чатом
+	if gm.thread != nil && gm.thread.chat != name {
+		err := gm.closeThread(g, nil)
 		if err != nil {
 			return err
 		}
-
-		cv.Visible = false
 	}
 
-	err := v.SetHighlight(nextIndex, true)
+	cv, err := g.View(chatHistoryViewName + name)
 	if err != nil {
 		return err
 	}
 
-	gm.currentChatName = chats[nextIndex]
+	cv.Visible = true
 
-	cv, err := g.View(chatHistoryViewName + gm.currentChatName)
+	mView, err := g.View(chatMessageViewName)
 	if err != nil {
 		return err
 	}
 
-	cv.Visible = true
+	mView.Visible = true
 
-	return nil
+	return gm.renderChatList(g)
 }
 
-func (gm *Manager) prevChat(g *gocui.Gui, v *gocui.View) error {
-	chats := v.BufferLines()
+// renderChatList - список чатов с выделением текущего
+func (gm *Manager) renderChatList(g *gocui.Gui) error {
+	v, err := g.View(chatListViewName)
+	if err != nil {
+		return err
+	}
+
+	labels := make([]string, 0, len(gm.chats))
+	for _, name := range gm.chats {
+		labels = append(labels, chatLabel(name))
+	}
 
-	if len(chats) == 0 {
+	v.Clear()
+	v.WriteString(strings.Join(labels, "\n"))
+
+	index := slices.Index(gm.chats, gm.currentChatName)
+	if index < 0 {
 		return nil
 	}
 
-	index := slices.Index(chats, gm.currentChatName)
-	nextIndex := (len(chats) + index - 1) % len(chats)
+	return v.SetHighlight(index, true)
+}
 
-	if index > -1 {
-		err := v.SetHighlight(index, false)
-		if err != nil {
-			return err
-		}
+// leaveChat - выход из текущего чата
+func (gm *Manager) leaveChat(g *gocui.Gui, v *gocui.View) error {
+	if !slices.Contains(gm.chats, gm.currentChatName) {
+		return nil
+	}
 
-		cv, err := g.View(chatHistoryViewName + gm.currentChatName)
-		if err != nil {
-			return err
-		}
+	return gm.leave(g, gm.currentChatName)
+}
 
-		cv.Visible = false
+// leave - выход из чата, если он был текущим выбирается первый из оставшихся
+func (gm *Manager) leave(g *gocui.Gui, name string) error {
+	gm.callbacker.Leave(name)
+
+	g.DeleteKeybindings(chatHistoryViewName + name)
+
+	err := g.DeleteView(chatHistoryViewName + name)
+	if err != nil && !errors.Is(err, gocui.ErrUnknownView) {
+		return err
 	}
 
-	if nextIndex > -1 {
-		err := v.SetHighlight(nextIndex, true)
-		if err != nil {
-			return err
-		}
+	for _, msg := range gm.messages[name] {
+		delete(gm.knownIDs, msg.ID)
+	}
 
-		gm.currentChatName = chats[nextIndex]
+	delete(gm.messages, name)
+	delete(gm.presence, name)
+	delete(gm.typing, name)
+	delete(gm.typingSent, name)
+	delete(gm.selected, name)
 
-		cv, err := g.View(chatHistoryViewName + gm.currentChatName)
+	if gm.thread != nil && gm.thread.chat == name {
+		err = gm.closeThread(g, nil)
 		if err != nil {
 			return err
 		}
+	}
 
-		cv.Visible = true
+	gm.chats = slices.DeleteFunc(gm.chats, func(chat string) bool {
+		return chat == name
+	})
+
+	if gm.currentChatName != name {
+		return gm.renderChatList(g)
 	}
 
-	return nil
+	gm.currentChatName = ""
+
+	if len(gm.chats) > 0 {
+		return gm.selectChat(g, gm.chats[0])
+	}
+
+	mView, err := g.View(chatMessageViewName)
+	if err != nil {
+		return err
+	}
+
+	mView.Visible = false
+
+	return gm.renderChatList(g)
 }
 
 func (gm *Manager) NewChat(name string) {
 	gm.g.Update(func(g *gocui.Gui) error {
-		maxX, maxY := g.Size()
+		return gm.newChat(g, name)
+	})
+}
 
-		chatSelectorX := maxX/3 - 2
+// newChat - создание истории чата, первый чат сразу становится текущим
+func (gm *Manager) newChat(g *gocui.Gui, name string) error {
+	maxX, maxY := g.Size()
 
-		if chatSelectorX > 20 {
-			chatSelectorX = 20
-		}
+	chatSelectorX := maxX/3 - 2
 
-		if v, err := g.SetView(chatHistoryViewName+name, chatSelectorX+2, 0, maxX-1, maxY-4, 0); err != nil {
-			if !errors.Is(err, gocui.ErrUnknownView) {
-				return err
-			}
+	if chatSelectorX > 20 {
+		chatSelectorX = 20
+	}
 
-			v.Title = "Chat " + name
-			v.Autoscroll = true
-			v.Wrap = true
-			v.Visible = false
+	v, err := g.SetView(chatHistoryViewName+name, chatSelectorX+2, 0, maxX-presencePanelWidth-2, maxY-5, 0)
+	if err == nil {
+		return nil
+	}
 
-			lView, err := g.View(chatListViewName)
-			if err != nil {
-				return err
-			}
+	if !errors.Is(err, gocui.ErrUnknownView) {
+		return err
+	}
 
-			if lView.LinesHeight() > 0 {
-				lView.WriteString("\n")
-			}
+	v.Title = chatTitle(name)
+	v.Autoscroll = true
+	v.Wrap = true
+	v.Visible = false
 
-			lView.WriteString(name)
+	if err := g.SetKeybinding(v.Name(), gocui.KeyArrowUp, gocui.ModNone, gm.scrollUp); err != nil {
+		return err
+	}
 
-			if lView.LinesHeight() == 1 {
-				err = lView.SetHighlight(0, true)
-				if err != nil {
-					return err
-				}
+	if err := g.SetKeybinding(v.Name(), gocui.KeyArrowDown, gocui.ModNone, gm.scrollDown); err != nil {
+		return err
+	}
 
-				v.Visible = true
-				gm.currentChatName = name
+	if err := g.SetKeybinding(v.Name(), 'k', gocui.ModNone, gm.selectPrev); err != nil {
+		return err
+	}
 
-				mView, err := g.View(chatMessageViewName)
-				if err != nil {
-					return err
-				}
+	if err := g.SetKeybinding(v.Name(), 'j', gocui.ModNone, gm.selectNext); err != nil {
+		return err
+	}
 
-				mView.Visible = true
-			}
-		}
+	if err := g.SetKeybinding(v.Name(), gocui.KeyEsc, gocui.ModNone, gm.clearSelection); err != nil {
+		return err
+	}
 
-		return nil
-	})
+	if err := g.SetKeybinding(v.Name(), 't', gocui.ModNone, gm.openThread); err != nil {
+		return err
+	}
+
+	if err := g.SetKeybinding(v.Name(), gocui.KeyPgup, gocui.ModNone, gm.selectPageUp); err != nil {
+		return err
+	}
+
+	if err := g.SetKeybinding(v.Name(), gocui.KeyPgdn, gocui.ModNone, gm.selectPageDown); err != nil {
+		return err
+	}
+
+	if err := g.SetKeybinding(v.Name(), 'g', gocui.ModNone, gm.selectFirst); err != nil {
+		return err
+	}
+
+	if err := g.SetKeybinding(v.Name(), 'G', gocui.ModNone, gm.selectLast); err != nil {
+		return err
+	}
+
+	if err := g.SetKeybinding(v.Name(), 'r', gocui.ModNone, gm.replySelected); err != nil {
+		return err
+	}
+
+	if err := g.SetKeybinding(v.Name(), '+', gocui.ModNone, gm.reactSelected); err != nil {
+		return err
+	}
+
+	if err := g.SetKeybinding(v.Name(), 'y', gocui.ModNone, gm.copySelected); err != nil {
+		return err
+	}
+
+	if err := g.SetKeybinding(v.Name(), 'e', gocui.ModNone, gm.editSelected); err != nil {
+		return err
+	}
+
+	if err := g.SetKeybinding(v.Name(), 'd', gocui.ModNone, gm.deleteSelected); err != nil {
+		return err
+	}
+
+	gm.chats = append(gm.chats, name)
+
+	if len(gm.chats) == 1 {
+		return gm.selectChat(g, name)
+	}
+
+	return gm.renderChatList(g)
+}
+
+// chatView - история чата, личная переписка открывается при получении сообщения
+func (gm *Manager) chatView(g *gocui.Gui, chat string) (*gocui.View, error) {
+	v, err := g.View(chatHistoryViewName + chat)
+	if !errors.Is(err, gocui.ErrUnknownView) {
+		return v, err
+	}
+
+	if _, ok := entities.DirectPeer(chat); !ok {
+		return nil, err
+	}
+
+	err = gm.newChat(g, chat)
+	if err != nil {
+		return nil, err
+	}
+
+	return g.View(chatHistoryViewName + chat)
+}
+
+// chatLabel - имя чата в списке, личная переписка отмечается отдельно
+func chatLabel(name string) string {
+	if peer, ok := entities.DirectPeer(name); ok {
+		return "✉ " + peer
+	}
+
+	return name
+}
+
+func chatTitle(name string) string {
+	if peer, ok := entities.DirectPeer(name); ok {
+		return "Direct " + peer
+	}
+
+	return "Chat " + name
 }
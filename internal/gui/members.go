@@ -0,0 +1,81 @@
+package gui
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/awesome-gocui/gocui"
+)
+
+// openMembers - окно участников зашифрованного канала, сохранение включает
+// шифрование или меняет состав участников с выпуском нового ключа
+func (gm *Manager) openMembers(g *gocui.Gui, v *gocui.View) error {
+	if gm.currentChatName == "" {
+		return nil
+	}
+
+	maxX, maxY := g.Size()
+
+	mv, err := g.SetView(membersViewName, maxX/4, maxY/4, maxX*3/4, maxY*3/4, 0)
+	if err != nil {
+		if !errors.Is(err, gocui.ErrUnknownView) {
+			return err
+		}
+
+		mv.Editable = true
+		mv.Wrap = true
+		mv.Editor = gocui.EditorFunc(gm.editMembers)
+
+		if err := g.SetKeybinding(membersViewName, gocui.KeyEsc, gocui.ModNone, gm.closeMembers); err != nil {
+			return err
+		}
+	}
+
+	mv.Title = "Members of " + gm.currentChatName + " (Enter - save, Esc - cancel)"
+	mv.Clear()
+	mv.WriteString(strings.Join(gm.callbacker.Members(gm.currentChatName), " "))
+
+	_, err = g.SetCurrentView(membersViewName)
+	if err != nil {
+		return err
+	}
+
+	g.Cursor = true
+
+	return mv.SetCursor(len(mv.Buffer()), 0)
+}
+
+func (gm *Manager) closeMembers(g *gocui.Gui, v *gocui.View) error {
+	g.DeleteKeybindings(membersViewName)
+
+	err := g.DeleteView(membersViewName)
+	if err != nil {
+		return err
+	}
+
+	_, err = g.SetCurrentView(chatMessageViewName)
+	if err != nil {
+		return err
+	}
+
+	g.Cursor = true
+
+	return nil
+}
+
+func (gm *Manager) editMembers(v *gocui.View, key gocui.Key, ch rune, mod gocui.Modifier) {
+	if key == gocui.KeyEnter {
+		err := gm.callbacker.SetMembers(gm.currentChatName, strings.Fields(v.Buffer()))
+		if err != nil {
+			v.Title = "Error: " + err.Error()
+
+			return
+		}
+
+		_ = gm.closeMembers(gm.g, v)
+
+		return
+	}
+
+	gocui.DefaultEditor.Edit(v, key, ch, mod)
+}
@@ -0,0 +1,141 @@
+package gui
+
+import (
+	"errors"
+
+	"github.com/awesome-gocui/gocui"
+	"github.com/gbh007/p2p-chat/internal/entities"
+)
+
+const peerShortIDLen = 8
+
+// HandlePeers - обновление панели соседей, панель появляется при первом вызове
+func (gm *Manager) HandlePeers(peers []entities.Peer) {
+	gm.g.Update(func(g *gocui.Gui) error {
+		gm.peers = peers
+		gm.showPeers = true
+
+		if gm.selectedPeer >= len(peers) {
+			gm.selectedPeer = max(len(peers)-1, 0)
+		}
+
+		v, err := g.View(peersViewName)
+		if errors.Is(err, gocui.ErrUnknownView) {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		return gm.renderPeers(v)
+	})
+}
+
+func (gm *Manager) layoutPeers(g *gocui.Gui, x0, y0, x1, y1 int) error {
+	v, err := g.SetView(peersViewName, x0, y0, x1, y1, 0)
+	if err == nil {
+		return nil
+	}
+
+	if !errors.Is(err, gocui.ErrUnknownView) {
+		return err
+	}
+
+	v.Title = "Peers"
+	v.SelBgColor = gocui.ColorGreen
+	v.SelFgColor = gocui.ColorBlack
+
+	for _, key := range []any{gocui.KeyArrowUp, 'k'} {
+		if err := g.SetKeybinding(peersViewName, key, gocui.ModNone, gm.prevPeer); err != nil {
+			return err
+		}
+	}
+
+	for _, key := range []any{gocui.KeyArrowDown, 'j'} {
+		if err := g.SetKeybinding(peersViewName, key, gocui.ModNone, gm.nextPeer); err != nil {
+			return err
+		}
+	}
+
+	if err := g.SetKeybinding(peersViewName, gocui.KeyEnter, gocui.ModNone, gm.connectPeer); err != nil {
+		return err
+	}
+
+	return gm.renderPeers(v)
+}
+
+func (gm *Manager) renderPeers(v *gocui.View) error {
+	v.Clear()
+
+	for _, peer := range gm.peers {
+		if peer.Connected {
+			v.WriteString("● ")
+		} else {
+			v.WriteString("○ ")
+		}
+
+		id := peer.ID
+		if len(id) > peerShortIDLen {
+			id = id[:peerShortIDLen]
+		}
+
+		v.WriteString(id + " " + peer.Addr + "\n")
+	}
+
+	if len(gm.peers) == 0 {
+		return nil
+	}
+
+	return v.SetHighlight(gm.selectedPeer, true)
+}
+
+func (gm *Manager) nextPeer(g *gocui.Gui, v *gocui.View) error {
+	if len(gm.peers) == 0 {
+		return nil
+	}
+
+	gm.selectedPeer = (gm.selectedPeer + 1) % len(gm.peers)
+
+	return gm.renderPeers(v)
+}
+
+func (gm *Manager) prevPeer(g *gocui.Gui, v *gocui.View) error {
+	if len(gm.peers) == 0 {
+		return nil
+	}
+
+	gm.selectedPeer = (len(gm.peers) + gm.selectedPeer - 1) % len(gm.peers)
+
+	return gm.renderPeers(v)
+}
+
+// connectPeer - подключение к выбранному соседу, выполняется в фоне чтобы не блокировать интерфейс
+func (gm *Manager) connectPeer(g *gocui.Gui, v *gocui.View) error {
+	if gm.selectedPeer >= len(gm.peers) {
+		return nil
+	}
+
+	peer := gm.peers[gm.selectedPeer]
+	if peer.Connected {
+		return nil
+	}
+
+	v.Title = "Peers (connecting " + peer.Addr + ")"
+
+	go func() {
+		err := gm.callbacker.ConnectPeer(peer.Addr)
+
+		gm.g.Update(func(g *gocui.Gui) error {
+			v.Title = "Peers"
+
+			if err != nil {
+				v.Title = "Peers (error: " + err.Error() + ")"
+			}
+
+			return nil
+		})
+	}()
+
+	return nil
+}
@@ -0,0 +1,162 @@
+package gui
+
+import (
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/awesome-gocui/gocui"
+	"github.com/gbh007/p2p-chat/internal/entities"
+)
+
+const presencePanelWidth = 20
+
+// HandleMembers - полный список участников чата, например после подписки
+func (gm *Manager) HandleMembers(chat string, members []entities.Member) {
+	gm.g.Update(func(g *gocui.Gui) error {
+		users := make(map[string]entities.PresenceStatus, len(members))
+
+		for _, member := range members {
+			users[member.User] = member.Presence
+		}
+
+		gm.presence[chat] = users
+
+		return gm.renderPresence(g, chat)
+	})
+}
+
+// HandlePresence - изменение присутствия участника чата, выход показывается в истории
+func (gm *Manager) HandlePresence(chat, user string, presence entities.PresenceStatus) {
+	gm.g.Update(func(g *gocui.Gui) error {
+		v, err := g.View(chatHistoryViewName + chat)
+		if errors.Is(err, gocui.ErrUnknownView) {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		users, ok := gm.presence[chat]
+		if !ok {
+			users = make(map[string]entities.PresenceStatus)
+			gm.presence[chat] = users
+		}
+
+		switch presence {
+		case entities.PresenceLeft, entities.PresenceOffline:
+			delete(users, user)
+			gm.stopTyping(chat, user)
+		case entities.PresenceJoined:
+			users[user] = entities.PresenceOnline
+		default:
+			users[user] = presence
+		}
+
+		err = gm.renderPresence(g, chat)
+		if err != nil {
+			return err
+		}
+
+		if presence != entities.PresenceLeft {
+			return nil
+		}
+
+		msg := entities.Message{
+			Chat:   chat,
+			User:   user,
+			Text:   "left the chat",
+			TS:     time.Now(),
+			System: true,
+		}
+
+		gm.messages[chat] = append(gm.messages[chat], msg)
+
+		return gm.writeMessage(v, msg)
+	})
+}
+
+// layoutPresence - панель участников текущего чата справа от истории
+func (gm *Manager) layoutPresence(g *gocui.Gui, x0, y0, x1, y1 int) error {
+	v, err := g.SetView(presenceViewName, x0, y0, x1, y1, 0)
+	if err != nil {
+		if !errors.Is(err, gocui.ErrUnknownView) {
+			return err
+		}
+
+		v.Title = "Online"
+	}
+
+	_, err = g.View(chatHistoryViewName + gm.currentChatName)
+	v.Visible = err == nil
+
+	if gm.presenceChat == gm.currentChatName {
+		return nil
+	}
+
+	return gm.renderPresence(g, gm.currentChatName)
+}
+
+// renderPresence - перерисовка панели, если чат выбран
+func (gm *Manager) renderPresence(g *gocui.Gui, chat string) error {
+	if chat != gm.currentChatName {
+		return nil
+	}
+
+	v, err := g.View(presenceViewName)
+	if errors.Is(err, gocui.ErrUnknownView) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	gm.presenceChat = chat
+
+	users := make([]string, 0, len(gm.presence[chat]))
+	for user := range gm.presence[chat] {
+		users = append(users, user)
+	}
+
+	slices.Sort(users)
+
+	v.Clear()
+
+	for _, user := range users {
+		if gm.presence[chat][user] == entities.PresenceAway {
+			v.WriteString("◐ " + user + "\n")
+		} else {
+			v.WriteString("● " + user + "\n")
+		}
+	}
+
+	return nil
+}
+
+// toggleAway - смена собственного статуса во всех чатах
+func (gm *Manager) toggleAway(g *gocui.Gui, v *gocui.View) error {
+	gm.away = !gm.away
+
+	gm.callbacker.SetAway(gm.away)
+
+	v.Title = gm.messageTitle()
+
+	return nil
+}
+
+func (gm *Manager) messageTitle() string {
+	title := "Message"
+
+	// Сообщения из редактора уходят ответом в открытый тред
+	if gm.threadOpen() {
+		title += " (thread)"
+	}
+
+	if gm.away {
+		title += " (away)"
+	}
+
+	return title
+}
@@ -0,0 +1,60 @@
+package gui
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/awesome-gocui/gocui"
+	"github.com/gbh007/p2p-chat/internal/entities"
+)
+
+// reactionSummary - реакции с количеством, реакции пользователя выделены скобками
+func reactionSummary(reactions []entities.Reaction, login string) string {
+	parts := make([]string, 0, len(reactions))
+
+	for _, reaction := range reactions {
+		part := reaction.Emoji + " " + strconv.Itoa(len(reaction.Users))
+
+		if slices.Contains(reaction.Users, login) {
+			part = "[" + part + "]"
+		}
+
+		parts = append(parts, part)
+	}
+
+	return strings.Join(parts, "  ")
+}
+
+// reactionTarget - сообщение для реакции: выбранное в истории или последнее в чате
+func (gm *Manager) reactionTarget() (string, entities.Message, error) {
+	chat, err := gm.currentChat()
+	if err != nil {
+		return "", entities.Message{}, err
+	}
+
+	msg, ok := gm.selectedMessage(chat)
+	if !ok {
+		return "", entities.Message{}, errNoMessage
+	}
+
+	return chat, msg, nil
+}
+
+func (gm *Manager) cmdReact(g *gocui.Gui, args string) error {
+	chat, msg, err := gm.reactionTarget()
+	if err != nil {
+		return err
+	}
+
+	return gm.callbacker.AddReaction(chat, msg.ID, args)
+}
+
+func (gm *Manager) cmdUnreact(g *gocui.Gui, args string) error {
+	chat, msg, err := gm.reactionTarget()
+	if err != nil {
+		return err
+	}
+
+	return gm.callbacker.RemoveReaction(chat, msg.ID, args)
+}
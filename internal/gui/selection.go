@@ -0,0 +1,197 @@
+package gui
+
+import (
+	"slices"
+
+	"github.com/awesome-gocui/gocui"
+	"github.com/gbh007/p2p-chat/internal/entities"
+	"github.com/mattn/go-runewidth"
+)
+
+// selectPrev, selectNext - выбор соседнего сообщения в истории текущего чата,
+// без выбранного сообщения вверх выбирается последнее
+func (gm *Manager) selectPrev(g *gocui.Gui, v *gocui.View) error {
+	return gm.moveSelection(g, v, -1, 1, oneRow)
+}
+
+func (gm *Manager) selectNext(g *gocui.Gui, v *gocui.View) error {
+	return gm.moveSelection(g, v, 1, 1, oneRow)
+}
+
+// selectPageUp, selectPageDown - выбор сообщения на экран выше или ниже текущего
+func (gm *Manager) selectPageUp(g *gocui.Gui, v *gocui.View) error {
+	_, height := v.Size()
+
+	return gm.moveSelection(g, v, -1, max(height-1, 1), messageRows)
+}
+
+func (gm *Manager) selectPageDown(g *gocui.Gui, v *gocui.View) error {
+	_, height := v.Size()
+
+	return gm.moveSelection(g, v, 1, max(height-1, 1), messageRows)
+}
+
+// selectFirst, selectLast - выбор первого или последнего загруженного сообщения
+func (gm *Manager) selectFirst(g *gocui.Gui, v *gocui.View) error {
+	return gm.selectEdge(v, slices.IndexFunc(gm.messages[gm.currentChatName], selectable))
+}
+
+func (gm *Manager) selectLast(g *gocui.Gui, v *gocui.View) error {
+	messages := gm.messages[gm.currentChatName]
+
+	for i := len(messages) - 1; i >= 0; i-- {
+		if selectable(messages[i]) {
+			return gm.selectEdge(v, i)
+		}
+	}
+
+	return nil
+}
+
+func (gm *Manager) selectEdge(v *gocui.View, index int) error {
+	if index < 0 {
+		return nil
+	}
+
+	chat := gm.currentChatName
+	gm.selected[chat] = gm.messages[chat][index].ID
+
+	return gm.showSelection(v, chat)
+}
+
+// clearSelection - снятие выбора, история снова прокручивается к новым сообщениям
+func (gm *Manager) clearSelection(g *gocui.Gui, v *gocui.View) error {
+	if _, ok := gm.selected[gm.currentChatName]; !ok {
+		return nil
+	}
+
+	delete(gm.selected, gm.currentChatName)
+	v.Autoscroll = true
+
+	return gm.renderChat(v, gm.currentChatName)
+}
+
+// moveSelection - перемещение выбора в направлении dir на distance строк,
+// строки сообщения считает rows, у края истории выбирается крайнее сообщение
+func (gm *Manager) moveSelection(g *gocui.Gui, v *gocui.View, dir, distance int, rows func(msg entities.Message) int) error {
+	chat := gm.currentChatName
+	messages := gm.messages[chat]
+
+	index := gm.selectedIndex(chat)
+	if index < 0 {
+		index = len(messages)
+	}
+
+	target, moved := -1, 0
+
+	for next := index + dir; next >= 0 && next < len(messages) && moved < distance; next += dir {
+		if selectable(messages[next]) {
+			target = next
+			moved += rows(messages[next])
+		}
+	}
+
+	if target >= 0 {
+		gm.selected[chat] = messages[target].ID
+
+		return gm.showSelection(v, chat)
+	}
+
+	// Выше загруженных сообщений подгружается более старая история
+	if dir < 0 {
+		gm.loadHistory(g, chat)
+	}
+
+	return nil
+}
+
+// showSelection - перерисовка с выбранным сообщением и прокрутка к нему
+func (gm *Manager) showSelection(v *gocui.View, chat string) error {
+	v.Autoscroll = false
+
+	err := gm.renderChat(v, chat)
+	if err != nil {
+		return err
+	}
+
+	// Позиция в истории считается в экранных строках с учетом переноса
+	width, height := v.Size()
+	lines := v.BufferLines()
+
+	start := wrappedHeight(lines[:min(gm.selectedLines[0], len(lines))], width)
+	end := wrappedHeight(lines[:min(gm.selectedLines[1], len(lines))], width)
+
+	_, oy := v.Origin()
+
+	switch {
+	case start < oy:
+		oy = start
+	case end > oy+height:
+		oy = max(end-height, 0)
+	}
+
+	return v.SetOrigin(0, oy)
+}
+
+// selectedIndex - индекс выбранного сообщения чата, -1 если ничего не выбрано
+func (gm *Manager) selectedIndex(chat string) int {
+	id, ok := gm.selected[chat]
+	if !ok {
+		return -1
+	}
+
+	return slices.IndexFunc(gm.messages[chat], func(msg entities.Message) bool {
+		return msg.ID == id && !msg.System
+	})
+}
+
+// selectedMessage - выбранное сообщение, без выбора - последнее сообщение чата
+func (gm *Manager) selectedMessage(chat string) (entities.Message, bool) {
+	messages := gm.messages[chat]
+
+	if index := gm.selectedIndex(chat); index >= 0 {
+		return messages[index], true
+	}
+
+	for i := len(messages) - 1; i >= 0; i-- {
+		if selectable(messages[i]) {
+			return messages[i], true
+		}
+	}
+
+	return entities.Message{}, false
+}
+
+func oneRow(entities.Message) int {
+	return 1
+}
+
+// messageRows - строки сообщения в истории без учета переноса: цитата ответа, текст и реакции
+func messageRows(msg entities.Message) int {
+	rows := 1
+
+	if msg.ReplyTo != "" {
+		rows++
+	}
+
+	if len(msg.Reactions) > 0 {
+		rows++
+	}
+
+	return rows
+}
+
+// selectable - выбрать можно только сохраненное на сервере сообщение
+func selectable(msg entities.Message) bool {
+	return !msg.System && msg.ID != "" && msg.Delivery == entities.DeliveryDelivered
+}
+
+func wrappedHeight(lines []string, width int) int {
+	height := 0
+
+	for _, line := range lines {
+		height += max((runewidth.StringWidth(line)+width-1)/max(width, 1), 1)
+	}
+
+	return height
+}
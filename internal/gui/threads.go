@@ -0,0 +1,302 @@
+package gui
+
+import (
+	"errors"
+	"slices"
+	"strings"
+
+	"github.com/awesome-gocui/gocui"
+	"github.com/gbh007/p2p-chat/internal/entities"
+)
+
+const (
+	threadViewName = "thread"
+	// replyPreviewLen - длина цитаты исходного сообщения в строке ответа, в символах
+	replyPreviewLen = 40
+)
+
+// thread - открытый тред: исходное сообщение и ответы на него в порядке отправки
+type thread struct {
+	chat    string
+	root    entities.Message
+	replies []entities.Message
+}
+
+// HandleThread - загруженный тред показывается рядом с историей чата
+func (gm *Manager) HandleThread(chat string, root entities.Message, replies []entities.Message) {
+	gm.g.Update(func(g *gocui.Gui) error {
+		// Пока тред загружался, пользователь мог перейти в другой чат
+		if chat != gm.currentChatName {
+			return nil
+		}
+
+		gm.thread = &thread{
+			chat:    chat,
+			root:    root,
+			replies: replies,
+		}
+
+		gm.setMessageTitle(g)
+
+		return gm.renderThread(g)
+	})
+}
+
+// openThread - тред выбранного сообщения, для ответа открывается тред исходного сообщения
+func (gm *Manager) openThread(g *gocui.Gui, v *gocui.View) error {
+	chat := gm.currentChatName
+
+	msg, ok := gm.selectedMessage(chat)
+	if !ok {
+		return nil
+	}
+
+	err := gm.callbacker.LoadThread(chat, threadRoot(msg))
+	if err != nil {
+		gm.notice(g, chat, "error: "+err.Error())
+	}
+
+	return nil
+}
+
+// closeThread - закрытие треда, фокус возвращается в историю чата
+func (gm *Manager) closeThread(g *gocui.Gui, v *gocui.View) error {
+	if gm.thread == nil {
+		return nil
+	}
+
+	gm.thread = nil
+	gm.setMessageTitle(g)
+
+	if current := g.CurrentView(); current == nil || current.Name() != threadViewName {
+		return nil
+	}
+
+	_, err := g.SetCurrentView(chatHistoryViewName + gm.currentChatName)
+
+	return err
+}
+
+// scrollThreadUp - прокрутка треда вверх, в отличие от истории чата ничего не подгружает
+func (gm *Manager) scrollThreadUp(g *gocui.Gui, v *gocui.View) error {
+	v.Autoscroll = false
+
+	ox, oy := v.Origin()
+	if oy > 0 {
+		return v.SetOrigin(ox, oy-1)
+	}
+
+	return nil
+}
+
+// threadOpen - открыт ли тред текущего чата
+func (gm *Manager) threadOpen() bool {
+	return gm.thread != nil && gm.thread.chat == gm.currentChatName
+}
+
+// updateThread - новое или измененное сообщение открытого треда
+func (gm *Manager) updateThread(g *gocui.Gui, msg entities.Message) error {
+	t := gm.thread
+	if t == nil || t.chat != msg.Chat || msg.System || msg.ID == "" {
+		return nil
+	}
+
+	if msg.ID == t.root.ID {
+		t.root = msg
+
+		return gm.renderThread(g)
+	}
+
+	if msg.ReplyTo != t.root.ID {
+		return nil
+	}
+
+	index := slices.IndexFunc(t.replies, func(reply entities.Message) bool { return reply.ID == msg.ID })
+
+	switch {
+	case index < 0:
+		t.replies = append(t.replies, msg)
+	// Ожидающая отправки копия не заменяет подтвержденное сервером сообщение
+	case t.replies[index].Delivery == entities.DeliveryDelivered && msg.Delivery != entities.DeliveryDelivered:
+		return nil
+	default:
+		t.replies[index] = msg
+	}
+
+	return gm.renderThread(g)
+}
+
+// setThreadDelivery - состояние отправки ответа в открытом треде
+func (gm *Manager) setThreadDelivery(g *gocui.Gui, chat, id string, state entities.DeliveryState) error {
+	if gm.thread == nil || gm.thread.chat != chat {
+		return nil
+	}
+
+	for i, reply := range gm.thread.replies {
+		if reply.ID == id && reply.Delivery != entities.DeliveryDelivered {
+			gm.thread.replies[i].Delivery = state
+
+			return gm.renderThread(g)
+		}
+	}
+
+	return nil
+}
+
+// layoutThread - история текущего чата и справа от нее открытый тред,
+// без треда история занимает всю ширину
+func (gm *Manager) layoutThread(g *gocui.Gui, x0, y0, x1, y1 int) error {
+	open := gm.threadOpen()
+
+	historyX := x1
+	if open {
+		historyX = x0 + (x1-x0)/2
+	}
+
+	if cv, err := g.View(chatHistoryViewName + gm.currentChatName); err == nil {
+		err = resizeView(g, cv, x0, y0, historyX, y1)
+		if err != nil {
+			return err
+		}
+	}
+
+	v, err := g.SetView(threadViewName, min(historyX+1, x1-1), y0, x1, y1, 0)
+	if err != nil {
+		if !errors.Is(err, gocui.ErrUnknownView) {
+			return err
+		}
+
+		v.Title = "Thread"
+		v.Wrap = true
+		v.Autoscroll = true
+
+		if err := g.SetKeybinding(threadViewName, gocui.KeyEsc, gocui.ModNone, gm.closeThread); err != nil {
+			return err
+		}
+
+		if err := g.SetKeybinding(threadViewName, gocui.KeyArrowUp, gocui.ModNone, gm.scrollThreadUp); err != nil {
+			return err
+		}
+
+		if err := g.SetKeybinding(threadViewName, gocui.KeyArrowDown, gocui.ModNone, gm.scrollDown); err != nil {
+			return err
+		}
+	}
+
+	v.Visible = open
+
+	return nil
+}
+
+// renderThread - перерисовка открытого треда
+func (gm *Manager) renderThread(g *gocui.Gui) error {
+	v, err := g.View(threadViewName)
+	// Панель появится при следующей отрисовке и будет заполнена
+	if errors.Is(err, gocui.ErrUnknownView) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	v.Clear()
+
+	if gm.thread == nil {
+		return nil
+	}
+
+	v.Title = "Thread " + strings.TrimSpace(replyPreview(gm.thread.root))
+
+	err = gm.writeMessage(v, gm.thread.root)
+	if err != nil {
+		return err
+	}
+
+	v.WriteString("──\n")
+
+	for _, reply := range gm.thread.replies {
+		err = gm.writeMessage(v, reply)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeReplyPreview - строка с началом исходного сообщения перед ответом
+func (gm *Manager) writeReplyPreview(v *gocui.View, msg entities.Message) {
+	index := slices.IndexFunc(gm.messages[msg.Chat], func(orig entities.Message) bool {
+		return orig.ID == msg.ReplyTo && !orig.System
+	})
+
+	if index < 0 {
+		v.WriteString("  ↳ reply to an earlier message\n")
+
+		return
+	}
+
+	v.WriteString("  ↳ " + replyPreview(gm.messages[msg.Chat][index]) + "\n")
+}
+
+// replyPreview - автор и начало текста сообщения в одну строку
+func replyPreview(msg entities.Message) string {
+	author := msg.User
+	if !msg.IsLocalDomain {
+		author += "@" + msg.Domain
+	}
+
+	if msg.Deleted {
+		return author + ": [message deleted]"
+	}
+
+	text := []rune(strings.Join(strings.Fields(msg.Text), " "))
+	if len(text) > replyPreviewLen {
+		return author + ": " + string(text[:replyPreviewLen]) + "…"
+	}
+
+	return author + ": " + string(text)
+}
+
+func (gm *Manager) cmdReply(g *gocui.Gui, args string) error {
+	chat, err := gm.currentChat()
+	if err != nil {
+		return err
+	}
+
+	msg, ok := gm.selectedMessage(chat)
+	if !ok {
+		return errNoMessage
+	}
+
+	return gm.callbacker.SendReply(chat, threadRoot(msg), args)
+}
+
+// threadRoot - исходное сообщение треда, ответ на ответ попадает в тот же тред
+func threadRoot(msg entities.Message) string {
+	if msg.ReplyTo != "" {
+		return msg.ReplyTo
+	}
+
+	return msg.ID
+}
+
+// setMessageTitle - заголовок редактора после открытия или закрытия треда
+func (gm *Manager) setMessageTitle(g *gocui.Gui) {
+	if v, err := g.View(chatMessageViewName); err == nil {
+		v.Title = gm.messageTitle()
+	}
+}
+
+// resizeView - изменение размеров окна, если они отличаются от текущих
+func resizeView(g *gocui.Gui, v *gocui.View, x0, y0, x1, y1 int) error {
+	vx0, vy0, vx1, vy1 := v.Dimensions()
+	if vx0 == x0 && vy0 == y0 && vx1 == x1 && vy1 == y1 {
+		return nil
+	}
+
+	_, err := g.SetView(v.Name(), x0, y0, x1, y1, 0)
+
+	return err
+}
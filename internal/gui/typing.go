@@ -0,0 +1,97 @@
+package gui
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/awesome-gocui/gocui"
+)
+
+const (
+	// typingTTL - время показа набора текста после последнего события
+	typingTTL = 5 * time.Second
+	// typingThrottle - минимальный интервал отправки собственных событий набора
+	typingThrottle = 3 * time.Second
+	// typingMaxNames - больше участников не перечисляются по именам
+	typingMaxNames = 3
+)
+
+// HandleTyping - участник чата набирает сообщение
+func (gm *Manager) HandleTyping(chat, user string) {
+	gm.g.Update(func(g *gocui.Gui) error {
+		users, ok := gm.typing[chat]
+		if !ok {
+			users = make(map[string]time.Time)
+			gm.typing[chat] = users
+		}
+
+		users[user] = time.Now().Add(typingTTL)
+
+		// Пустое обновление перерисовывает подвал после истечения времени
+		time.AfterFunc(typingTTL, func() {
+			gm.g.Update(func(g *gocui.Gui) error { return nil })
+		})
+
+		return nil
+	})
+}
+
+// stopTyping - участник отправил сообщение или покинул чат
+func (gm *Manager) stopTyping(chat, user string) {
+	delete(gm.typing[chat], user)
+}
+
+// layoutTyping - строка под историей текущего чата, обновляется при каждой отрисовке
+func (gm *Manager) layoutTyping(g *gocui.Gui, x0, y0, x1, y1 int) error {
+	v, err := g.SetView(typingViewName, x0, y0, x1, y1, 0)
+	if err != nil {
+		if !errors.Is(err, gocui.ErrUnknownView) {
+			return err
+		}
+
+		v.Frame = false
+		v.FgColor = gocui.ColorYellow
+	}
+
+	v.Clear()
+
+	now := time.Now()
+	users := make([]string, 0, len(gm.typing[gm.currentChatName]))
+
+	for user, expire := range gm.typing[gm.currentChatName] {
+		if expire.Before(now) {
+			delete(gm.typing[gm.currentChatName], user)
+
+			continue
+		}
+
+		users = append(users, user)
+	}
+
+	slices.Sort(users)
+
+	switch {
+	case len(users) == 0:
+	case len(users) == 1:
+		v.WriteString(users[0] + " is typing…")
+	case len(users) <= typingMaxNames:
+		v.WriteString(strings.Join(users, ", ") + " are typing…")
+	default:
+		v.WriteString("several people are typing…")
+	}
+
+	return nil
+}
+
+// notifyTyping - отправка события набора не чаще typingThrottle для чата
+func (gm *Manager) notifyTyping(chat string) {
+	if time.Since(gm.typingSent[chat]) < typingThrottle {
+		return
+	}
+
+	gm.typingSent[chat] = time.Now()
+
+	go gm.callbacker.Typing(chat)
+}
@@ -0,0 +1,219 @@
+package identity
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	keyFileName           = "identity.key"
+	encryptionKeyFileName = "encryption.key"
+	knownKeysFileName     = "known_keys.json"
+)
+
+// Keystore - локальное хранилище собственного ключа и ключей собеседников
+type Keystore struct {
+	dir string
+
+	private    ed25519.PrivateKey
+	encryption *ecdh.PrivateKey
+
+	known      map[string]string
+	knownMutex *sync.Mutex
+}
+
+// Open - открывает хранилище, при отсутствии ключа создает новый
+func Open(dir string) (*Keystore, error) {
+	err := os.MkdirAll(dir, 0o700)
+	if err != nil {
+		return nil, fmt.Errorf("create dir: %w", err)
+	}
+
+	ks := &Keystore{
+		dir:        dir,
+		known:      make(map[string]string),
+		knownMutex: &sync.Mutex{},
+	}
+
+	err = ks.loadKey()
+	if err != nil {
+		return nil, fmt.Errorf("key: %w", err)
+	}
+
+	err = ks.loadEncryptionKey()
+	if err != nil {
+		return nil, fmt.Errorf("encryption key: %w", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, knownKeysFileName))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("known keys: %w", err)
+	}
+
+	if len(data) > 0 {
+		err = json.Unmarshal(data, &ks.known)
+		if err != nil {
+			return nil, fmt.Errorf("known keys: %w", err)
+		}
+	}
+
+	return ks, nil
+}
+
+func DefaultDir() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ".p2p-chat"
+	}
+
+	return filepath.Join(dir, "p2p-chat")
+}
+
+func (ks *Keystore) PrivateKey() ed25519.PrivateKey {
+	return ks.private
+}
+
+func (ks *Keystore) PublicKey() ed25519.PublicKey {
+	return ks.private.Public().(ed25519.PublicKey)
+}
+
+func (ks *Keystore) EncryptionKey() *ecdh.PrivateKey {
+	return ks.encryption
+}
+
+// KnownKey - ранее закрепленный ключ пользователя
+func (ks *Keystore) KnownKey(login string) (ed25519.PublicKey, bool) {
+	ks.knownMutex.Lock()
+	defer ks.knownMutex.Unlock()
+
+	encoded, ok := ks.known[login]
+	if !ok {
+		return nil, false
+	}
+
+	key, err := hex.DecodeString(encoded)
+	if err != nil {
+		return nil, false
+	}
+
+	return key, true
+}
+
+// Pin - запоминает ключ пользователя при первой встрече,
+// false если ранее для этого пользователя был сохранен другой ключ
+func (ks *Keystore) Pin(login string, key ed25519.PublicKey) (bool, error) {
+	ks.knownMutex.Lock()
+	defer ks.knownMutex.Unlock()
+
+	encoded := hex.EncodeToString(key)
+
+	known, ok := ks.known[login]
+	if ok {
+		return known == encoded, nil
+	}
+
+	ks.known[login] = encoded
+
+	data, err := json.MarshalIndent(ks.known, "", "  ")
+	if err != nil {
+		return false, fmt.Errorf("marshal: %w", err)
+	}
+
+	err = os.WriteFile(filepath.Join(ks.dir, knownKeysFileName), data, 0o600)
+	if err != nil {
+		return false, fmt.Errorf("write: %w", err)
+	}
+
+	return true, nil
+}
+
+func (ks *Keystore) loadKey() error {
+	seed, err := loadOrCreate(filepath.Join(ks.dir, keyFileName), func() ([]byte, error) {
+		_, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+
+		return private.Seed(), nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(seed) != ed25519.SeedSize {
+		return fmt.Errorf("invalid key size %d", len(seed))
+	}
+
+	ks.private = ed25519.NewKeyFromSeed(seed)
+
+	return nil
+}
+
+func (ks *Keystore) loadEncryptionKey() error {
+	raw, err := loadOrCreate(filepath.Join(ks.dir, encryptionKeyFileName), func() ([]byte, error) {
+		private, err := ecdh.X25519().GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+
+		return private.Bytes(), nil
+	})
+	if err != nil {
+		return err
+	}
+
+	ks.encryption, err = ecdh.X25519().NewPrivateKey(raw)
+	if err != nil {
+		return fmt.Errorf("parse: %w", err)
+	}
+
+	return nil
+}
+
+// loadOrCreate - читает ключ в hex из файла, при отсутствии файла создает ключ
+func loadOrCreate(path string, generate func() ([]byte, error)) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		key, err := generate()
+		if err != nil {
+			return nil, fmt.Errorf("generate: %w", err)
+		}
+
+		err = os.WriteFile(path, []byte(hex.EncodeToString(key)+"\n"), 0o600)
+		if err != nil {
+			return nil, fmt.Errorf("write: %w", err)
+		}
+
+		return key, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("read: %w", err)
+	}
+
+	key, err := hex.DecodeString(string(bytes.TrimSpace(data)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid key file %s", path)
+	}
+
+	return key, nil
+}
+
+// Fingerprint - короткое представление ключа, используется как логин по умолчанию
+func Fingerprint(key ed25519.PublicKey) string {
+	encoded := hex.EncodeToString(key)
+	if len(encoded) > 16 {
+		encoded = encoded[:16]
+	}
+
+	return encoded
+}
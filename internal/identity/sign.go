@@ -0,0 +1,238 @@
+package identity
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"time"
+
+	"github.com/gbh007/p2p-chat/internal/entities"
+)
+
+// Контексты отделяют подписи разных сущностей сделанные одним ключом
+const (
+	signContext          = "p2p-chat/message/v1"
+	deleteContext        = "p2p-chat/delete/v1"
+	encryptionKeyContext = "p2p-chat/encryption-key/v1"
+	keyShareContext      = "p2p-chat/key-share/v1"
+	federationContext    = "p2p-chat/federation/v1"
+	contactContext       = "p2p-chat/dht-contact/v1"
+	forwardContext       = "p2p-chat/forward/v1"
+	helloContext         = "p2p-chat/hello/v1"
+)
+
+// SignMessage - подпись над каналом, текстом, видом, временем и идентификатором сообщения
+func SignMessage(key ed25519.PrivateKey, channel, text string, kind entities.MessageKind, ts time.Time, id string) []byte {
+	return ed25519.Sign(key, messagePayload(channel, text, kind, ts, id))
+}
+
+func VerifyMessage(key ed25519.PublicKey, channel, text string, kind entities.MessageKind, ts time.Time, id string, signature []byte) bool {
+	if len(key) != ed25519.PublicKeySize {
+		return false
+	}
+
+	return ed25519.Verify(key, messagePayload(channel, text, kind, ts, id), signature)
+}
+
+// messagePayload - поля с префиксом длины, чтобы их границы нельзя было сдвинуть.
+// Подписывается имя канала без домена, чтобы подпись проверялась и на сервере канала, и на других серверах федерации.
+// Вид обычного сообщения не добавляется, поэтому подписи сделанные до появления видов остаются верными
+func messagePayload(channel, text string, kind entities.MessageKind, ts time.Time, id string) []byte {
+	channel, _ = entities.SplitChannel(channel)
+
+	payload := make([]byte, 0, len(signContext)+len(channel)+len(text)+len(id)+32)
+
+	payload = appendField(payload, []byte(signContext))
+	payload = appendField(payload, []byte(channel))
+	payload = appendField(payload, []byte(text))
+	payload = binary.BigEndian.AppendUint64(payload, uint64(ts.UnixNano()))
+	payload = appendField(payload, []byte(id))
+
+	if kind != entities.MessageText {
+		payload = binary.BigEndian.AppendUint32(payload, uint32(kind))
+	}
+
+	return payload
+}
+
+// SignDeletion - подпись автора над удалением сообщения, позволяет другим узлам проверить удаление
+func SignDeletion(key ed25519.PrivateKey, channel, id string, ts time.Time) []byte {
+	return ed25519.Sign(key, deletionPayload(channel, id, ts))
+}
+
+func VerifyDeletion(key ed25519.PublicKey, channel, id string, ts time.Time, signature []byte) bool {
+	if len(key) != ed25519.PublicKeySize {
+		return false
+	}
+
+	return ed25519.Verify(key, deletionPayload(channel, id, ts), signature)
+}
+
+func deletionPayload(channel, id string, ts time.Time) []byte {
+	channel, _ = entities.SplitChannel(channel)
+
+	return fieldsPayload(
+		deleteContext,
+		[]byte(channel),
+		[]byte(id),
+		binary.BigEndian.AppendUint64(nil, uint64(ts.UnixNano())),
+	)
+}
+
+func appendField(payload, field []byte) []byte {
+	payload = binary.BigEndian.AppendUint32(payload, uint32(len(field)))
+
+	return append(payload, field...)
+}
+
+// SignedText - подписываемое содержимое, для зашифрованных сообщений это шифротекст
+func SignedText(text string, ciphertext []byte) string {
+	if len(ciphertext) > 0 {
+		return string(ciphertext)
+	}
+
+	return text
+}
+
+// SignEncryptionKey - подтверждает что ключ шифрования принадлежит владельцу ключа подписи
+func SignEncryptionKey(key ed25519.PrivateKey, login string, encryptionKey []byte) []byte {
+	return ed25519.Sign(key, fieldsPayload(encryptionKeyContext, []byte(login), encryptionKey))
+}
+
+func VerifyEncryptionKey(key ed25519.PublicKey, login string, encryptionKey, signature []byte) bool {
+	if len(key) != ed25519.PublicKeySize {
+		return false
+	}
+
+	return ed25519.Verify(key, fieldsPayload(encryptionKeyContext, []byte(login), encryptionKey), signature)
+}
+
+// SignKeyShare - подпись владельца канала над групповым ключом, выданным участнику
+func SignKeyShare(key ed25519.PrivateKey, channel string, epoch uint32, login string, wrapped []byte) []byte {
+	return ed25519.Sign(key, keySharePayload(channel, epoch, login, wrapped))
+}
+
+func VerifyKeyShare(key ed25519.PublicKey, channel string, epoch uint32, login string, wrapped, signature []byte) bool {
+	if len(key) != ed25519.PublicKeySize {
+		return false
+	}
+
+	return ed25519.Verify(key, keySharePayload(channel, epoch, login, wrapped), signature)
+}
+
+// SignServerRequest - подпись сервера федерации над запросом к другому серверу,
+// ref связывает подпись с содержимым запроса (идентификатор сообщения или курсор)
+func SignServerRequest(key ed25519.PrivateKey, domain, method, channel, ref string, ts time.Time) []byte {
+	return ed25519.Sign(key, serverRequestPayload(domain, method, channel, ref, ts))
+}
+
+func VerifyServerRequest(key ed25519.PublicKey, domain, method, channel, ref string, ts time.Time, signature []byte) bool {
+	if len(key) != ed25519.PublicKeySize {
+		return false
+	}
+
+	return ed25519.Verify(key, serverRequestPayload(domain, method, channel, ref, ts), signature)
+}
+
+// ForwardDigest - хеш всех полей пересылаемого сообщения, служит ref подписи сервера,
+// поэтому промежуточный узел не может изменить сообщение не нарушив подпись
+func ForwardDigest(
+	channel, login, text string,
+	kind entities.MessageKind,
+	ts time.Time,
+	replyTo, id string,
+	publicKey, signature []byte,
+) string {
+	channel, _ = entities.SplitChannel(channel)
+
+	digest := sha256.Sum256(fieldsPayload(
+		forwardContext,
+		[]byte(channel),
+		[]byte(login),
+		[]byte(text),
+		binary.BigEndian.AppendUint32(nil, uint32(kind)),
+		binary.BigEndian.AppendUint64(nil, uint64(ts.UnixNano())),
+		[]byte(replyTo),
+		[]byte(id),
+		publicKey,
+		signature,
+	))
+
+	return hex.EncodeToString(digest[:])
+}
+
+// SignContact - подпись узла над своим адресом в DHT, доказывает владение ключом узла
+func SignContact(key ed25519.PrivateKey, addr string, ts time.Time) []byte {
+	return ed25519.Sign(key, contactPayload(addr, ts))
+}
+
+func VerifyContact(key ed25519.PublicKey, addr string, ts time.Time, signature []byte) bool {
+	if len(key) != ed25519.PublicKeySize {
+		return false
+	}
+
+	return ed25519.Verify(key, contactPayload(addr, ts), signature)
+}
+
+// SignHello - подпись узла p2p сети над своим адресом при знакомстве с соседом,
+// challenge - подпись запроса в ответе, чтобы ответ нельзя было повторить другому узлу
+func SignHello(key ed25519.PrivateKey, addr string, ts time.Time, challenge []byte) []byte {
+	return ed25519.Sign(key, helloPayload(addr, ts, challenge))
+}
+
+func VerifyHello(key ed25519.PublicKey, addr string, ts time.Time, challenge, signature []byte) bool {
+	if len(key) != ed25519.PublicKeySize {
+		return false
+	}
+
+	return ed25519.Verify(key, helloPayload(addr, ts, challenge), signature)
+}
+
+func helloPayload(addr string, ts time.Time, challenge []byte) []byte {
+	return fieldsPayload(
+		helloContext,
+		[]byte(addr),
+		binary.BigEndian.AppendUint64(nil, uint64(ts.UnixNano())),
+		challenge,
+	)
+}
+
+func contactPayload(addr string, ts time.Time) []byte {
+	return fieldsPayload(
+		contactContext,
+		[]byte(addr),
+		binary.BigEndian.AppendUint64(nil, uint64(ts.UnixNano())),
+	)
+}
+
+func serverRequestPayload(domain, method, channel, ref string, ts time.Time) []byte {
+	return fieldsPayload(
+		federationContext,
+		[]byte(domain),
+		[]byte(method),
+		[]byte(channel),
+		[]byte(ref),
+		binary.BigEndian.AppendUint64(nil, uint64(ts.UnixNano())),
+	)
+}
+
+func keySharePayload(channel string, epoch uint32, login string, wrapped []byte) []byte {
+	return fieldsPayload(
+		keyShareContext,
+		[]byte(channel),
+		binary.BigEndian.AppendUint32(nil, epoch),
+		[]byte(login),
+		wrapped,
+	)
+}
+
+func fieldsPayload(context string, fields ...[]byte) []byte {
+	payload := appendField(nil, []byte(context))
+
+	for _, field := range fields {
+		payload = appendField(payload, field)
+	}
+
+	return payload
+}
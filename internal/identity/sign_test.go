@@ -0,0 +1,99 @@
+package identity
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/gbh007/p2p-chat/internal/entities"
+)
+
+func TestVerifyMessage(t *testing.T) {
+	publicKey, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := time.Now()
+	signature := SignMessage(key, "room", "hello", entities.MessageAction, ts, "m1")
+
+	if !VerifyMessage(publicKey, "room", "hello", entities.MessageAction, ts, "m1", signature) {
+		t.Fatal("valid signature is rejected")
+	}
+
+	// Подпись проверяется и на других серверах федерации, где к каналу добавлен домен
+	if !VerifyMessage(publicKey, "room@remote", "hello", entities.MessageAction, ts, "m1", signature) {
+		t.Fatal("signature must not depend on the channel domain")
+	}
+
+	otherKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		key     ed25519.PublicKey
+		channel string
+		text    string
+		kind    entities.MessageKind
+		ts      time.Time
+		id      string
+	}{
+		"channel": {key: publicKey, channel: "other", text: "hello", kind: entities.MessageAction, ts: ts, id: "m1"},
+		"text":    {key: publicKey, channel: "room", text: "hell", kind: entities.MessageAction, ts: ts, id: "m1"},
+		"kind":    {key: publicKey, channel: "room", text: "hello", kind: entities.MessageText, ts: ts, id: "m1"},
+		"topic":   {key: publicKey, channel: "room", text: "hello", kind: entities.MessageTopic, ts: ts, id: "m1"},
+		"ts":      {key: publicKey, channel: "room", text: "hello", kind: entities.MessageAction, ts: ts.Add(time.Nanosecond), id: "m1"},
+		"id":      {key: publicKey, channel: "room", text: "hello", kind: entities.MessageAction, ts: ts, id: "m2"},
+		"key":     {key: otherKey, channel: "room", text: "hello", kind: entities.MessageAction, ts: ts, id: "m1"},
+		"no key":  {key: nil, channel: "room", text: "hello", kind: entities.MessageAction, ts: ts, id: "m1"},
+		// Границы полей не сдвигаются между каналом и текстом
+		"shifted": {key: publicKey, channel: "roomh", text: "ello", kind: entities.MessageAction, ts: ts, id: "m1"},
+	}
+
+	for name, tt := range tests {
+		if VerifyMessage(tt.key, tt.channel, tt.text, tt.kind, tt.ts, tt.id, signature) {
+			t.Fatalf("signature with changed %s is accepted", name)
+		}
+	}
+}
+
+func TestMessagePayloadWithoutKind(t *testing.T) {
+	ts := time.Unix(1700000000, 42)
+
+	// Подписи обычных сообщений сделанные до появления видов остаются верными
+	legacy := fieldsPayload(signContext, []byte("room"), []byte("hello"))
+	legacy = binary.BigEndian.AppendUint64(legacy, uint64(ts.UnixNano()))
+	legacy = appendField(legacy, []byte("m1"))
+
+	if !bytes.Equal(legacy, messagePayload("room", "hello", entities.MessageText, ts, "m1")) {
+		t.Fatal("payload of text message must not change")
+	}
+
+	if bytes.Equal(legacy, messagePayload("room", "hello", entities.MessageTopic, ts, "m1")) {
+		t.Fatal("payload must depend on non text kind")
+	}
+}
+
+func TestForwardDigest(t *testing.T) {
+	ts := time.Now()
+	digest := ForwardDigest("room@remote", "alice", "hello", entities.MessageText, ts, "", "m1", nil, nil)
+
+	if digest != ForwardDigest("room", "alice", "hello", entities.MessageText, ts, "", "m1", nil, nil) {
+		t.Fatal("digest must not depend on the channel domain")
+	}
+
+	for name, other := range map[string]string{
+		"login":    ForwardDigest("room", "bob", "hello", entities.MessageText, ts, "", "m1", nil, nil),
+		"kind":     ForwardDigest("room", "alice", "hello", entities.MessageAction, ts, "", "m1", nil, nil),
+		"reply to": ForwardDigest("room", "alice", "hello", entities.MessageText, ts, "m0", "m1", nil, nil),
+		"key":      ForwardDigest("room", "alice", "hello", entities.MessageText, ts, "", "m1", []byte("key"), nil),
+	} {
+		if other == digest {
+			t.Fatalf("digest must change with %s", name)
+		}
+	}
+}
@@ -0,0 +1,320 @@
+package mdns
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+	"golang.org/x/net/ipv4"
+)
+
+const (
+	// ServiceName - тип службы DNS-SD для узлов чата
+	ServiceName = "_p2pchat._tcp"
+
+	groupAddr     = "224.0.0.251:5353"
+	recordTTL     = 120
+	queryInterval = 10 * time.Second
+	maxPacketSize = 9000
+	idKey         = "id="
+)
+
+var serviceFQDN = dnsmessage.MustNewName(ServiceName + ".local.")
+
+type Peer struct {
+	ID   string
+	Addr string
+}
+
+// Service - объявление узла в локальной сети и поиск других узлов через mDNS.
+// Адрес найденного узла берется из источника пакета и порта SRV записи
+type Service struct {
+	logger *slog.Logger
+
+	id      string
+	port    uint16
+	handler func(Peer)
+
+	// ifi - интерфейс для приема и отправки, nil - выбор системы
+	ifi   *net.Interface
+	group *net.UDPAddr
+}
+
+func New(id string, port int, handler func(Peer)) *Service {
+	return &Service{
+		logger:  slog.Default(),
+		id:      id,
+		port:    uint16(port),
+		handler: handler,
+	}
+}
+
+// SetInterface - сетевой интерфейс для mDNS, вызывать до Run
+func (s *Service) SetInterface(ifi *net.Interface) {
+	s.ifi = ifi
+}
+
+// Run - обслуживание до отмены контекста
+func (s *Service) Run(ctx context.Context) error {
+	group, err := net.ResolveUDPAddr("udp4", groupAddr)
+	if err != nil {
+		return err
+	}
+
+	s.group = group
+
+	listener, err := net.ListenMulticastUDP("udp4", s.ifi, group)
+	if err != nil {
+		return err
+	}
+
+	defer listener.Close()
+
+	sender, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return err
+	}
+
+	defer sender.Close()
+
+	if s.ifi != nil {
+		err = ipv4.NewPacketConn(sender).SetMulticastInterface(s.ifi)
+		if err != nil {
+			return err
+		}
+	}
+
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	go s.poll(ctx, sender)
+
+	buf := make([]byte, maxPacketSize)
+
+	for {
+		n, src, err := listener.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			return err
+		}
+
+		s.handle(sender, buf[:n], src)
+	}
+}
+
+// poll - периодическое объявление себя и запрос других узлов
+func (s *Service) poll(ctx context.Context, sender *net.UDPConn) {
+	ticker := time.NewTicker(queryInterval)
+	defer ticker.Stop()
+
+	for {
+		s.write(sender, s.announcement)
+		s.write(sender, query)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Service) write(sender *net.UDPConn, build func() ([]byte, error)) {
+	packet, err := build()
+	if err != nil {
+		s.logger.Error("build mdns packet", "error", err)
+
+		return
+	}
+
+	_, err = sender.WriteToUDP(packet, s.group)
+	if err != nil {
+		s.logger.Warn("send mdns packet", "error", err)
+	}
+}
+
+func (s *Service) handle(sender *net.UDPConn, packet []byte, src *net.UDPAddr) {
+	var p dnsmessage.Parser
+
+	header, err := p.Start(packet)
+	if err != nil {
+		return
+	}
+
+	if !header.Response {
+		questions, err := p.AllQuestions()
+		if err != nil {
+			return
+		}
+
+		for _, q := range questions {
+			if q.Name == serviceFQDN && (q.Type == dnsmessage.TypePTR || q.Type == dnsmessage.TypeALL) {
+				s.write(sender, s.announcement)
+
+				return
+			}
+		}
+
+		return
+	}
+
+	err = p.SkipAllQuestions()
+	if err != nil {
+		return
+	}
+
+	for _, peer := range parsePeers(&p, src.IP) {
+		if peer.ID != s.id {
+			s.handler(peer)
+		}
+	}
+}
+
+// instance - сведения об экземпляре службы, собираются из нескольких записей
+type instance struct {
+	id   string
+	port uint16
+}
+
+func parsePeers(p *dnsmessage.Parser, ip net.IP) []Peer {
+	instances := make(map[string]*instance)
+	get := func(name dnsmessage.Name) *instance {
+		inst, ok := instances[name.String()]
+		if !ok {
+			inst = &instance{}
+			instances[name.String()] = inst
+		}
+
+		return inst
+	}
+
+	for {
+		h, err := p.AnswerHeader()
+		if errors.Is(err, dnsmessage.ErrSectionDone) {
+			break
+		}
+
+		if err != nil {
+			return nil
+		}
+
+		if !strings.HasSuffix(h.Name.String(), serviceFQDN.String()) {
+			_ = p.SkipAnswer()
+
+			continue
+		}
+
+		switch h.Type {
+		case dnsmessage.TypeSRV:
+			srv, err := p.SRVResource()
+			if err != nil {
+				return nil
+			}
+
+			get(h.Name).port = srv.Port
+		case dnsmessage.TypeTXT:
+			txt, err := p.TXTResource()
+			if err != nil {
+				return nil
+			}
+
+			for _, v := range txt.TXT {
+				if strings.HasPrefix(v, idKey) {
+					get(h.Name).id = strings.TrimPrefix(v, idKey)
+				}
+			}
+		default:
+			_ = p.SkipAnswer()
+		}
+	}
+
+	var result []Peer
+
+	for _, inst := range instances {
+		if inst.id == "" || inst.port == 0 {
+			continue
+		}
+
+		result = append(result, Peer{
+			ID:   inst.id,
+			Addr: net.JoinHostPort(ip.String(), strconv.Itoa(int(inst.port))),
+		})
+	}
+
+	return result
+}
+
+func (s *Service) announcement() ([]byte, error) {
+	instanceName, err := dnsmessage.NewName(s.id + "." + serviceFQDN.String())
+	if err != nil {
+		return nil, err
+	}
+
+	host, err := dnsmessage.NewName(s.id + ".local.")
+	if err != nil {
+		return nil, err
+	}
+
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{Response: true, Authoritative: true})
+	b.EnableCompression()
+
+	err = b.StartAnswers()
+	if err != nil {
+		return nil, err
+	}
+
+	err = b.PTRResource(resourceHeader(serviceFQDN), dnsmessage.PTRResource{PTR: instanceName})
+	if err != nil {
+		return nil, err
+	}
+
+	err = b.SRVResource(resourceHeader(instanceName), dnsmessage.SRVResource{Port: s.port, Target: host})
+	if err != nil {
+		return nil, err
+	}
+
+	err = b.TXTResource(resourceHeader(instanceName), dnsmessage.TXTResource{TXT: []string{idKey + s.id}})
+	if err != nil {
+		return nil, err
+	}
+
+	return b.Finish()
+}
+
+func query() ([]byte, error) {
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{})
+
+	err := b.StartQuestions()
+	if err != nil {
+		return nil, err
+	}
+
+	err = b.Question(dnsmessage.Question{
+		Name:  serviceFQDN,
+		Type:  dnsmessage.TypePTR,
+		Class: dnsmessage.ClassINET,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return b.Finish()
+}
+
+func resourceHeader(name dnsmessage.Name) dnsmessage.ResourceHeader {
+	return dnsmessage.ResourceHeader{
+		Name:  name,
+		Class: dnsmessage.ClassINET,
+		TTL:   recordTTL,
+	}
+}
@@ -0,0 +1,103 @@
+package mdns
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestAnnouncementPeers(t *testing.T) {
+	s := New("node-a", 9000, nil)
+
+	packet, err := s.announcement()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var p dnsmessage.Parser
+
+	_, err = p.Start(packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = p.SkipAllQuestions()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	peers := parsePeers(&p, net.IPv4(192, 168, 1, 5))
+	if len(peers) != 1 || peers[0] != (Peer{ID: "node-a", Addr: "192.168.1.5:9000"}) {
+		t.Fatalf("unexpected peers: %+v", peers)
+	}
+}
+
+// TestLoopbackDiscovery - два узла на одной машине находят друг друга через multicast на loopback интерфейсе
+func TestLoopbackDiscovery(t *testing.T) {
+	lo := loopback(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	found := make(chan Peer, 16)
+	start := func(id string, port int) {
+		s := New(id, port, func(peer Peer) {
+			if id == "node-a" {
+				found <- peer
+			}
+		})
+		s.SetInterface(lo)
+
+		go func() {
+			err := s.Run(ctx)
+			if err != nil {
+				t.Errorf("run %s: %v", id, err)
+			}
+		}()
+	}
+
+	start("node-a", 9001)
+	start("node-b", 9002)
+
+	timeout := time.After(5 * time.Second)
+
+	for {
+		select {
+		case peer := <-found:
+			if peer.ID == "node-a" {
+				t.Fatal("node discovered itself")
+			}
+
+			_, port, err := net.SplitHostPort(peer.Addr)
+			if err != nil || peer.ID != "node-b" || port != "9002" {
+				t.Fatalf("unexpected peer: %+v", peer)
+			}
+
+			return
+		case <-timeout:
+			t.Fatal("peer not discovered")
+		}
+	}
+}
+
+func loopback(t *testing.T) *net.Interface {
+	t.Helper()
+
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ifi := range interfaces {
+		if ifi.Flags&net.FlagLoopback != 0 && ifi.Flags&net.FlagUp != 0 {
+			return &ifi
+		}
+	}
+
+	t.Skip("no loopback interface")
+
+	return nil
+}
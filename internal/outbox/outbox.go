@@ -0,0 +1,143 @@
+package outbox
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gbh007/p2p-chat/internal/entities"
+)
+
+// Entry - неотправленное сообщение, ID используется сервером как ключ идемпотентности
+type Entry struct {
+	ID      string    `json:"id"`
+	Chat    string    `json:"chat"`
+	Text    string    `json:"text"`
+	Created time.Time `json:"created"`
+	// ReplyTo - сообщение на которое отвечает это, пусто для обычных сообщений
+	ReplyTo string               `json:"reply_to,omitempty"`
+	Kind    entities.MessageKind `json:"kind,omitempty"`
+}
+
+// Outbox - очередь исходящих сообщений на диске, сообщения отправляются в порядке добавления
+type Outbox struct {
+	path string
+
+	entries []Entry
+	mutex   *sync.Mutex
+	notify  chan struct{}
+}
+
+func Open(path string) (*Outbox, error) {
+	o := &Outbox{
+		path:   path,
+		mutex:  &sync.Mutex{},
+		notify: make(chan struct{}, 1),
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return o, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("read outbox: %w", err)
+	}
+
+	err = json.Unmarshal(data, &o.entries)
+	if err != nil {
+		return nil, fmt.Errorf("parse outbox: %w", err)
+	}
+
+	return o, nil
+}
+
+func (o *Outbox) Add(entry Entry) error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	o.entries = append(o.entries, entry)
+
+	err := o.save()
+	if err != nil {
+		o.entries = o.entries[:len(o.entries)-1]
+
+		return err
+	}
+
+	select {
+	case o.notify <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+func (o *Outbox) Remove(id string) error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	o.entries = slices.DeleteFunc(o.entries, func(e Entry) bool {
+		return e.ID == id
+	})
+
+	return o.save()
+}
+
+// Head - самое старое неотправленное сообщение
+func (o *Outbox) Head() (Entry, bool) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if len(o.entries) == 0 {
+		return Entry{}, false
+	}
+
+	return o.entries[0], true
+}
+
+func (o *Outbox) Chat(chat string) []Entry {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	var result []Entry
+
+	for _, e := range o.entries {
+		if e.Chat == chat {
+			result = append(result, e)
+		}
+	}
+
+	return result
+}
+
+// Notify - сигнал о новых сообщениях в очереди
+func (o *Outbox) Notify() <-chan struct{} {
+	return o.notify
+}
+
+// save - запись через временный файл, чтобы очередь не повредилась при падении, вызывать только под блокировкой
+func (o *Outbox) save() error {
+	data, err := json.Marshal(o.entries)
+	if err != nil {
+		return err
+	}
+
+	tmp := o.path + ".tmp"
+
+	err = os.WriteFile(tmp, data, 0o600)
+	if err != nil {
+		return fmt.Errorf("write outbox: %w", err)
+	}
+
+	err = os.Rename(tmp, o.path)
+	if err != nil {
+		return fmt.Errorf("write outbox: %w", err)
+	}
+
+	return nil
+}
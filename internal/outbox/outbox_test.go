@@ -0,0 +1,62 @@
+package outbox
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gbh007/p2p-chat/internal/entities"
+)
+
+func TestOutboxReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.json")
+
+	o, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := o.Head(); ok {
+		t.Fatal("new outbox must be empty")
+	}
+
+	entries := []Entry{
+		{ID: "m1", Chat: "room", Text: "first", Created: time.Unix(1, 0).UTC()},
+		{ID: "m2", Chat: "other", Text: "second", Created: time.Unix(2, 0).UTC(), Kind: entities.MessageAction},
+		{ID: "m3", Chat: "room", Text: "third", Created: time.Unix(3, 0).UTC(), ReplyTo: "m0"},
+	}
+
+	for _, e := range entries {
+		err = o.Add(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	select {
+	case <-o.Notify():
+	default:
+		t.Fatal("add must notify the sender")
+	}
+
+	err = o.Remove("m1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Неотправленные сообщения переживают перезапуск клиента с теми же идентификаторами
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	head, ok := reopened.Head()
+	if !ok || head != entries[1] {
+		t.Fatalf("unexpected head: %+v", head)
+	}
+
+	room := reopened.Chat("room")
+	if len(room) != 1 || room[0] != entries[2] {
+		t.Fatalf("unexpected entries of room: %+v", room)
+	}
+}
@@ -0,0 +1,41 @@
+package p2p
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"sync"
+)
+
+// AuthorKeys - ключи авторов, закрепленные при первой встрече, false если за логином закреплен другой ключ
+type AuthorKeys interface {
+	Pin(login string, key ed25519.PublicKey) (bool, error)
+}
+
+// memoryAuthors - закрепление до перезапуска узла, когда хранилище ключей не задано
+type memoryAuthors struct {
+	keys  map[string]string
+	mutex *sync.Mutex
+}
+
+func newMemoryAuthors() *memoryAuthors {
+	return &memoryAuthors{
+		keys:  make(map[string]string),
+		mutex: &sync.Mutex{},
+	}
+}
+
+func (a *memoryAuthors) Pin(login string, key ed25519.PublicKey) (bool, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	encoded := hex.EncodeToString(key)
+
+	known, ok := a.keys[login]
+	if ok {
+		return known == encoded, nil
+	}
+
+	a.keys[login] = encoded
+
+	return true, nil
+}
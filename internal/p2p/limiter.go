@@ -0,0 +1,52 @@
+package p2p
+
+import (
+	"sync"
+	"time"
+)
+
+// limiter - ограничение частоты действий: запас burst пополняется на одно действие каждые interval
+type limiter struct {
+	interval time.Duration
+	burst    int
+
+	tokens int
+	last   time.Time
+	mutex  *sync.Mutex
+}
+
+func newLimiter(interval time.Duration, burst int) *limiter {
+	return &limiter{
+		interval: interval,
+		burst:    burst,
+		tokens:   burst,
+		last:     time.Now(),
+		mutex:    &sync.Mutex{},
+	}
+}
+
+// allow - действие разрешено, запас уменьшается
+func (l *limiter) allow() bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+
+	refill := int(now.Sub(l.last) / l.interval)
+	if refill > 0 {
+		l.tokens = min(l.burst, l.tokens+refill)
+		l.last = l.last.Add(time.Duration(refill) * l.interval)
+	}
+
+	if l.tokens == 0 {
+		return false
+	}
+
+	if l.tokens == l.burst {
+		l.last = now
+	}
+
+	l.tokens--
+
+	return true
+}
@@ -0,0 +1,773 @@
+package p2p
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"log/slog"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gbh007/p2p-chat/internal/auth"
+	"github.com/gbh007/p2p-chat/internal/dht"
+	"github.com/gbh007/p2p-chat/internal/entities"
+	"github.com/gbh007/p2p-chat/internal/identity"
+	"github.com/gbh007/p2p-chat/internal/protoconv"
+	"github.com/gbh007/p2p-chat/internal/server"
+	"github.com/gbh007/p2p-chat/internal/storage"
+	"github.com/gbh007/p2p-chat/proto/gen"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	// defaultTTL - сколько раз сообщение может быть переслано, защита от бесконечной пересылки
+	// если идентификатор уже вытеснен из истории узла
+	defaultTTL   = 8
+	historySize  = 1000
+	helloTimeout = 5 * time.Second
+	// helloMaxSkew - допустимое расхождение времени подписи знакомства с текущим
+	helloMaxSkew = 5 * time.Minute
+	// maxPeers - к адресам из списков соседей и обратно к новым узлам подключение идет только до этого числа соседей
+	maxPeers = 32
+	// maxDiscover - сколько адресов берется из одного списка соседа
+	maxDiscover = 8
+	// discoverInterval, discoverBurst - частота подключений к адресам из списков соседей
+	discoverInterval = time.Second
+	discoverBurst    = maxDiscover
+	// relayedSize - сколько идентификаторов пересланных без сохранения сообщений помнит узел
+	relayedSize = 4096
+)
+
+var (
+	ErrSelfConnect  = errors.New("connect to self")
+	errInvalidHello = errors.New("invalid hello signature")
+)
+
+// Node - узел p2p сети: локальный сервер для клиента и сервер для соседей,
+// сообщения каналов распространяются между узлами с исключением дублей по идентификатору
+type Node struct {
+	gen.UnimplementedPeerServer
+	logger *slog.Logger
+
+	id         string
+	privateKey ed25519.PrivateKey
+	advertise  string
+
+	server *server.Server
+	store  *storage.Memory
+	auth   *auth.Service
+	dht    *dht.DHT
+
+	peers      map[string]*peer
+	peersMutex *sync.RWMutex
+
+	// authors - логин не совпадающий с отпечатком ключа закрепляется за первым встреченным ключом
+	authors AuthorKeys
+
+	// joined - каналы локального клиента, сообщения остальных каналов только пересылаются
+	joined      map[string]struct{}
+	joinedMutex *sync.RWMutex
+	// relayed - сообщения чужих каналов, уже пересланные дальше
+	relayed *recent
+
+	// discoverLimit - список адресов от соседа не должен порождать много исходящих подключений
+	discoverLimit *limiter
+
+	// discovered - узлы найденные в локальной сети, идентификатор -> адрес
+	discovered   map[string]string
+	peersHandler func([]entities.Peer)
+	handlerMutex *sync.Mutex
+}
+
+// New - узел с идентификатором из ключа узла, advertise - адрес по которому до узла могут достучаться соседи,
+// authors - хранилище закрепленных ключей авторов, при nil ключи закрепляются до перезапуска
+func New(privateKey ed25519.PrivateKey, advertise string, authors AuthorKeys) *Node {
+	publicKey := privateKey.Public().(ed25519.PublicKey)
+
+	if authors == nil {
+		authors = newMemoryAuthors()
+	}
+
+	store := storage.NewMemory(historySize)
+	accounts := auth.New(store)
+
+	n := &Node{
+		logger:        slog.Default(),
+		id:            identity.Fingerprint(publicKey),
+		privateKey:    privateKey,
+		advertise:     advertise,
+		server:        server.New(store, store, accounts, server.DefaultLimits()),
+		store:         store,
+		auth:          accounts,
+		dht:           dht.New(privateKey, advertise),
+		peers:         make(map[string]*peer),
+		peersMutex:    &sync.RWMutex{},
+		authors:       authors,
+		joined:        make(map[string]struct{}),
+		joinedMutex:   &sync.RWMutex{},
+		relayed:       newRecent(relayedSize),
+		discoverLimit: newLimiter(discoverInterval, discoverBurst),
+		discovered:    make(map[string]string),
+		handlerMutex:  &sync.Mutex{},
+	}
+
+	n.server.SetRelay(n)
+
+	return n
+}
+
+func (n *Node) ID() string {
+	return n.id
+}
+
+// Serve - обслуживание локального клиента и соседей до отмены контекста
+func (n *Node) Serve(ctx context.Context, lis net.Listener) error {
+	publicMethods := []string{
+		gen.Server_Register_FullMethodName,
+		gen.Server_Login_FullMethodName,
+		gen.Peer_Hello_FullMethodName,
+		gen.Peer_Gossip_FullMethodName,
+		gen.DHT_FindNode_FullMethodName,
+		gen.DHT_FindProviders_FullMethodName,
+		gen.DHT_AddProvider_FullMethodName,
+	}
+
+	grpcServer := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(auth.UnaryServerInterceptor(n.auth, publicMethods...)),
+		grpc.ChainStreamInterceptor(auth.StreamServerInterceptor(n.auth, publicMethods...)),
+	)
+	gen.RegisterServerServer(grpcServer, n.server)
+	gen.RegisterPeerServer(grpcServer, n)
+	gen.RegisterDHTServer(grpcServer, n.dht)
+
+	go n.dht.Run(ctx)
+
+	go func() {
+		<-ctx.Done()
+		grpcServer.GracefulStop()
+
+		n.peersMutex.Lock()
+		defer n.peersMutex.Unlock()
+
+		for addr, p := range n.peers {
+			p.close()
+			delete(n.peers, addr)
+		}
+	}()
+
+	return grpcServer.Serve(lis)
+}
+
+// Connect - подключение к соседу по адресу, соседи обмениваются известными адресами
+// и подключаются к ним, поэтому для входа в сеть достаточно одного адреса
+func (n *Node) Connect(ctx context.Context, addr string) error {
+	if addr == n.advertise {
+		return ErrSelfConnect
+	}
+
+	p, err := n.peer(addr)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, helloTimeout)
+	defer cancel()
+
+	ts := time.Now()
+	req := &gen.HelloRequest{
+		NodeId:    n.id,
+		Addr:      n.advertise,
+		Peers:     n.Peers(),
+		PublicKey: n.privateKey.Public().(ed25519.PublicKey),
+		Ts:        timestamppb.New(ts),
+		Signature: identity.SignHello(n.privateKey, n.advertise, ts, nil),
+	}
+
+	res, err := p.client.Hello(ctx, req)
+	if err != nil {
+		n.removePeer(addr)
+
+		return err
+	}
+
+	// Идентификатор соседа - отпечаток ключа, которым подписан ответ на этот запрос
+	if !identity.VerifyHello(res.GetPublicKey(), "", res.GetTs().AsTime(), req.GetSignature(), res.GetSignature()) {
+		n.removePeer(addr)
+
+		return errInvalidHello
+	}
+
+	id := identity.Fingerprint(res.GetPublicKey())
+
+	if id == n.id {
+		n.removePeer(addr)
+
+		return ErrSelfConnect
+	}
+
+	// Узел мог быть уже подключен по другому адресу, например найден в локальной сети
+	if n.hasNode(id, addr) {
+		n.removePeer(addr)
+
+		return nil
+	}
+
+	p.setID(id)
+
+	n.logger.Info("peer connected", "peer", addr, "node", id)
+
+	n.notifyPeers()
+	n.discover(res.GetPeers())
+
+	return nil
+}
+
+// Bootstrap - вход в сеть через известный адрес: заполнение таблицы DHT и подключение к соседу
+func (n *Node) Bootstrap(ctx context.Context, addr string) error {
+	err := n.dht.Bootstrap(ctx, addr)
+	if err != nil {
+		return err
+	}
+
+	return n.Connect(ctx, addr)
+}
+
+// Join - объявление подписки на канал в DHT и подключение к другим подписчикам канала
+func (n *Node) Join(ctx context.Context, channel string) error {
+	n.joinedMutex.Lock()
+	n.joined[channel] = struct{}{}
+	n.joinedMutex.Unlock()
+
+	key := dht.ChannelKey(channel)
+
+	err := n.dht.Announce(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	for _, c := range n.dht.Providers(ctx, key) {
+		if n.hasNode(identity.Fingerprint(c.PublicKey), "") {
+			continue
+		}
+
+		err := n.Connect(ctx, c.Addr)
+		if err != nil && !errors.Is(err, ErrSelfConnect) {
+			n.logger.Warn("connect channel member", "chan", channel, "peer", c.Addr, "error", err)
+		}
+	}
+
+	return nil
+}
+
+// Leave - прекращение объявления подписки на канал
+func (n *Node) Leave(channel string) {
+	n.joinedMutex.Lock()
+	delete(n.joined, channel)
+	n.joinedMutex.Unlock()
+
+	n.dht.Withdraw(dht.ChannelKey(channel))
+}
+
+func (n *Node) isJoined(channel string) bool {
+	n.joinedMutex.RLock()
+	defer n.joinedMutex.RUnlock()
+
+	_, ok := n.joined[channel]
+
+	return ok
+}
+
+// Peers - адреса подключенных соседей
+func (n *Node) Peers() []string {
+	n.peersMutex.RLock()
+	defer n.peersMutex.RUnlock()
+
+	result := make([]string, 0, len(n.peers))
+
+	for addr := range n.peers {
+		result = append(result, addr)
+	}
+
+	return result
+}
+
+// Discovered - узел найден в локальной сети, подключение выполняется по запросу пользователя
+func (n *Node) Discovered(id, addr string) {
+	if id == n.id {
+		return
+	}
+
+	n.peersMutex.Lock()
+	changed := n.discovered[id] != addr
+	n.discovered[id] = addr
+	n.peersMutex.Unlock()
+
+	if changed {
+		n.logger.Debug("peer discovered", "peer", addr, "node", id)
+		n.notifyPeers()
+	}
+}
+
+// PeerList - подключенные и найденные соседи
+func (n *Node) PeerList() []entities.Peer {
+	n.peersMutex.RLock()
+	defer n.peersMutex.RUnlock()
+
+	result := make([]entities.Peer, 0, len(n.peers)+len(n.discovered))
+	connected := make(map[string]struct{}, len(n.peers))
+
+	for addr, p := range n.peers {
+		id := p.nodeID()
+		if id == "" {
+			continue
+		}
+
+		connected[id] = struct{}{}
+
+		result = append(result, entities.Peer{ID: id, Addr: addr, Connected: true})
+	}
+
+	for id, addr := range n.discovered {
+		if _, ok := connected[id]; !ok {
+			result = append(result, entities.Peer{ID: id, Addr: addr})
+		}
+	}
+
+	slices.SortFunc(result, func(a, b entities.Peer) int {
+		return strings.Compare(a.ID, b.ID)
+	})
+
+	return result
+}
+
+// SetPeersHandler - обработчик изменения списка соседей, вызывается с актуальным списком
+func (n *Node) SetPeersHandler(handler func([]entities.Peer)) {
+	n.handlerMutex.Lock()
+	n.peersHandler = handler
+	n.handlerMutex.Unlock()
+
+	n.notifyPeers()
+}
+
+func (n *Node) notifyPeers() {
+	n.handlerMutex.Lock()
+	defer n.handlerMutex.Unlock()
+
+	if n.peersHandler != nil {
+		n.peersHandler(n.PeerList())
+	}
+}
+
+// Hello - знакомство с соседом, подписанным ключом узла с идентификатором из запроса
+func (n *Node) Hello(ctx context.Context, req *gen.HelloRequest) (*gen.HelloResponse, error) {
+	ts := req.GetTs().AsTime()
+	if time.Since(ts).Abs() > helloMaxSkew {
+		return nil, status.Error(codes.InvalidArgument, "hello time too far from node time")
+	}
+
+	if !identity.VerifyHello(req.GetPublicKey(), req.GetAddr(), ts, nil, req.GetSignature()) {
+		return nil, status.Error(codes.Unauthenticated, "invalid hello signature")
+	}
+
+	id := identity.Fingerprint(req.GetPublicKey())
+	if req.GetNodeId() != id {
+		return nil, status.Error(codes.InvalidArgument, "node id does not match the key")
+	}
+
+	if id == n.id {
+		return n.helloResponse(req, nil), nil
+	}
+
+	// Обратное подключение, чтобы сообщения шли в обе стороны
+	if req.GetAddr() != "" && (n.hasPeer(req.GetAddr()) || n.peerCount() < maxPeers) {
+		p, err := n.peer(req.GetAddr())
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, "invalid peer address")
+		}
+
+		p.setID(id)
+		n.notifyPeers()
+	}
+
+	n.discover(req.GetPeers())
+
+	return n.helloResponse(req, n.Peers()), nil
+}
+
+// helloResponse - ответ подписывается вместе с подписью запроса, поэтому его нельзя повторить другому узлу
+func (n *Node) helloResponse(req *gen.HelloRequest, peers []string) *gen.HelloResponse {
+	ts := time.Now()
+
+	return &gen.HelloResponse{
+		NodeId:    n.id,
+		Peers:     peers,
+		PublicKey: n.privateKey.Public().(ed25519.PublicKey),
+		Ts:        timestamppb.New(ts),
+		Signature: identity.SignHello(n.privateKey, "", ts, req.GetSignature()),
+	}
+}
+
+func (n *Node) Gossip(ctx context.Context, req *gen.GossipRequest) (*gen.GossipResponse, error) {
+	if req.GetMessage().GetDeleted() || req.GetMessage().Edited != nil {
+		return n.gossipChange(ctx, req)
+	}
+
+	msg, err := gossipToMessage(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if !n.isJoined(msg.Chat) {
+		return n.relayOnly(req, msg.ID), nil
+	}
+
+	err = n.checkAuthor(ctx, msg.User, msg.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	added, err := n.server.Deliver(ctx, msg)
+	if err != nil {
+		n.logger.Error("deliver gossip", "chan", msg.Chat, "error", err)
+		return nil, status.Error(codes.Internal, "deliver")
+	}
+
+	if !added {
+		return &gen.GossipResponse{Known: true}, nil
+	}
+
+	n.forward(req)
+
+	return &gen.GossipResponse{}, nil
+}
+
+// gossipChange - изменение или удаление сообщения, примененное один раз пересылается дальше
+func (n *Node) gossipChange(ctx context.Context, req *gen.GossipRequest) (*gen.GossipResponse, error) {
+	msg, err := gossipToChange(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if !n.isJoined(msg.Chat) {
+		return n.relayOnly(req, msg.ID+"/"+strconv.FormatInt(msg.Edited.UnixNano(), 10)+"/"+strconv.FormatBool(msg.Deleted)), nil
+	}
+
+	err = n.checkAuthor(ctx, msg.User, msg.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	changed, err := n.server.DeliverChange(ctx, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	if !changed {
+		return &gen.GossipResponse{Known: true}, nil
+	}
+
+	n.forward(req)
+
+	return &gen.GossipResponse{}, nil
+}
+
+// relayOnly - сообщение канала, в котором нет локального клиента, не сохраняется,
+// иначе соседи могли бы занять память узла историями произвольных каналов,
+// но пересылается дальше один раз, чтобы не разрывать сеть
+func (n *Node) relayOnly(req *gen.GossipRequest, key string) *gen.GossipResponse {
+	if !n.relayed.add(req.GetChannel() + "/" + key) {
+		return &gen.GossipResponse{Known: true}
+	}
+
+	n.forward(req)
+
+	return &gen.GossipResponse{}
+}
+
+// forward - пересылка принятого сообщения остальным соседям пока не исчерпан ttl
+func (n *Node) forward(req *gen.GossipRequest) {
+	if req.GetTtl() <= 1 {
+		return
+	}
+
+	n.broadcast(&gen.GossipRequest{
+		Channel: req.GetChannel(),
+		Message: req.GetMessage(),
+		From:    n.advertise,
+		Ttl:     req.GetTtl() - 1,
+	}, req.GetFrom())
+}
+
+// checkAuthor - подпись проверена ключом из сообщения, поэтому ключ должен принадлежать логину автора:
+// логин равен отпечатку ключа, совпадает с ключом учетной записи этого узла или с ранее закрепленным ключом
+func (n *Node) checkAuthor(ctx context.Context, login string, publicKey []byte) error {
+	if login == identity.Fingerprint(publicKey) {
+		return nil
+	}
+
+	accountKey, err := n.auth.PublicKey(ctx, login)
+	switch {
+	case err == nil:
+		if !bytes.Equal(accountKey, publicKey) {
+			n.logger.Warn("author key differs from account key", "user", login, "key", identity.Fingerprint(publicKey))
+			return status.Error(codes.PermissionDenied, "public key does not belong to the login")
+		}
+
+		return nil
+	case !errors.Is(err, entities.ErrNotFound):
+		n.logger.Error("get public key", "user", login, "error", err)
+		return status.Error(codes.Internal, "get public key")
+	}
+
+	ok, err := n.authors.Pin(login, publicKey)
+	if err != nil {
+		n.logger.Error("pin author key", "user", login, "error", err)
+		return status.Error(codes.Internal, "pin author key")
+	}
+
+	// Другой ключ под известным логином - попытка выдать себя за автора или смена ключа, требующая внимания
+	if !ok {
+		n.logger.Warn("author key differs from pinned key", "user", login, "key", identity.Fingerprint(publicKey))
+		return status.Error(codes.PermissionDenied, "public key does not belong to the login")
+	}
+
+	return nil
+}
+
+// Relay - рассылка сообщения, отправленного локальным клиентом
+func (n *Node) Relay(msg entities.Message) {
+	n.broadcast(&gen.GossipRequest{
+		Channel: msg.Chat,
+		Message: &gen.ReadMessagesResponse{
+			Login:      msg.User,
+			Message:    msg.Text,
+			Ts:         timestamppb.New(msg.TS),
+			Id:         msg.ID,
+			Signature:  msg.Signature,
+			PublicKey:  msg.PublicKey,
+			Ciphertext: msg.Ciphertext,
+			KeyEpoch:   msg.KeyEpoch,
+			ReplyTo:    msg.ReplyTo,
+			Kind:       protoconv.KindToProto(msg.Kind),
+		},
+		From: n.advertise,
+		Ttl:  defaultTTL,
+	}, "")
+}
+
+// RelayChange - рассылка изменения или удаления, подписанного автором
+func (n *Node) RelayChange(msg entities.Message) {
+	n.broadcast(&gen.GossipRequest{
+		Channel: msg.Chat,
+		Message: &gen.ReadMessagesResponse{
+			Login:      msg.User,
+			Message:    msg.Text,
+			Id:         msg.ID,
+			Signature:  msg.Signature,
+			PublicKey:  msg.PublicKey,
+			Ciphertext: msg.Ciphertext,
+			KeyEpoch:   msg.KeyEpoch,
+			Edited:     timestamppb.New(msg.Edited),
+			Deleted:    msg.Deleted,
+			Kind:       protoconv.KindToProto(msg.Kind),
+		},
+		From: n.advertise,
+		Ttl:  defaultTTL,
+	}, "")
+}
+
+func (n *Node) broadcast(req *gen.GossipRequest, except string) {
+	n.peersMutex.RLock()
+	defer n.peersMutex.RUnlock()
+
+	for addr, p := range n.peers {
+		if addr != except {
+			p.send(req)
+		}
+	}
+}
+
+// discover - подключение к ранее неизвестным соседям, число адресов из одного списка,
+// частота подключений и общее число соседей ограничены
+func (n *Node) discover(addrs []string) {
+	if len(addrs) > maxDiscover {
+		addrs = addrs[:maxDiscover]
+	}
+
+	for _, addr := range addrs {
+		if addr == n.advertise || n.hasPeer(addr) {
+			continue
+		}
+
+		if n.peerCount() >= maxPeers || !n.discoverLimit.allow() {
+			n.logger.Debug("skip discovered peers", "peer", addr)
+
+			return
+		}
+
+		go func() {
+			err := n.Connect(context.Background(), addr)
+			if err != nil && !errors.Is(err, ErrSelfConnect) {
+				n.logger.Warn("connect discovered peer", "peer", addr, "error", err)
+			}
+		}()
+	}
+}
+
+func (n *Node) peerCount() int {
+	n.peersMutex.RLock()
+	defer n.peersMutex.RUnlock()
+
+	return len(n.peers)
+}
+
+func (n *Node) hasPeer(addr string) bool {
+	n.peersMutex.RLock()
+	defer n.peersMutex.RUnlock()
+
+	_, ok := n.peers[addr]
+
+	return ok
+}
+
+// hasNode - узел с идентификатором id подключен по адресу отличному от except
+func (n *Node) hasNode(id, except string) bool {
+	n.peersMutex.RLock()
+	defer n.peersMutex.RUnlock()
+
+	for addr, p := range n.peers {
+		if addr != except && p.nodeID() == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (n *Node) peer(addr string) (*peer, error) {
+	n.peersMutex.Lock()
+	defer n.peersMutex.Unlock()
+
+	if p, ok := n.peers[addr]; ok {
+		return p, nil
+	}
+
+	p, err := newPeer(addr, n.logger)
+	if err != nil {
+		return nil, err
+	}
+
+	n.peers[addr] = p
+
+	return p, nil
+}
+
+func (n *Node) removePeer(addr string) {
+	n.peersMutex.Lock()
+
+	p, ok := n.peers[addr]
+	if ok {
+		p.close()
+		delete(n.peers, addr)
+	}
+
+	n.peersMutex.Unlock()
+
+	if ok {
+		n.notifyPeers()
+	}
+}
+
+// gossipToMessage - сообщения от соседей принимаются только с верной подписью автора
+func gossipToMessage(req *gen.GossipRequest) (entities.Message, error) {
+	raw := req.GetMessage()
+
+	if req.GetChannel() == "" || raw.GetId() == "" || raw.GetLogin() == "" {
+		return entities.Message{}, status.Error(codes.InvalidArgument, "incomplete message")
+	}
+
+	if len(raw.GetPublicKey()) != ed25519.PublicKeySize {
+		return entities.Message{}, status.Error(codes.InvalidArgument, "unsigned message")
+	}
+
+	kind, ok := protoconv.KindFromProto(raw.GetKind())
+	if !ok {
+		return entities.Message{}, status.Error(codes.InvalidArgument, "unknown message kind")
+	}
+
+	signedText := identity.SignedText(raw.GetMessage(), raw.GetCiphertext())
+
+	if !identity.VerifyMessage(raw.GetPublicKey(), req.GetChannel(), signedText, kind, raw.GetTs().AsTime(), raw.GetId(), raw.GetSignature()) {
+		return entities.Message{}, status.Error(codes.PermissionDenied, "invalid signature")
+	}
+
+	return entities.Message{
+		ID:         raw.GetId(),
+		Chat:       req.GetChannel(),
+		User:       raw.GetLogin(),
+		Text:       raw.GetMessage(),
+		TS:         raw.GetTs().AsTime(),
+		Signature:  raw.GetSignature(),
+		PublicKey:  raw.GetPublicKey(),
+		Ciphertext: raw.GetCiphertext(),
+		KeyEpoch:   raw.GetKeyEpoch(),
+		ReplyTo:    raw.GetReplyTo(),
+		Kind:       kind,
+	}, nil
+}
+
+// gossipToChange - изменение принимается с подписью автора над новым текстом и временем изменения,
+// удаление - с подписью над удалением
+func gossipToChange(req *gen.GossipRequest) (entities.Message, error) {
+	raw := req.GetMessage()
+
+	if req.GetChannel() == "" || raw.GetId() == "" || raw.GetLogin() == "" || raw.Edited == nil {
+		return entities.Message{}, status.Error(codes.InvalidArgument, "incomplete change")
+	}
+
+	if len(raw.GetPublicKey()) != ed25519.PublicKeySize {
+		return entities.Message{}, status.Error(codes.InvalidArgument, "unsigned change")
+	}
+
+	edited := raw.GetEdited().AsTime()
+
+	kind, ok := protoconv.KindFromProto(raw.GetKind())
+	if !ok {
+		return entities.Message{}, status.Error(codes.InvalidArgument, "unknown message kind")
+	}
+
+	var valid bool
+
+	if raw.GetDeleted() {
+		valid = identity.VerifyDeletion(raw.GetPublicKey(), req.GetChannel(), raw.GetId(), edited, raw.GetSignature())
+	} else {
+		signedText := identity.SignedText(raw.GetMessage(), raw.GetCiphertext())
+		valid = identity.VerifyMessage(raw.GetPublicKey(), req.GetChannel(), signedText, kind, edited, raw.GetId(), raw.GetSignature())
+	}
+
+	if !valid {
+		return entities.Message{}, status.Error(codes.PermissionDenied, "invalid signature")
+	}
+
+	return entities.Message{
+		ID:         raw.GetId(),
+		Chat:       req.GetChannel(),
+		User:       raw.GetLogin(),
+		Text:       raw.GetMessage(),
+		Edited:     edited,
+		Deleted:    raw.GetDeleted(),
+		Signature:  raw.GetSignature(),
+		PublicKey:  raw.GetPublicKey(),
+		Ciphertext: raw.GetCiphertext(),
+		KeyEpoch:   raw.GetKeyEpoch(),
+		Kind:       kind,
+	}, nil
+}
@@ -0,0 +1,238 @@
+package p2p
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gbh007/p2p-chat/internal/entities"
+	"github.com/gbh007/p2p-chat/internal/identity"
+	"github.com/gbh007/p2p-chat/proto/gen"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func newKey(t *testing.T) ed25519.PrivateKey {
+	t.Helper()
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key
+}
+
+// startNode - узел на свободном порту localhost
+func startNode(t *testing.T) *Node {
+	t.Helper()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	n := New(newKey(t), lis.Addr().String(), nil)
+
+	go func() {
+		_ = n.Serve(ctx, lis)
+	}()
+
+	return n
+}
+
+func hello(key ed25519.PrivateKey, addr string, ts time.Time) *gen.HelloRequest {
+	return &gen.HelloRequest{
+		NodeId:    identity.Fingerprint(key.Public().(ed25519.PublicKey)),
+		Addr:      addr,
+		PublicKey: key.Public().(ed25519.PublicKey),
+		Ts:        timestamppb.New(ts),
+		Signature: identity.SignHello(key, addr, ts, nil),
+	}
+}
+
+func TestHelloRequiresSignature(t *testing.T) {
+	ctx := context.Background()
+	n := New(newKey(t), "127.0.0.1:9000", nil)
+	key := newKey(t)
+
+	spoofed := hello(key, "127.0.0.1:9001", time.Now())
+	spoofed.NodeId = n.id
+
+	forged := hello(key, "127.0.0.1:9001", time.Now())
+	forged.Addr = "127.0.0.1:9666"
+
+	stale := hello(key, "127.0.0.1:9001", time.Now().Add(-2*helloMaxSkew))
+
+	unsigned := hello(key, "127.0.0.1:9001", time.Now())
+	unsigned.Signature = nil
+
+	for name, req := range map[string]*gen.HelloRequest{"spoofed": spoofed, "forged": forged, "stale": stale, "unsigned": unsigned} {
+		_, err := n.Hello(ctx, req)
+		if status.Code(err) == codes.OK {
+			t.Fatalf("%s hello must be rejected", name)
+		}
+	}
+
+	if n.peerCount() != 0 {
+		t.Fatal("rejected hello must not add peers")
+	}
+
+	req := hello(key, "127.0.0.1:9001", time.Now())
+
+	res, err := n.Hello(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !identity.VerifyHello(res.GetPublicKey(), "", res.GetTs().AsTime(), req.GetSignature(), res.GetSignature()) {
+		t.Fatal("hello response must be signed over the request")
+	}
+
+	peers := n.PeerList()
+	if len(peers) != 1 || peers[0].ID != req.GetNodeId() || peers[0].Addr != req.GetAddr() {
+		t.Fatalf("unexpected peers: %+v", peers)
+	}
+}
+
+func TestConnect(t *testing.T) {
+	a, b := startNode(t), startNode(t)
+
+	err := a.Connect(context.Background(), b.advertise)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	peers := a.PeerList()
+	if len(peers) != 1 || peers[0].ID != b.id {
+		t.Fatalf("unexpected peers of a: %+v", peers)
+	}
+
+	peers = b.PeerList()
+	if len(peers) != 1 || peers[0].ID != a.id {
+		t.Fatalf("unexpected peers of b: %+v", peers)
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	l := newLimiter(time.Hour, 2)
+
+	if !l.allow() || !l.allow() {
+		t.Fatal("burst must be allowed")
+	}
+
+	if l.allow() {
+		t.Fatal("action over the burst must be limited")
+	}
+
+	l.last = l.last.Add(-time.Hour)
+
+	if !l.allow() || l.allow() {
+		t.Fatal("one action must be restored per interval")
+	}
+}
+
+func TestGossipStoresOnlyJoinedChannels(t *testing.T) {
+	ctx := context.Background()
+	n := New(newKey(t), "127.0.0.1:9000", nil)
+	key := newKey(t)
+	publicKey := key.Public().(ed25519.PublicKey)
+
+	gossip := func(channel, id string) *gen.GossipResponse {
+		t.Helper()
+
+		ts := time.Now()
+
+		res, err := n.Gossip(ctx, &gen.GossipRequest{
+			Channel: channel,
+			Message: &gen.ReadMessagesResponse{
+				Login:     identity.Fingerprint(publicKey),
+				Message:   "hello",
+				Ts:        timestamppb.New(ts),
+				Id:        id,
+				PublicKey: publicKey,
+				Signature: identity.SignMessage(key, channel, "hello", entities.MessageText, ts, id),
+			},
+			Ttl: defaultTTL,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return res
+	}
+
+	// Сообщение чужого канала пересылается один раз и не сохраняется
+	if gossip("other", "m1").GetKnown() || !gossip("other", "m1").GetKnown() {
+		t.Fatal("message of a foreign channel must be relayed once")
+	}
+
+	err := n.Join(ctx, "room")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gossip("room", "m2")
+
+	for channel, count := range map[string]int{"other": 0, "room": 1} {
+		messages, err := n.store.MessagesAfter(ctx, channel, 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(messages) != count {
+			t.Fatalf("unexpected messages of %s: %+v", channel, messages)
+		}
+	}
+}
+
+func TestCheckAuthorPinsKeys(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	keystore, err := identity.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := newKey(t).Public().(ed25519.PublicKey)
+	second := newKey(t).Public().(ed25519.PublicKey)
+
+	n := New(newKey(t), "127.0.0.1:9000", keystore)
+
+	err = n.checkAuthor(ctx, "bob", first)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Логин равный отпечатку ключа не закрепляется
+	err = n.checkAuthor(ctx, identity.Fingerprint(second), second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Закрепленный ключ переживает перезапуск узла
+	reopened, err := identity.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n = New(newKey(t), "127.0.0.1:9000", reopened)
+
+	err = n.checkAuthor(ctx, "bob", second)
+	if status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("other key of pinned login must be rejected, got %v", err)
+	}
+
+	err = n.checkAuthor(ctx, "bob", first)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
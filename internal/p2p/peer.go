@@ -0,0 +1,103 @@
+package p2p
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/gbh007/p2p-chat/proto/gen"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+const (
+	peerQueueSize = 256
+	gossipTimeout = 5 * time.Second
+)
+
+// peer - соединение с соседом, сообщения отправляются из отдельной горутины
+// чтобы медленный сосед не задерживал остальных
+type peer struct {
+	addr   string
+	conn   *grpc.ClientConn
+	client gen.PeerClient
+	logger *slog.Logger
+
+	id      string
+	idMutex *sync.Mutex
+
+	queue chan *gen.GossipRequest
+	done  chan struct{}
+	once  *sync.Once
+}
+
+func newPeer(addr string, logger *slog.Logger) (*peer, error) {
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, err
+	}
+
+	p := &peer{
+		addr:    addr,
+		conn:    conn,
+		client:  gen.NewPeerClient(conn),
+		logger:  logger,
+		idMutex: &sync.Mutex{},
+		queue:   make(chan *gen.GossipRequest, peerQueueSize),
+		done:    make(chan struct{}),
+		once:    &sync.Once{},
+	}
+
+	go p.run()
+
+	return p, nil
+}
+
+func (p *peer) setID(id string) {
+	p.idMutex.Lock()
+	defer p.idMutex.Unlock()
+
+	p.id = id
+}
+
+func (p *peer) nodeID() string {
+	p.idMutex.Lock()
+	defer p.idMutex.Unlock()
+
+	return p.id
+}
+
+// send - неблокирующая постановка в очередь, при переполнении сообщение отбрасывается,
+// сосед может получить его от других узлов
+func (p *peer) send(req *gen.GossipRequest) {
+	select {
+	case p.queue <- req:
+	default:
+		p.logger.Warn("peer queue overflow", "peer", p.addr)
+	}
+}
+
+func (p *peer) run() {
+	for {
+		select {
+		case <-p.done:
+			return
+		case req := <-p.queue:
+			ctx, cancel := context.WithTimeout(context.Background(), gossipTimeout)
+			_, err := p.client.Gossip(ctx, req)
+			cancel()
+
+			if err != nil {
+				p.logger.Warn("gossip", "peer", p.addr, "error", err)
+			}
+		}
+	}
+}
+
+func (p *peer) close() {
+	p.once.Do(func() {
+		close(p.done)
+		_ = p.conn.Close()
+	})
+}
@@ -0,0 +1,37 @@
+package p2p
+
+import "sync"
+
+// recent - множество последних ключей фиксированного размера, старые ключи вытесняются
+type recent struct {
+	keys  map[string]struct{}
+	ring  []string
+	next  int
+	mutex *sync.Mutex
+}
+
+func newRecent(size int) *recent {
+	return &recent{
+		keys:  make(map[string]struct{}, size),
+		ring:  make([]string, size),
+		mutex: &sync.Mutex{},
+	}
+}
+
+// add - добавление ключа, false если ключ уже был
+func (r *recent) add(key string) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, ok := r.keys[key]; ok {
+		return false
+	}
+
+	delete(r.keys, r.ring[r.next])
+
+	r.ring[r.next] = key
+	r.keys[key] = struct{}{}
+	r.next = (r.next + 1) % len(r.ring)
+
+	return true
+}
@@ -0,0 +1,31 @@
+package protoconv
+
+import (
+	"github.com/gbh007/p2p-chat/internal/entities"
+	"github.com/gbh007/p2p-chat/proto/gen"
+)
+
+func KindToProto(kind entities.MessageKind) gen.MessageKind {
+	switch kind {
+	case entities.MessageAction:
+		return gen.MessageKind_MESSAGE_ACTION
+	case entities.MessageTopic:
+		return gen.MessageKind_MESSAGE_TOPIC
+	default:
+		return gen.MessageKind_MESSAGE_TEXT
+	}
+}
+
+// KindFromProto - вид сообщения, false для неизвестного вида: подпись сделана над ним и не совпадет с заменой
+func KindFromProto(kind gen.MessageKind) (entities.MessageKind, bool) {
+	switch kind {
+	case gen.MessageKind_MESSAGE_TEXT:
+		return entities.MessageText, true
+	case gen.MessageKind_MESSAGE_ACTION:
+		return entities.MessageAction, true
+	case gen.MessageKind_MESSAGE_TOPIC:
+		return entities.MessageTopic, true
+	default:
+		return entities.MessageText, false
+	}
+}
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+
+	"github.com/gbh007/p2p-chat/internal/entities"
+	"github.com/gbh007/p2p-chat/proto/gen"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var errDirectForbidden = status.Error(codes.PermissionDenied, "direct messages are available only to their participants")
+
+// inbox - ящик личных сообщений пользователя, в нем хранятся и входящие, и отправленные сообщения
+func inbox(login string) string {
+	return entities.DirectChannel(login)
+}
+
+func isDirect(chat string) bool {
+	_, ok := entities.DirectPeer(chat)
+
+	return ok
+}
+
+// readChat - канал из которого пользователь читает сообщения, личная переписка читается из его ящика
+func (s *Server) readChat(login, channel string) string {
+	if isDirect(channel) {
+		return inbox(login)
+	}
+
+	return s.localChannel(channel)
+}
+
+// subscribeChat - подписаться на личные сообщения можно только через собственный ящик
+func (s *Server) subscribeChat(login, channel string) (string, error) {
+	peer, ok := entities.DirectPeer(channel)
+	if ok && peer != "" {
+		return "", status.Error(codes.InvalidArgument, "direct messages are delivered through the inbox channel")
+	}
+
+	return s.readChat(login, channel), nil
+}
+
+// checkDirectPeer - получатель личного сообщения должен быть пользователем этого сервера
+func (s *Server) checkDirectPeer(ctx context.Context, peer string) error {
+	if peer == "" {
+		return status.Error(codes.InvalidArgument, "empty recipient")
+	}
+
+	if strings.Contains(peer, "@") {
+		return status.Error(codes.Unimplemented, "direct messages are not federated")
+	}
+
+	_, err := s.accounts.User(ctx, peer)
+	if errors.Is(err, entities.ErrNotFound) {
+		return status.Error(codes.NotFound, "user not found")
+	}
+
+	if err != nil {
+		s.logger.Error("get user", "user", peer, "error", err)
+		return status.Error(codes.Internal, "get user")
+	}
+
+	return nil
+}
+
+// sendDirect - личное сообщение сохраняется в ящики отправителя и получателя,
+// получатель не в сети прочитает его из своего ящика после подключения
+func (s *Server) sendDirect(ctx context.Context, msg entities.Message) (*gen.SendMessageResponse, error) {
+	if len(msg.Ciphertext) > 0 {
+		return nil, status.Error(codes.InvalidArgument, "direct messages are not encrypted")
+	}
+
+	s.sendMutex.Lock()
+	defer s.sendMutex.Unlock()
+
+	own := msg
+	own.Chat = inbox(msg.User)
+
+	existing, err := s.store.MessageByID(ctx, own.Chat, own.ID)
+	switch {
+	case err == nil:
+		return &gen.SendMessageResponse{
+			Ts:  timestamppb.New(existing.TS),
+			Id:  existing.ID,
+			Seq: existing.Seq,
+		}, nil
+	case !errors.Is(err, entities.ErrNotFound):
+		s.logger.Error("find message", "chan", own.Chat, "error", err)
+		return nil, status.Error(codes.Internal, "find message")
+	}
+
+	// Сначала ящик получателя, иначе при ошибке повтор отправки будет считаться уже выполненным
+	if msg.To != msg.User {
+		received := msg
+		received.Chat = inbox(msg.To)
+
+		_, err = s.store.MessageByID(ctx, received.Chat, received.ID)
+		switch {
+		case errors.Is(err, entities.ErrNotFound):
+			_, err = s.publish(ctx, received)
+			if err != nil {
+				return nil, err
+			}
+		case err != nil:
+			s.logger.Error("find message", "chan", received.Chat, "error", err)
+			return nil, status.Error(codes.Internal, "find message")
+		}
+	}
+
+	own, err = s.publish(ctx, own)
+	if err != nil {
+		return nil, err
+	}
+
+	return &gen.SendMessageResponse{
+		Ts:  timestamppb.New(own.TS),
+		Id:  own.ID,
+		Seq: own.Seq,
+	}, nil
+}
+
+// directHistory - страница переписки с собеседником из ящика пользователя, номера сообщений - номера ящика
+func (s *Server) directHistory(ctx context.Context, login, peer string, before uint64, limit int) ([]entities.Message, error) {
+	chat := inbox(login)
+	result := make([]entities.Message, 0, limit)
+
+	for len(result) < limit {
+		messages, err := s.store.MessagesBefore(ctx, chat, before, limit)
+		if err != nil {
+			return nil, err
+		}
+
+		for i := len(messages) - 1; i >= 0 && len(result) < limit; i-- {
+			if directPeer(login, messages[i]) == peer {
+				result = append(result, messages[i])
+			}
+		}
+
+		if len(messages) < limit {
+			break
+		}
+
+		before = messages[0].Seq
+	}
+
+	// Сообщения собраны от новых к старым
+	slices.Reverse(result)
+
+	return result, nil
+}
+
+// directPeer - собеседник пользователя в личном сообщении
+func directPeer(login string, msg entities.Message) string {
+	if msg.User == login {
+		return msg.To
+	}
+
+	return msg.User
+}
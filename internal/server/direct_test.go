@@ -0,0 +1,124 @@
+package server_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gbh007/p2p-chat/internal/entities"
+	"github.com/gbh007/p2p-chat/proto/gen"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestDirectHistoryIsolation(t *testing.T) {
+	ts := newServer(t)
+
+	for _, login := range []string{"alice", "bob", "carol"} {
+		ts.register(t, login, nil)
+	}
+
+	toBob := ts.send(t, "alice", entities.DirectChannel("bob"), "hi bob")
+	toCarol := ts.send(t, "alice", entities.DirectChannel("carol"), "hi carol")
+
+	history := func(login, channel string) []string {
+		t.Helper()
+
+		res, err := ts.GetHistory(as(login), &gen.GetHistoryRequest{Channel: channel})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ids := make([]string, 0, len(res.GetMessages()))
+		for _, msg := range res.GetMessages() {
+			ids = append(ids, msg.GetId())
+		}
+
+		return ids
+	}
+
+	tests := []struct {
+		login   string
+		channel string
+		ids     []string
+	}{
+		{login: "alice", channel: entities.DirectChannel("bob"), ids: []string{toBob}},
+		{login: "alice", channel: entities.DirectChannel("carol"), ids: []string{toCarol}},
+		{login: "alice", channel: entities.DirectChannel(""), ids: []string{toBob, toCarol}},
+		{login: "bob", channel: entities.DirectChannel("alice"), ids: []string{toBob}},
+		{login: "bob", channel: entities.DirectChannel(""), ids: []string{toBob}},
+		// Чужая переписка не видна ни через имя собеседника, ни через ящик
+		{login: "bob", channel: entities.DirectChannel("carol"), ids: []string{}},
+		{login: "carol", channel: entities.DirectChannel("bob"), ids: []string{}},
+		{login: "carol", channel: entities.DirectChannel(""), ids: []string{toCarol}},
+	}
+
+	for _, tt := range tests {
+		ids := history(tt.login, tt.channel)
+		if len(ids) != len(tt.ids) {
+			t.Fatalf("history of %s for %s: %v", tt.channel, tt.login, ids)
+		}
+
+		for i := range ids {
+			if ids[i] != tt.ids[i] {
+				t.Fatalf("history of %s for %s: %v", tt.channel, tt.login, ids)
+			}
+		}
+	}
+}
+
+func TestDirectSubscribe(t *testing.T) {
+	ts := newServer(t)
+	ts.register(t, "alice", nil)
+	ts.register(t, "bob", nil)
+
+	sess := ts.session(t, ts.startGRPC(t), "bob")
+
+	// Подписка на переписку с собеседником открыла бы чужой ящик
+	sess.send(&gen.ClientFrame{RequestId: 1, Frame: &gen.ClientFrame_Subscribe{Subscribe: &gen.SubscribeFrame{Channel: entities.DirectChannel("alice")}}})
+
+	if frame := sess.recv(); frame.GetError().GetCode() != uint32(codes.InvalidArgument) {
+		t.Fatalf("unexpected frame: %v", frame)
+	}
+
+	sess.send(&gen.ClientFrame{RequestId: 2, Frame: &gen.ClientFrame_Subscribe{Subscribe: &gen.SubscribeFrame{Channel: entities.DirectChannel("")}}})
+
+	if frame := sess.recv(); frame.GetSubscribed() == nil {
+		t.Fatalf("unexpected frame: %v", frame)
+	}
+
+	ts.send(t, "alice", entities.DirectChannel("bob"), "hi bob")
+
+	for {
+		msg := sess.recv().GetMessage()
+		if msg == nil {
+			continue
+		}
+
+		if msg.GetMessage().GetMessage() != "hi bob" {
+			t.Fatalf("unexpected message: %v", msg)
+		}
+
+		break
+	}
+
+	// Другие серверы федерации не читают ящики пользователей
+	err := ts.Listen(context.Background(), entities.DirectChannel("bob"), "remote", 0, func(entities.Message) error { return nil })
+	if status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("listen of inbox must be forbidden, got %v", err)
+	}
+}
+
+func TestDirectRecipient(t *testing.T) {
+	ts := newServer(t)
+	ts.register(t, "alice", nil)
+
+	for channel, code := range map[string]codes.Code{
+		entities.DirectChannel("nobody"):     codes.NotFound,
+		entities.DirectChannel("bob@remote"): codes.Unimplemented,
+	} {
+		_, err := ts.SendMessage(as("alice"), &gen.SendMessageRequest{Channel: channel, Message: "hello"})
+		if status.Code(err) != code {
+			t.Fatalf("send to %s: expected %v, got %v", channel, code, err)
+		}
+	}
+}
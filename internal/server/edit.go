@@ -0,0 +1,351 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gbh007/p2p-chat/internal/entities"
+	"github.com/gbh007/p2p-chat/internal/identity"
+	"github.com/gbh007/p2p-chat/proto/gen"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// EditMessage - изменение текста сообщения, доступно только автору,
+// новый текст подписывается со временем изменения
+func (s *Server) EditMessage(ctx context.Context, req *gen.EditMessageRequest) (*gen.EditMessageResponse, error) {
+	login, err := loginFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	chat, err := s.changeChat(login, req.GetChannel())
+	if err != nil {
+		return nil, err
+	}
+
+	s.sendMutex.Lock()
+	defer s.sendMutex.Unlock()
+
+	msg, err := s.findMessage(ctx, chat, req.GetId())
+	if err != nil {
+		return nil, err
+	}
+
+	if msg.User != login || msg.Domain != "" {
+		return nil, status.Error(codes.PermissionDenied, "only the author can edit a message")
+	}
+
+	if msg.Deleted {
+		return nil, status.Error(codes.FailedPrecondition, "message is deleted")
+	}
+
+	edited := time.Now()
+
+	if req.Ts != nil {
+		ts := req.GetTs().AsTime()
+
+		if ts.Sub(edited).Abs() > maxClockSkew {
+			return nil, status.Error(codes.InvalidArgument, "message time too far from server time")
+		}
+
+		edited = ts
+	}
+
+	msg.Text = req.GetMessage()
+	msg.Ciphertext = req.GetCiphertext()
+	msg.KeyEpoch = req.GetKeyEpoch()
+	msg.Edited = edited
+
+	// Личное сообщение подписано с каналом получателя
+	channel := msg.Chat
+	if isDirect(chat) {
+		channel = entities.DirectChannel(msg.To)
+
+		if len(msg.Ciphertext) > 0 {
+			return nil, status.Error(codes.InvalidArgument, "direct messages are not encrypted")
+		}
+	} else {
+		err = s.checkEncryption(ctx, msg)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = s.checkSignature(ctx, login, channel, &msg, edited, req.GetSignature())
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.change(ctx, msg, applyEdit(msg))
+	if err != nil {
+		return nil, err
+	}
+
+	// Соседние узлы принимают только изменения, подписанные автором
+	if s.relay != nil && !isDirect(chat) && len(msg.Signature) > 0 {
+		s.relay.RelayChange(msg)
+	}
+
+	return &gen.EditMessageResponse{Edited: timestamppb.New(edited)}, nil
+}
+
+// DeleteMessage - удаление сообщения автором или модератором, в истории остается пустая запись с тем же номером
+func (s *Server) DeleteMessage(ctx context.Context, req *gen.DeleteMessageRequest) (*gen.DeleteMessageResponse, error) {
+	login, err := loginFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	chat, err := s.changeChat(login, req.GetChannel())
+	if err != nil {
+		return nil, err
+	}
+
+	s.sendMutex.Lock()
+	defer s.sendMutex.Unlock()
+
+	msg, err := s.findMessage(ctx, chat, req.GetId())
+	if err != nil {
+		return nil, err
+	}
+
+	// Модераторы не участвуют в личной переписке
+	author := msg.User == login && msg.Domain == ""
+	if !author && (isDirect(chat) || !s.isModerator(login)) {
+		return nil, status.Error(codes.PermissionDenied, "only the author or a moderator can delete a message")
+	}
+
+	if msg.Deleted {
+		return &gen.DeleteMessageResponse{}, nil
+	}
+
+	var (
+		deletion entities.Message
+		signed   bool
+	)
+
+	if author && !isDirect(chat) {
+		deletion, signed, err = s.checkDeletion(ctx, login, msg, req)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = s.change(ctx, msg, markDeleted)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.relay != nil && signed {
+		s.relay.RelayChange(deletion)
+	}
+
+	s.logger.Info("delete message", "chan", chat, "id", msg.ID, "user", login)
+
+	return &gen.DeleteMessageResponse{}, nil
+}
+
+// changeChat - канал в котором изменяется сообщение, личные сообщения ищутся в ящике пользователя
+func (s *Server) changeChat(login, channel string) (string, error) {
+	if channel == "" || channel == inbox("") {
+		return "", status.Error(codes.InvalidArgument, "empty channel")
+	}
+
+	chat := s.readChat(login, channel)
+
+	// Порядок изменений должен задавать сервер канала, как и для новых сообщений
+	if s.isRemote(chat) {
+		return "", status.Error(codes.Unimplemented, "messages of federated channels cannot be changed")
+	}
+
+	return chat, nil
+}
+
+func (s *Server) findMessage(ctx context.Context, chat, id string) (entities.Message, error) {
+	if id == "" {
+		return entities.Message{}, status.Error(codes.InvalidArgument, "empty message id")
+	}
+
+	msg, err := s.store.MessageByID(ctx, chat, id)
+	if errors.Is(err, entities.ErrNotFound) {
+		return entities.Message{}, status.Error(codes.NotFound, "message not found")
+	}
+
+	if err != nil {
+		s.logger.Error("find message", "chan", chat, "error", err)
+		return entities.Message{}, status.Error(codes.Internal, "find message")
+	}
+
+	return msg, nil
+}
+
+// checkDeletion - удаление подписанное автором для рассылки соседним узлам, false если подписи нет.
+// В возвращаемом сообщении Edited содержит время удаления, а подпись сделана над удалением
+func (s *Server) checkDeletion(
+	ctx context.Context,
+	login string,
+	msg entities.Message,
+	req *gen.DeleteMessageRequest,
+) (entities.Message, bool, error) {
+	if len(req.GetSignature()) == 0 || req.Ts == nil {
+		return entities.Message{}, false, nil
+	}
+
+	ts := req.GetTs().AsTime()
+
+	if time.Since(ts).Abs() > maxClockSkew {
+		return entities.Message{}, false, status.Error(codes.InvalidArgument, "message time too far from server time")
+	}
+
+	publicKey, err := s.accounts.PublicKey(ctx, login)
+	if err != nil && !errors.Is(err, entities.ErrNotFound) {
+		s.logger.Error("get public key", "user", login, "error", err)
+		return entities.Message{}, false, status.Error(codes.Internal, "get public key")
+	}
+
+	if len(publicKey) == 0 {
+		return entities.Message{}, false, nil
+	}
+
+	if !identity.VerifyDeletion(publicKey, msg.Chat, msg.ID, ts, req.GetSignature()) {
+		return entities.Message{}, false, status.Error(codes.PermissionDenied, "invalid signature")
+	}
+
+	return entities.Message{
+		ID:        msg.ID,
+		Chat:      msg.Chat,
+		User:      msg.User,
+		Deleted:   true,
+		Edited:    ts,
+		Signature: req.GetSignature(),
+		PublicKey: publicKey,
+	}, true, nil
+}
+
+// DeliverChange - изменение или удаление сообщения канала, пришедшее не от клиента этого сервера,
+// подпись автора уже проверена, false если изменение уже известно или сообщения нет
+func (s *Server) DeliverChange(ctx context.Context, msg entities.Message) (bool, error) {
+	if isDirect(msg.Chat) {
+		return false, errDirectForbidden
+	}
+
+	s.sendMutex.Lock()
+	defer s.sendMutex.Unlock()
+
+	stored, err := s.store.MessageByID(ctx, msg.Chat, msg.ID)
+	if errors.Is(err, entities.ErrNotFound) {
+		return false, nil
+	}
+
+	if err != nil {
+		s.logger.Error("find message", "chan", msg.Chat, "error", err)
+		return false, status.Error(codes.Internal, "find message")
+	}
+
+	// У удаленного сообщения ключ автора уже не хранится
+	if stored.Deleted {
+		return false, nil
+	}
+
+	// Изменение должно быть подписано тем же ключом что и само сообщение
+	if stored.User != msg.User || stored.Domain != "" || !bytes.Equal(stored.PublicKey, msg.PublicKey) {
+		return false, status.Error(codes.PermissionDenied, "only the author can change a message")
+	}
+
+	if !msg.Deleted && !msg.Edited.After(stored.Edited) {
+		return false, nil
+	}
+
+	// Подпись изменения сделана над видом сообщения, вид при изменении не меняется
+	if !msg.Deleted && msg.Kind != stored.Kind {
+		return false, status.Error(codes.InvalidArgument, "message kind cannot be changed")
+	}
+
+	apply := applyEdit(msg)
+	if msg.Deleted {
+		apply = markDeleted
+	}
+
+	err = s.change(ctx, stored, apply)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// applyEdit - перенос нового текста и подписи автора в сохраненное сообщение
+func applyEdit(edit entities.Message) func(stored *entities.Message) {
+	return func(stored *entities.Message) {
+		stored.Text = edit.Text
+		stored.Ciphertext = edit.Ciphertext
+		stored.KeyEpoch = edit.KeyEpoch
+		stored.Edited = edit.Edited
+		stored.Signature = edit.Signature
+		stored.PublicKey = edit.PublicKey
+	}
+}
+
+// markDeleted - у удаленного сообщения остаются номер, автор и время отправки
+func markDeleted(stored *entities.Message) {
+	stored.Deleted = true
+	stored.Text = ""
+	stored.Ciphertext = nil
+	stored.KeyEpoch = 0
+	stored.Signature = nil
+	stored.PublicKey = nil
+	stored.Reactions = nil
+}
+
+// change - сохранение изменения и рассылка читателям, личное сообщение меняется в ящиках обоих участников,
+// вызывать только под sendMutex
+func (s *Server) change(ctx context.Context, msg entities.Message, apply func(stored *entities.Message)) error {
+	chats := []string{msg.Chat}
+
+	if isDirect(msg.Chat) {
+		chats = []string{inbox(msg.User)}
+
+		if msg.To != msg.User {
+			chats = append(chats, inbox(msg.To))
+		}
+	}
+
+	for _, chat := range chats {
+		stored, err := s.store.MessageByID(ctx, chat, msg.ID)
+		// Копия в ящике собеседника могла не сохраниться при сбое отправки
+		if errors.Is(err, entities.ErrNotFound) {
+			continue
+		}
+
+		if err != nil {
+			s.logger.Error("find message", "chan", chat, "error", err)
+			return status.Error(codes.Internal, "find message")
+		}
+
+		apply(&stored)
+
+		// Изменение получает номер канала, поэтому читатели догоняют его из истории как и новые сообщения
+		stored.ChangeSeq, err = s.nextSeq(ctx, chat)
+		if err != nil {
+			s.logger.Error("next seq", "chan", chat, "error", err)
+			return status.Error(codes.Internal, "update message")
+		}
+
+		err = s.commitChange(ctx, stored)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Server) isModerator(login string) bool {
+	_, ok := s.moderators[login]
+
+	return ok
+}
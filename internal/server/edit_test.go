@@ -0,0 +1,230 @@
+package server_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gbh007/p2p-chat/internal/entities"
+	"github.com/gbh007/p2p-chat/internal/identity"
+	"github.com/gbh007/p2p-chat/internal/ulid"
+	"github.com/gbh007/p2p-chat/proto/gen"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// newEditServer - alice пишет в канал room и личное сообщение bob, mod - модератор
+func newEditServer(t *testing.T) (ts *testServer, channelID, directID string) {
+	t.Helper()
+
+	ts = newServer(t)
+	ts.SetModerators([]string{"mod"})
+
+	for _, login := range []string{"alice", "bob", "mod"} {
+		ts.register(t, login, nil)
+	}
+
+	channelID = ts.send(t, "alice", "room", "hello")
+	directID = ts.send(t, "alice", entities.DirectChannel("bob"), "hi bob")
+
+	return ts, channelID, directID
+}
+
+func TestEditMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		login   string
+		channel string
+		direct  bool
+		deleted bool
+		code    codes.Code
+	}{
+		{name: "author", login: "alice", channel: "room", code: codes.OK},
+		{name: "other user", login: "bob", channel: "room", code: codes.PermissionDenied},
+		{name: "moderator", login: "mod", channel: "room", code: codes.PermissionDenied},
+		{name: "deleted", login: "alice", channel: "room", deleted: true, code: codes.FailedPrecondition},
+		{name: "direct author", login: "alice", channel: entities.DirectChannel("bob"), direct: true, code: codes.OK},
+		{name: "direct recipient", login: "bob", channel: entities.DirectChannel("alice"), direct: true, code: codes.PermissionDenied},
+		{name: "direct outsider", login: "mod", channel: entities.DirectChannel("alice"), direct: true, code: codes.NotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts, channelID, directID := newEditServer(t)
+
+			id := channelID
+			if tt.direct {
+				id = directID
+			}
+
+			if tt.deleted {
+				_, err := ts.DeleteMessage(as("alice"), &gen.DeleteMessageRequest{Channel: tt.channel, Id: id})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			_, err := ts.EditMessage(as(tt.login), &gen.EditMessageRequest{Channel: tt.channel, Id: id, Message: "edited"})
+			if status.Code(err) != tt.code {
+				t.Fatalf("expected %v, got %v", tt.code, err)
+			}
+
+			if tt.code != codes.OK {
+				return
+			}
+
+			// Личное сообщение меняется в ящиках обоих участников
+			chats := []string{"room"}
+			if tt.direct {
+				chats = []string{entities.DirectChannel("alice"), entities.DirectChannel("bob")}
+			}
+
+			for _, chat := range chats {
+				msg := ts.message(t, chat, id)
+				if msg.Text != "edited" || msg.Edited.IsZero() || msg.ChangeSeq == 0 {
+					t.Fatalf("message of %s is not edited: %+v", chat, msg)
+				}
+			}
+		})
+	}
+}
+
+func TestDeleteMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		login   string
+		channel string
+		direct  bool
+		code    codes.Code
+	}{
+		{name: "author", login: "alice", channel: "room", code: codes.OK},
+		{name: "other user", login: "bob", channel: "room", code: codes.PermissionDenied},
+		{name: "moderator", login: "mod", channel: "room", code: codes.OK},
+		{name: "direct author", login: "alice", channel: entities.DirectChannel("bob"), direct: true, code: codes.OK},
+		{name: "direct recipient", login: "bob", channel: entities.DirectChannel("alice"), direct: true, code: codes.PermissionDenied},
+		// Модератор не видит чужие ящики, поэтому сообщения для него нет
+		{name: "direct moderator", login: "mod", channel: entities.DirectChannel("alice"), direct: true, code: codes.NotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts, channelID, directID := newEditServer(t)
+
+			id := channelID
+			if tt.direct {
+				id = directID
+			}
+
+			_, err := ts.DeleteMessage(as(tt.login), &gen.DeleteMessageRequest{Channel: tt.channel, Id: id})
+			if status.Code(err) != tt.code {
+				t.Fatalf("expected %v, got %v", tt.code, err)
+			}
+
+			chats := []string{"room"}
+			if tt.direct {
+				chats = []string{entities.DirectChannel("alice"), entities.DirectChannel("bob")}
+			}
+
+			for _, chat := range chats {
+				msg := ts.message(t, chat, id)
+				if msg.Deleted != (tt.code == codes.OK) {
+					t.Fatalf("unexpected message of %s: %+v", chat, msg)
+				}
+
+				if msg.Deleted && msg.Text != "" {
+					t.Fatalf("deleted message keeps its text: %+v", msg)
+				}
+			}
+		})
+	}
+}
+
+func TestDeleteMessageModeratorInDirect(t *testing.T) {
+	ts, _, _ := newEditServer(t)
+
+	// Модератор - участник переписки, но удалить может только свое сообщение
+	id := ts.send(t, "alice", entities.DirectChannel("mod"), "hi mod")
+
+	_, err := ts.DeleteMessage(as("mod"), &gen.DeleteMessageRequest{Channel: entities.DirectChannel("alice"), Id: id})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("moderator must not delete direct messages of others, got %v", err)
+	}
+}
+
+func TestDeleteDeletedMessage(t *testing.T) {
+	ctx := context.Background()
+	ts, id, _ := newEditServer(t)
+
+	_, err := ts.DeleteMessage(as("alice"), &gen.DeleteMessageRequest{Channel: "room", Id: id})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	last, err := ts.LastSeq(ctx, "room")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Повторное удаление ничего не меняет и не получает новый номер
+	for _, login := range []string{"alice", "mod"} {
+		_, err = ts.DeleteMessage(as(login), &gen.DeleteMessageRequest{Channel: "room", Id: id})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	again, err := ts.LastSeq(ctx, "room")
+	if err != nil || again != last {
+		t.Fatalf("repeated delete changed the channel: %d -> %d %v", last, again, err)
+	}
+}
+
+func TestEditMessageSignature(t *testing.T) {
+	ts := newServer(t)
+	key := newKey(t)
+	ts.register(t, "alice", key)
+
+	sent := time.Now()
+	id := ulid.New()
+
+	_, err := ts.SendMessage(as("alice"), &gen.SendMessageRequest{
+		Channel:   "room",
+		Id:        id,
+		Message:   "hello",
+		Ts:        timestamppb.New(sent),
+		Signature: identity.SignMessage(key, "room", "hello", entities.MessageText, sent, id),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	edited := sent.Add(time.Second)
+	edit := func(signature []byte) error {
+		_, err := ts.EditMessage(as("alice"), &gen.EditMessageRequest{
+			Channel:   "room",
+			Id:        id,
+			Message:   "edited",
+			Ts:        timestamppb.New(edited),
+			Signature: signature,
+		})
+
+		return err
+	}
+
+	// Подпись старого текста или другого вида не подтверждает изменение
+	for name, signature := range map[string][]byte{
+		"unsigned": nil,
+		"old text": identity.SignMessage(key, "room", "hello", entities.MessageText, edited, id),
+		"kind":     identity.SignMessage(key, "room", "edited", entities.MessageTopic, edited, id),
+	} {
+		if status.Code(edit(signature)) != codes.PermissionDenied {
+			t.Fatalf("edit with %s signature must be rejected", name)
+		}
+	}
+
+	err = edit(identity.SignMessage(key, "room", "edited", entities.MessageText, edited, id))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
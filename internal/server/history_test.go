@@ -0,0 +1,55 @@
+package server_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/gbh007/p2p-chat/internal/auth"
+	"github.com/gbh007/p2p-chat/internal/entities"
+	"github.com/gbh007/p2p-chat/internal/server"
+	"github.com/gbh007/p2p-chat/internal/storage"
+	"github.com/gbh007/p2p-chat/proto/gen"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// failingStore - хранилище, чтение истории которого завершается ошибкой err
+type failingStore struct {
+	*storage.Memory
+	err error
+}
+
+func (s failingStore) MessagesBefore(context.Context, string, uint64, int) ([]entities.Message, error) {
+	return nil, s.err
+}
+
+func TestGetHistoryErrors(t *testing.T) {
+	tests := []struct {
+		err  error
+		code codes.Code
+	}{
+		{err: context.DeadlineExceeded, code: codes.DeadlineExceeded},
+		{err: context.Canceled, code: codes.Canceled},
+		{err: errors.New("disk failure"), code: codes.Internal},
+	}
+
+	for _, tt := range tests {
+		store := storage.NewMemory(10)
+		s := server.New(failingStore{Memory: store, err: tt.err}, store, auth.New(store), server.DefaultLimits())
+
+		for _, channel := range []string{"room", entities.DirectChannel("bob")} {
+			_, err := s.GetHistory(as("alice"), &gen.GetHistoryRequest{Channel: channel})
+
+			st, ok := status.FromError(err)
+			if !ok || st.Code() != tt.code {
+				t.Fatalf("history of %s with %v: expected %v, got %v", channel, tt.err, tt.code, err)
+			}
+
+			// Подробности ошибки хранилища остаются в логе сервера
+			if tt.code == codes.Internal && st.Message() != "history" {
+				t.Fatalf("store error is sent to client: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"slices"
+
+	"github.com/gbh007/p2p-chat/internal/entities"
+	"github.com/gbh007/p2p-chat/proto/gen"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (s *Server) PublishKey(ctx context.Context, req *gen.PublishKeyRequest) (*gen.PublishKeyResponse, error) {
+	login, err := loginFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.accounts.SetEncryptionKey(ctx, login, req.GetEncryptionKey(), req.GetSignature())
+	if err != nil {
+		return nil, s.accountError("publish key", login, err)
+	}
+
+	return &gen.PublishKeyResponse{}, nil
+}
+
+func (s *Server) GetKeys(ctx context.Context, req *gen.GetKeysRequest) (*gen.GetKeysResponse, error) {
+	res := &gen.GetKeysResponse{
+		Keys: make([]*gen.UserKeys, 0, len(req.GetLogins())),
+	}
+
+	for _, login := range req.GetLogins() {
+		user, err := s.accounts.User(ctx, login)
+		if errors.Is(err, entities.ErrNotFound) {
+			continue
+		}
+
+		if err != nil {
+			s.logger.Error("get user keys", "user", login, "error", err)
+			return nil, status.Error(codes.Internal, "get keys")
+		}
+
+		res.Keys = append(res.Keys, &gen.UserKeys{
+			Login:         user.Login,
+			PublicKey:     user.PublicKey,
+			EncryptionKey: user.EncryptionKey,
+			Signature:     user.EncryptionKeySignature,
+		})
+	}
+
+	return res, nil
+}
+
+// ShareChannelKey - новая эпоха ключа канала, зашифрованным можно сделать только канал без сообщений,
+// дальнейшая смена ключа и состава участников доступна только владельцу
+func (s *Server) ShareChannelKey(ctx context.Context, req *gen.ShareChannelKeyRequest) (*gen.ShareChannelKeyResponse, error) {
+	login, err := loginFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	chat := s.localChannel(req.GetChannel())
+
+	if chat == "" {
+		return nil, status.Error(codes.InvalidArgument, "empty channel")
+	}
+
+	if isDirect(chat) {
+		return nil, status.Error(codes.InvalidArgument, "direct messages are not encrypted")
+	}
+
+	if s.isRemote(chat) {
+		return nil, status.Error(codes.InvalidArgument, "encrypted channels are not federated")
+	}
+
+	s.sendMutex.Lock()
+	defer s.sendMutex.Unlock()
+
+	current, err := s.keys.ChannelEncryption(ctx, chat)
+	if err != nil {
+		s.logger.Error("get channel encryption", "chan", chat, "error", err)
+		return nil, status.Error(codes.Internal, "get channel encryption")
+	}
+
+	if current.Epoch > 0 && current.Owner != login {
+		return nil, status.Error(codes.PermissionDenied, "only channel owner can change keys")
+	}
+
+	// Открытый канал с историей принадлежит его участникам, перехватить его шифрованием нельзя
+	if current.Epoch == 0 {
+		last, err := s.lastSeq(ctx, chat)
+		if err != nil {
+			s.logger.Error("get last seq", "chan", chat, "error", err)
+			return nil, status.Error(codes.Internal, "get last seq")
+		}
+
+		if last > 0 {
+			return nil, status.Error(codes.FailedPrecondition, "only a new channel can be encrypted")
+		}
+	}
+
+	if req.GetEpoch() != current.Epoch+1 {
+		return nil, status.Errorf(codes.FailedPrecondition, "expected epoch %d", current.Epoch+1)
+	}
+
+	shares := make([]entities.ChannelKeyShare, 0, len(req.GetKeys()))
+	members := make([]string, 0, len(req.GetKeys()))
+
+	for _, key := range req.GetKeys() {
+		if slices.Contains(members, key.GetLogin()) {
+			return nil, status.Errorf(codes.InvalidArgument, "duplicate member %s", key.GetLogin())
+		}
+
+		members = append(members, key.GetLogin())
+		shares = append(shares, entities.ChannelKeyShare{
+			Chat:      chat,
+			Epoch:     req.GetEpoch(),
+			Owner:     login,
+			Login:     key.GetLogin(),
+			Key:       key.GetKey(),
+			Signature: key.GetSignature(),
+		})
+	}
+
+	if !slices.Contains(members, login) {
+		return nil, status.Error(codes.InvalidArgument, "owner must be a member")
+	}
+
+	err = s.keys.AddChannelKeys(ctx, shares)
+	if err != nil {
+		s.logger.Error("add channel keys", "chan", chat, "error", err)
+		return nil, status.Error(codes.Internal, "add channel keys")
+	}
+
+	s.logger.Info("channel key rotated", "chan", chat, "epoch", req.GetEpoch(), "members", len(members))
+
+	return &gen.ShareChannelKeyResponse{}, nil
+}
+
+func (s *Server) GetChannelKeys(ctx context.Context, req *gen.GetChannelKeysRequest) (*gen.GetChannelKeysResponse, error) {
+	login, err := loginFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	chat := s.localChannel(req.GetChannel())
+
+	current, err := s.keys.ChannelEncryption(ctx, chat)
+	if err != nil {
+		s.logger.Error("get channel encryption", "chan", chat, "error", err)
+		return nil, status.Error(codes.Internal, "get channel encryption")
+	}
+
+	if current.Epoch == 0 {
+		return &gen.GetChannelKeysResponse{}, nil
+	}
+
+	shares, err := s.keys.ChannelKeys(ctx, chat, login)
+	if err != nil {
+		s.logger.Error("get channel keys", "chan", chat, "error", err)
+		return nil, status.Error(codes.Internal, "get channel keys")
+	}
+
+	ownerKey, err := s.accounts.PublicKey(ctx, current.Owner)
+	if err != nil {
+		s.logger.Error("get owner key", "chan", chat, "error", err)
+		return nil, status.Error(codes.Internal, "get owner key")
+	}
+
+	res := &gen.GetChannelKeysResponse{
+		Owner:          current.Owner,
+		OwnerPublicKey: ownerKey,
+		Epoch:          current.Epoch,
+		Members:        current.Members,
+		Keys:           make([]*gen.ChannelKey, 0, len(shares)),
+	}
+
+	for _, share := range shares {
+		res.Keys = append(res.Keys, &gen.ChannelKey{
+			Epoch:     share.Epoch,
+			Key:       share.Key,
+			Signature: share.Signature,
+		})
+	}
+
+	return res, nil
+}
+
+// checkEncryption - в зашифрованный канал принимаются только шифротексты
+// текущей эпохи от ее участников, вызывать только под sendMutex
+func (s *Server) checkEncryption(ctx context.Context, msg entities.Message) error {
+	current, err := s.keys.ChannelEncryption(ctx, msg.Chat)
+	if err != nil {
+		s.logger.Error("get channel encryption", "chan", msg.Chat, "error", err)
+		return status.Error(codes.Internal, "get channel encryption")
+	}
+
+	if current.Epoch == 0 {
+		if len(msg.Ciphertext) > 0 {
+			return status.Error(codes.InvalidArgument, "channel is not encrypted")
+		}
+
+		return nil
+	}
+
+	if len(msg.Ciphertext) == 0 || msg.Text != "" {
+		return status.Error(codes.InvalidArgument, "channel is encrypted")
+	}
+
+	if !slices.Contains(current.Members, msg.User) {
+		return status.Error(codes.PermissionDenied, "not a channel member")
+	}
+
+	if msg.KeyEpoch != current.Epoch {
+		return status.Errorf(codes.FailedPrecondition, "stale key epoch, current %d", current.Epoch)
+	}
+
+	return nil
+}
@@ -0,0 +1,128 @@
+package server_test
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"testing"
+
+	"github.com/gbh007/p2p-chat/internal/e2e"
+	"github.com/gbh007/p2p-chat/internal/ulid"
+	"github.com/gbh007/p2p-chat/proto/gen"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestChannelKeyRotation(t *testing.T) {
+	ts := newServer(t)
+
+	private := make(map[string]*ecdh.PrivateKey)
+
+	for _, login := range []string{"alice", "bob", "eve"} {
+		ts.register(t, login, nil)
+
+		key, err := ecdh.X25519().GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		private[login] = key
+	}
+
+	groupKeys := make(map[uint32][]byte)
+
+	share := func(login string, epoch uint32, members ...string) error {
+		groupKey, err := e2e.NewGroupKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req := &gen.ShareChannelKeyRequest{Channel: "secret", Epoch: epoch}
+
+		for _, member := range members {
+			wrapped, err := e2e.WrapKey(groupKey, private[member].PublicKey(), "secret", epoch)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req.Keys = append(req.Keys, &gen.WrappedKey{Login: member, Key: wrapped})
+		}
+
+		_, err = ts.ShareChannelKey(as(login), req)
+		if err == nil {
+			groupKeys[epoch] = groupKey
+		}
+
+		return err
+	}
+
+	send := func(login string, epoch uint32) error {
+		id := ulid.New()
+
+		ciphertext, err := e2e.Encrypt(groupKeys[epoch], "secret", id, []byte("hello"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = ts.SendMessage(as(login), &gen.SendMessageRequest{Channel: "secret", Id: id, Ciphertext: ciphertext, KeyEpoch: epoch})
+
+		return err
+	}
+
+	expect := func(name string, err error, code codes.Code) {
+		t.Helper()
+
+		if status.Code(err) != code {
+			t.Fatalf("%s: expected %v, got %v", name, code, err)
+		}
+	}
+
+	expect("skipped epoch", share("alice", 2, "alice", "bob"), codes.FailedPrecondition)
+	expect("owner not member", share("alice", 1, "bob"), codes.InvalidArgument)
+	expect("first epoch", share("alice", 1, "alice", "bob"), codes.OK)
+	expect("not owner", share("bob", 2, "bob"), codes.PermissionDenied)
+	expect("member", send("bob", 1), codes.OK)
+	expect("outsider", send("eve", 1), codes.PermissionDenied)
+
+	_, err := ts.SendMessage(as("alice"), &gen.SendMessageRequest{Channel: "secret", Id: ulid.New(), Message: "plain"})
+	expect("plaintext", err, codes.InvalidArgument)
+
+	// Исключенный участник не получает ключ новой эпохи и не может писать
+	expect("rotation", share("alice", 2, "alice", "eve"), codes.OK)
+	expect("stale epoch", send("alice", 1), codes.FailedPrecondition)
+	expect("removed member", send("bob", 2), codes.PermissionDenied)
+	expect("new member", send("eve", 2), codes.OK)
+
+	for login, epochs := range map[string][]uint32{"alice": {1, 2}, "bob": {1}, "eve": {2}} {
+		res, err := ts.GetChannelKeys(as(login), &gen.GetChannelKeysRequest{Channel: "secret"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if res.GetEpoch() != 2 || res.GetOwner() != "alice" || len(res.GetKeys()) != len(epochs) {
+			t.Fatalf("unexpected keys of %s: %+v", login, res)
+		}
+
+		for i, key := range res.GetKeys() {
+			groupKey, err := e2e.UnwrapKey(key.GetKey(), private[login], "secret", key.GetEpoch())
+			if err != nil || key.GetEpoch() != epochs[i] || !bytes.Equal(groupKey, groupKeys[epochs[i]]) {
+				t.Fatalf("unexpected key of %s for epoch %d: %v", login, key.GetEpoch(), err)
+			}
+		}
+	}
+}
+
+func TestShareChannelKeyWithHistory(t *testing.T) {
+	ts := newServer(t)
+	ts.register(t, "alice", nil)
+	ts.send(t, "alice", "room", "hello")
+
+	_, err := ts.ShareChannelKey(as("alice"), &gen.ShareChannelKeyRequest{
+		Channel: "room",
+		Epoch:   1,
+		Keys:    []*gen.WrappedKey{{Login: "alice", Key: []byte("key")}},
+	})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("channel with history must not be encrypted, got %v", err)
+	}
+}
@@ -0,0 +1,184 @@
+package server
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/gbh007/p2p-chat/internal/entities"
+	"github.com/gbh007/p2p-chat/proto/gen"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// maxReactionLen - длина реакции в байтах, реакцией может быть эмодзи или короткая строка
+	maxReactionLen = 32
+	// maxReactions - количество разных реакций на одно сообщение
+	maxReactions = 20
+)
+
+func (s *Server) AddReaction(ctx context.Context, req *gen.AddReactionRequest) (*gen.AddReactionResponse, error) {
+	reactions, err := s.react(ctx, req.GetChannel(), req.GetId(), req.GetEmoji(), true)
+	if err != nil {
+		return nil, err
+	}
+
+	return &gen.AddReactionResponse{Reactions: reactionsToProto(reactions)}, nil
+}
+
+func (s *Server) RemoveReaction(ctx context.Context, req *gen.RemoveReactionRequest) (*gen.RemoveReactionResponse, error) {
+	reactions, err := s.react(ctx, req.GetChannel(), req.GetId(), req.GetEmoji(), false)
+	if err != nil {
+		return nil, err
+	}
+
+	return &gen.RemoveReactionResponse{Reactions: reactionsToProto(reactions)}, nil
+}
+
+// react - добавление или снятие реакции пользователя, повторное действие ничего не меняет
+func (s *Server) react(ctx context.Context, channel, id, emoji string, add bool) ([]entities.Reaction, error) {
+	login, err := loginFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkReaction(emoji)
+	if err != nil {
+		return nil, err
+	}
+
+	chat, err := s.reactChat(login, channel)
+	if err != nil {
+		return nil, err
+	}
+
+	s.sendMutex.Lock()
+	defer s.sendMutex.Unlock()
+
+	msg, err := s.findMessage(ctx, chat, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if msg.Deleted {
+		return nil, status.Error(codes.FailedPrecondition, "message is deleted")
+	}
+
+	var (
+		reactions []entities.Reaction
+		changed   bool
+	)
+
+	if add {
+		reactions, changed, err = addReaction(msg.Reactions, emoji, login)
+	} else {
+		reactions, changed = removeReaction(msg.Reactions, emoji, login)
+	}
+
+	if err != nil || !changed {
+		return msg.Reactions, err
+	}
+
+	err = s.change(ctx, msg, func(stored *entities.Message) {
+		stored.Reactions = reactions
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return reactions, nil
+}
+
+// reactChat - реакции не подписываются, поэтому другие серверы и узлы не могут их проверить:
+// в каналах других серверов и в p2p сети реакция осталась бы видна только на этом сервере
+func (s *Server) reactChat(login, channel string) (string, error) {
+	if s.isRemote(s.readChat(login, channel)) {
+		return "", status.Error(codes.Unimplemented, "reactions are not supported in federated channels")
+	}
+
+	chat, err := s.changeChat(login, channel)
+	if err != nil {
+		return "", err
+	}
+
+	// Личные сообщения в p2p режиме не рассылаются соседям, как и их изменения
+	if s.relay != nil && !isDirect(chat) {
+		return "", status.Error(codes.Unimplemented, "reactions are not supported in p2p channels")
+	}
+
+	return chat, nil
+}
+
+func checkReaction(emoji string) error {
+	if emoji == "" {
+		return status.Error(codes.InvalidArgument, "empty reaction")
+	}
+
+	if len(emoji) > maxReactionLen {
+		return status.Errorf(codes.InvalidArgument, "reaction exceeds %d bytes", maxReactionLen)
+	}
+
+	if strings.IndexFunc(emoji, unicode.IsSpace) >= 0 {
+		return status.Error(codes.InvalidArgument, "reaction must not contain spaces")
+	}
+
+	return nil
+}
+
+// addReaction - новый список реакций, исходный не изменяется так как может храниться в памяти
+func addReaction(reactions []entities.Reaction, emoji, login string) ([]entities.Reaction, bool, error) {
+	index := slices.IndexFunc(reactions, func(r entities.Reaction) bool { return r.Emoji == emoji })
+
+	if index < 0 {
+		if len(reactions) >= maxReactions {
+			return nil, false, status.Errorf(codes.FailedPrecondition, "message already has %d different reactions", maxReactions)
+		}
+
+		result := slices.Clone(reactions)
+
+		return append(result, entities.Reaction{Emoji: emoji, Users: []string{login}}), true, nil
+	}
+
+	if slices.Contains(reactions[index].Users, login) {
+		return reactions, false, nil
+	}
+
+	result := slices.Clone(reactions)
+	result[index].Users = append(slices.Clone(reactions[index].Users), login)
+
+	return result, true, nil
+}
+
+// removeReaction - новый список реакций без реакции пользователя, реакции без пользователей удаляются
+func removeReaction(reactions []entities.Reaction, emoji, login string) ([]entities.Reaction, bool) {
+	index := slices.IndexFunc(reactions, func(r entities.Reaction) bool { return r.Emoji == emoji })
+	if index < 0 || !slices.Contains(reactions[index].Users, login) {
+		return reactions, false
+	}
+
+	users := slices.DeleteFunc(slices.Clone(reactions[index].Users), func(user string) bool { return user == login })
+
+	if len(users) == 0 {
+		return slices.Delete(slices.Clone(reactions), index, index+1), true
+	}
+
+	result := slices.Clone(reactions)
+	result[index].Users = users
+
+	return result, true
+}
+
+func reactionsToProto(reactions []entities.Reaction) []*gen.Reaction {
+	result := make([]*gen.Reaction, 0, len(reactions))
+
+	for _, reaction := range reactions {
+		result = append(result, &gen.Reaction{
+			Emoji: reaction.Emoji,
+			Users: reaction.Users,
+		})
+	}
+
+	return result
+}
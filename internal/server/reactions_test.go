@@ -0,0 +1,82 @@
+package server_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gbh007/p2p-chat/internal/entities"
+	"github.com/gbh007/p2p-chat/proto/gen"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type relay struct{}
+
+func (relay) Relay(entities.Message)       {}
+func (relay) RelayChange(entities.Message) {}
+
+type federation struct{}
+
+func (federation) SendRemote(context.Context, entities.Message) (uint64, error) { return 0, nil }
+func (federation) Follow(string) error                                          { return nil }
+func (federation) Unfollow(string)                                              {}
+
+func TestAddReaction(t *testing.T) {
+	ts := newServer(t)
+	ts.register(t, "alice", nil)
+	ts.register(t, "bob", nil)
+
+	id := ts.send(t, "alice", "room", "hello")
+
+	for _, login := range []string{"alice", "bob", "bob"} {
+		_, err := ts.AddReaction(as(login), &gen.AddReactionRequest{Channel: "room", Id: id, Emoji: "+1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	res, err := ts.RemoveReaction(as("alice"), &gen.RemoveReactionRequest{Channel: "room", Id: id, Emoji: "+1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reactions := res.GetReactions()
+	if len(reactions) != 1 || len(reactions[0].GetUsers()) != 1 || reactions[0].GetUsers()[0] != "bob" {
+		t.Fatalf("unexpected reactions: %v", reactions)
+	}
+
+	for emoji, code := range map[string]codes.Code{"": codes.InvalidArgument, "a b": codes.InvalidArgument} {
+		_, err := ts.AddReaction(as("alice"), &gen.AddReactionRequest{Channel: "room", Id: id, Emoji: emoji})
+		if status.Code(err) != code {
+			t.Fatalf("reaction %q: expected %v, got %v", emoji, code, err)
+		}
+	}
+}
+
+func TestAddReactionNotRelayed(t *testing.T) {
+	ts := newServer(t)
+	ts.register(t, "alice", nil)
+	ts.register(t, "bob", nil)
+
+	id := ts.send(t, "alice", "room", "hello")
+	directID := ts.send(t, "alice", entities.DirectChannel("bob"), "hi bob")
+
+	ts.SetRelay(relay{})
+	ts.SetFederation("local", federation{})
+
+	// Реакция осталась бы только на этом узле, поэтому отклоняется явно
+	for channel, code := range map[string]codes.Code{
+		"room":        codes.Unimplemented,
+		"room@remote": codes.Unimplemented,
+	} {
+		_, err := ts.AddReaction(as("alice"), &gen.AddReactionRequest{Channel: channel, Id: id, Emoji: "+1"})
+		if status.Code(err) != code {
+			t.Fatalf("reaction in %s: expected %v, got %v", channel, code, err)
+		}
+	}
+
+	_, err := ts.AddReaction(as("bob"), &gen.AddReactionRequest{Channel: entities.DirectChannel("alice"), Id: directID, Emoji: "+1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
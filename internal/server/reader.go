@@ -0,0 +1,94 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/gbh007/p2p-chat/internal/entities"
+)
+
+type OverflowPolicy string
+
+const (
+	// OverflowDropOldest - отбрасывать самые старые непрочитанные сообщения
+	OverflowDropOldest OverflowPolicy = "drop-oldest"
+	// OverflowDisconnect - отключать медленного читателя с RESOURCE_EXHAUSTED
+	OverflowDisconnect OverflowPolicy = "disconnect"
+	// OverflowSpill - пропускать живые сообщения, читатель догоняет их из истории
+	OverflowSpill OverflowPolicy = "spill"
+)
+
+func ParseOverflowPolicy(s string) (OverflowPolicy, error) {
+	switch p := OverflowPolicy(s); p {
+	case OverflowDropOldest, OverflowDisconnect, OverflowSpill:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown overflow policy %q", s)
+	}
+}
+
+// eventBufferSize - очередь эфемерных событий читателя, при переполнении события теряются
+const eventBufferSize = 32
+
+type reader struct {
+	messages chan entities.Message
+	events   chan entities.Event
+	// overflow - сигнал о переполнении буфера, обработка зависит от политики
+	overflow chan struct{}
+
+	// user - пользователь читателя, у одного пользователя может быть несколько сессий
+	user string
+	// member - читатель является участником канала, защищен readersMutex вместе с presence
+	member   bool
+	presence entities.PresenceStatus
+}
+
+func newReader(size int) *reader {
+	return &reader{
+		messages: make(chan entities.Message, size),
+		events:   make(chan entities.Event, eventBufferSize),
+		overflow: make(chan struct{}, 1),
+	}
+}
+
+// pushEvent - неблокирующая отправка события, события не критичны и при переполнении отбрасываются
+func (r *reader) pushEvent(event entities.Event) {
+	select {
+	case r.events <- event:
+	default:
+	}
+}
+
+// push - неблокирующая отправка сообщения читателю
+func (r *reader) push(msg entities.Message, policy OverflowPolicy) {
+	select {
+	case r.messages <- msg:
+		return
+	default:
+	}
+
+	if policy != OverflowDropOldest {
+		r.signalOverflow()
+
+		return
+	}
+
+	for {
+		select {
+		case <-r.messages:
+		default:
+		}
+
+		select {
+		case r.messages <- msg:
+			return
+		default:
+		}
+	}
+}
+
+func (r *reader) signalOverflow() {
+	select {
+	case r.overflow <- struct{}{}:
+	default:
+	}
+}
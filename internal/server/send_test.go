@@ -0,0 +1,149 @@
+package server_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gbh007/p2p-chat/internal/entities"
+	"github.com/gbh007/p2p-chat/internal/identity"
+	"github.com/gbh007/p2p-chat/internal/ulid"
+	"github.com/gbh007/p2p-chat/proto/gen"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestSendMessageSignature(t *testing.T) {
+	ts := newServer(t)
+	key := newKey(t)
+	ts.register(t, "alice", key)
+
+	sent := time.Now()
+
+	tests := []struct {
+		name      string
+		kind      gen.MessageKind
+		signature func(id string) []byte
+		code      codes.Code
+	}{
+		{
+			name: "text",
+			kind: gen.MessageKind_MESSAGE_TEXT,
+			signature: func(id string) []byte {
+				return identity.SignMessage(key, "room", "hello", entities.MessageText, sent, id)
+			},
+		},
+		{
+			name: "action",
+			kind: gen.MessageKind_MESSAGE_ACTION,
+			signature: func(id string) []byte {
+				return identity.SignMessage(key, "room", "hello", entities.MessageAction, sent, id)
+			},
+		},
+		{
+			name:      "unsigned",
+			kind:      gen.MessageKind_MESSAGE_TEXT,
+			signature: func(string) []byte { return nil },
+			code:      codes.PermissionDenied,
+		},
+		{
+			// Подпись обычного сообщения не подтверждает смену темы
+			name: "wrong kind",
+			kind: gen.MessageKind_MESSAGE_TOPIC,
+			signature: func(id string) []byte {
+				return identity.SignMessage(key, "room", "hello", entities.MessageText, sent, id)
+			},
+			code: codes.PermissionDenied,
+		},
+		{
+			name: "other key",
+			kind: gen.MessageKind_MESSAGE_TEXT,
+			signature: func(id string) []byte {
+				return identity.SignMessage(newKey(t), "room", "hello", entities.MessageText, sent, id)
+			},
+			code: codes.PermissionDenied,
+		},
+		{
+			name: "unknown kind",
+			kind: gen.MessageKind(100),
+			signature: func(id string) []byte {
+				return identity.SignMessage(key, "room", "hello", entities.MessageKind(100), sent, id)
+			},
+			code: codes.InvalidArgument,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id := ulid.New()
+
+			_, err := ts.SendMessage(as("alice"), &gen.SendMessageRequest{
+				Channel:   "room",
+				Id:        id,
+				Message:   "hello",
+				Kind:      tt.kind,
+				Ts:        timestamppb.New(sent),
+				Signature: tt.signature(id),
+			})
+			if status.Code(err) != tt.code {
+				t.Fatalf("expected %v, got %v", tt.code, err)
+			}
+
+			if tt.code != codes.OK {
+				return
+			}
+
+			msg := ts.message(t, "room", id)
+			if msg.PublicKey == nil || msg.Signature == nil {
+				t.Fatalf("signature is not stored: %+v", msg)
+			}
+		})
+	}
+}
+
+func TestSendMessageRedelivery(t *testing.T) {
+	ts := newServer(t)
+	ts.register(t, "alice", nil)
+	ts.register(t, "bob", nil)
+
+	send := func(login, channel, id string) (*gen.SendMessageResponse, error) {
+		return ts.SendMessage(as(login), &gen.SendMessageRequest{Channel: channel, Id: id, Message: "hello"})
+	}
+
+	for _, channel := range []string{"room", entities.DirectChannel("bob")} {
+		id := ulid.New()
+
+		first, err := send("alice", channel, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Повтор после потерянного ответа возвращает уже сохраненное сообщение
+		again, err := send("alice", channel, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if again.GetSeq() != first.GetSeq() || again.GetId() != id || !again.GetTs().AsTime().Equal(first.GetTs().AsTime()) {
+			t.Fatalf("redelivery to %s created a new message: %+v %+v", channel, first, again)
+		}
+	}
+
+	last, err := ts.LastSeq(context.Background(), "room")
+	if err != nil || last != 1 {
+		t.Fatalf("unexpected last seq: %d %v", last, err)
+	}
+
+	id := ulid.New()
+
+	_, err = send("alice", "room", id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = send("bob", "room", id)
+	if status.Code(err) != codes.AlreadyExists {
+		t.Fatalf("id of another user must be rejected, got %v", err)
+	}
+}
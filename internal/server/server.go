@@ -2,117 +2,1052 @@ package server
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/gbh007/p2p-chat/internal/auth"
 	"github.com/gbh007/p2p-chat/internal/entities"
+	"github.com/gbh007/p2p-chat/internal/identity"
+	"github.com/gbh007/p2p-chat/internal/protoconv"
+	"github.com/gbh007/p2p-chat/internal/ulid"
 	"github.com/gbh007/p2p-chat/proto/gen"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
+const (
+	defaultHistoryLimit = 50
+	maxClockSkew        = 5 * time.Minute
+	messageIDLen        = 26
+)
+
+type Limits struct {
+	OverflowPolicy OverflowPolicy
+	// ReaderBuffer - количество живых сообщений в очереди читателя до срабатывания политики переполнения
+	ReaderBuffer int
+	// MaxHistory - максимальное количество сообщений в одном запросе истории
+	MaxHistory int
+}
+
+func DefaultLimits() Limits {
+	return Limits{
+		OverflowPolicy: OverflowSpill,
+		ReaderBuffer:   100,
+		MaxHistory:     500,
+	}
+}
+
+type MessageStore interface {
+	AddMessage(ctx context.Context, msg entities.Message) error
+	// LastSeq - последний номер канала с учетом номеров изменений
+	LastSeq(ctx context.Context, chat string) (uint64, error)
+	// MessagesAfter - сообщения с номером больше after и ранние сообщения, изменение которых получило номер больше after
+	MessagesAfter(ctx context.Context, chat string, after uint64, limit int) ([]entities.Message, error)
+	MessagesBefore(ctx context.Context, chat string, before uint64, limit int) ([]entities.Message, error)
+	MessageByID(ctx context.Context, chat, id string) (entities.Message, error)
+	// Replies - последние limit ответов на сообщение id в порядке номеров, 0 означает без ограничения
+	Replies(ctx context.Context, chat, id string, limit int) ([]entities.Message, error)
+	// UpdateMessage - замена сообщения с тем же идентификатором, номер сообщения сохраняется
+	UpdateMessage(ctx context.Context, msg entities.Message) error
+}
+
+type KeyStore interface {
+	AddChannelKeys(ctx context.Context, shares []entities.ChannelKeyShare) error
+	ChannelKeys(ctx context.Context, chat, login string) ([]entities.ChannelKeyShare, error)
+	ChannelEncryption(ctx context.Context, chat string) (entities.ChannelEncryption, error)
+}
+
+// Relay - получатель сообщений, отправленных клиентами этого сервера,
+// вызывается под блокировкой отправки и не должен блокироваться
+type Relay interface {
+	Relay(msg entities.Message)
+	// RelayChange - рассылка изменения, подписанного автором, для удаления Edited содержит время удаления
+	RelayChange(msg entities.Message)
+}
+
+// Federation - связь с серверами на которых размещены каналы вида name@domain
+type Federation interface {
+	// SendRemote - отправка сообщения на сервер канала, возвращает назначенный там номер
+	SendRemote(ctx context.Context, msg entities.Message) (uint64, error)
+	// Follow - получение сообщений канала с его сервера, повторный вызов ничего не делает,
+	// ошибка если сервер канала не разрешен, вызывается под блокировкой читателей и не должен блокироваться
+	Follow(chat string) error
+	// Unfollow - остановка получения канала, у которого не осталось читателей на этом сервере
+	Unfollow(chat string)
+}
+
+type Accounts interface {
+	Register(ctx context.Context, login, password string, publicKey []byte) (string, time.Time, error)
+	Login(ctx context.Context, login, password string) (string, time.Time, error)
+	PublicKey(ctx context.Context, login string) ([]byte, error)
+	User(ctx context.Context, login string) (entities.User, error)
+	SetEncryptionKey(ctx context.Context, login string, key, signature []byte) error
+}
+
 type Server struct {
 	gen.UnimplementedServerServer
 	logger *slog.Logger
 
-	readers      map[string]map[string]chan entities.Message
+	store    MessageStore
+	keys     KeyStore
+	accounts Accounts
+
+	// sendMutex - сохранение и рассылка должны идти в одном порядке,
+	// иначе читатели не смогут склеить историю с живым потоком,
+	// также защищает смену ключей шифрования каналов
+	sendMutex *sync.Mutex
+	// seqs - последний выданный номер сообщения по каналам, защищен sendMutex
+	seqs map[string]uint64
+
+	limits Limits
+
+	relay Relay
+
+	// domain - имя сервера в федерации, пусто если федерация выключена
+	domain     string
+	federation Federation
+
+	// moderators - пользователи которые могут удалять любые сообщения каналов
+	moderators map[string]struct{}
+
+	// readers - читатели каналов по ключу подписки, канал -> ключ -> читатель
+	readers      map[string]map[string]*reader
 	readersMutex *sync.RWMutex
 }
 
-func New() *Server {
+func New(store MessageStore, keys KeyStore, accounts Accounts, limits Limits) *Server {
 	return &Server{
-		readers:      make(map[string]map[string]chan entities.Message),
+		keys:         keys,
+		accounts:     accounts,
+		limits:       limits,
+		readers:      make(map[string]map[string]*reader),
 		readersMutex: &sync.RWMutex{},
+		sendMutex:    &sync.Mutex{},
+		seqs:         make(map[string]uint64),
+		moderators:   make(map[string]struct{}),
 		logger:       slog.Default(),
+		store:        store,
+	}
+}
+
+// SetRelay - подключение пересылки сообщений, вызывать до начала обслуживания запросов
+func (s *Server) SetRelay(relay Relay) {
+	s.relay = relay
+}
+
+// SetFederation - подключение федерации, domain - имя этого сервера, вызывать до начала обслуживания запросов
+func (s *Server) SetFederation(domain string, federation Federation) {
+	s.domain = domain
+	s.federation = federation
+}
+
+// SetModerators - пользователи которые могут удалять чужие сообщения, вызывать до начала обслуживания запросов
+func (s *Server) SetModerators(logins []string) {
+	s.moderators = make(map[string]struct{}, len(logins))
+
+	for _, login := range logins {
+		s.moderators[login] = struct{}{}
 	}
 }
 
 func (s *Server) ReadMessages(req *gen.ReadMessagesRequest, stream grpc.ServerStreamingServer[gen.ReadMessagesResponse]) error {
-	ctx := stream.Context()
+	login, err := loginFromContext(stream.Context())
+	if err != nil {
+		return err
+	}
+
+	chat, err := s.subscribeChat(login, req.GetChannel())
+	if err != nil {
+		return err
+	}
+
+	return s.listen(stream.Context(), chat, login, readerKey(login), req.Since, listener{
+		// Заголовки сообщают клиенту что подписка активна
+		ready:  func() error { return stream.SendHeader(metadata.MD{}) },
+		send:   func(msg entities.Message) error { return stream.Send(messageToResponse(msg)) },
+		change: func(msg entities.Message) error { return stream.Send(messageToResponse(msg)) },
+		member: true,
+	})
+}
+
+// Listen - чтение канала не клиентом сервера (например другим сервером федерации),
+// key отличает читателя от остальных, сообщения и изменения после since отправляются до отмены контекста,
+// изменение отличается от нового сообщения тем что его Seq не больше уже полученных номеров
+func (s *Server) Listen(ctx context.Context, chat, key string, since uint64, send func(entities.Message) error) error {
+	if isDirect(chat) {
+		return errDirectForbidden
+	}
+
+	return s.listen(ctx, chat, key, key, &since, listener{send: send, change: send})
+}
+
+// readerKey - уникальный ключ подписки пользователя, каждая сессия и устройство читают канал независимо
+func readerKey(login string) string {
+	return login + "#" + ulid.New()
+}
+
+// listener - получатель сообщений подписки, ready и event необязательны
+type listener struct {
+	ready func() error
+	send  func(entities.Message) error
+	// change - изменение сообщения, которое читатель уже получил
+	change func(entities.Message) error
+	event  func(entities.Event) error
+	// member - о подписке и ее завершении сообщается остальным участникам канала
+	member bool
+}
+
+// listen - подписка читателя user с ключом key, ключ уникален для каждой подписки
+func (s *Server) listen(ctx context.Context, chat, user, key string, since *uint64, l listener) error {
+	// Без курсора читатель получает только новое, изменения отличаются от новых сообщений по этому номеру
+	lastSeq, err := s.LastSeq(ctx, chat)
+	if err != nil {
+		s.logger.Error("last seq", "chan", chat, "error", err)
+		return status.Error(codes.Internal, "last seq")
+	}
 
-	ch := make(chan entities.Message, 100)
+	r := newReader(s.limits.ReaderBuffer)
 
 	s.readersMutex.Lock()
 
-	users, ok := s.readers[req.GetChannel()]
+	readers, ok := s.readers[chat]
 	if !ok {
-		users = make(map[string]chan entities.Message)
-		s.logger.Info("create chan", "chan", req.GetChannel())
-		s.readers[req.GetChannel()] = users
+		// Канал другого сервера читается с него пока на этом сервере есть читатели
+		if s.isRemote(chat) {
+			err = s.federation.Follow(chat)
+			if err != nil {
+				s.readersMutex.Unlock()
+
+				return err
+			}
+		}
+
+		readers = make(map[string]*reader)
+		s.logger.Info("create chan", "chan", chat)
+		s.readers[chat] = readers
 	}
 
-	_, ok = users[req.GetLogin()]
+	_, ok = readers[key]
 	if ok {
 		s.readersMutex.Unlock()
 
-		return fmt.Errorf("already connected")
+		return status.Error(codes.AlreadyExists, "already subscribed")
 	}
 
-	users[req.GetLogin()] = ch
-	s.logger.Info("create user listen", "chan", req.GetChannel(), "user", req.GetLogin())
+	// О входе сообщается только для первой сессии пользователя
+	_, online := s.userPresence(chat, user)
+	joined := l.member && !online
+
+	r.user = user
+	r.member = l.member
+	readers[key] = r
+	s.logger.Info("create user listen", "chan", chat, "user", user, "key", key)
 
 	s.readersMutex.Unlock()
 
-	var sendError error
+	if joined {
+		s.broadcastEvent(entities.Event{
+			Kind:     entities.EventPresence,
+			Chat:     chat,
+			User:     user,
+			Presence: entities.PresenceJoined,
+			TS:       time.Now(),
+		})
+	}
+
+	defer func() {
+		s.readersMutex.Lock()
+
+		delete(s.readers[chat], key)
+
+		if len(s.readers[chat]) == 0 {
+			delete(s.readers, chat)
+			s.logger.Info("remove chan", "chan", chat)
+
+			if s.isRemote(chat) {
+				s.federation.Unfollow(chat)
+			}
+		}
+
+		// О выходе из канала уже сообщено при отписке, пользователь остается в сети пока есть другие сессии
+		_, online := s.userPresence(chat, user)
+		offline := l.member && r.presence != entities.PresenceLeft && !online
+
+		s.readersMutex.Unlock()
+
+		if offline {
+			s.broadcastEvent(entities.Event{
+				Kind:     entities.EventPresence,
+				Chat:     chat,
+				User:     user,
+				Presence: entities.PresenceOffline,
+				TS:       time.Now(),
+			})
+		}
+	}()
+
+	if l.ready != nil {
+		err := l.ready()
+		if err != nil {
+			return fmt.Errorf("send header: %w", err)
+		}
+	}
+
+	// Читатель уже подписан, поэтому все что не попадет в историю придет через канал
+	if since != nil {
+		lastSeq, err = s.replay(ctx, chat, *since, l)
+		if err != nil {
+			return err
+		}
+	}
 
-listen:
 	for {
 		select {
-		case msg := <-ch:
-			sendError = stream.Send(&gen.ReadMessagesResponse{
-				Login:   msg.User,
-				Message: msg.Text,
-				Ts:      timestamppb.New(msg.TS),
-			})
-			if sendError != nil {
-				break listen
+		case msg := <-r.messages:
+			if msg.Position() <= lastSeq {
+				continue
+			}
+
+			err = l.deliver(msg, lastSeq)
+			if err != nil {
+				return fmt.Errorf("send: %w", err)
+			}
+
+			lastSeq = msg.Position()
+		case event := <-r.events:
+			if l.event == nil {
+				continue
+			}
+
+			err = l.event(event)
+			if err != nil {
+				return fmt.Errorf("send event: %w", err)
+			}
+		case <-r.overflow:
+			if s.limits.OverflowPolicy == OverflowDisconnect {
+				s.logger.Warn("disconnect slow reader", "chan", chat, "user", key)
+
+				return status.Error(codes.ResourceExhausted, "reader is too slow")
+			}
+
+			// Пропущенные сообщения уже в хранилище, догоняем по номеру
+			lastSeq, err = s.replay(ctx, chat, lastSeq, l)
+			if err != nil {
+				return err
 			}
 		case <-ctx.Done():
-			break listen
+			return nil
 		}
 	}
+}
 
+// broadcastEvent - рассылка эфемерного события читателям канала кроме сессий самого автора
+func (s *Server) broadcastEvent(event entities.Event) {
+	s.readersMutex.RLock()
+	defer s.readersMutex.RUnlock()
+
+	for _, r := range s.readers[event.Chat] {
+		if r.user != event.User {
+			r.pushEvent(event)
+		}
+	}
+}
+
+// setPresence - смена статуса подписки участника с уведомлением остальных, false если подписки нет,
+// остальные видят общий статус всех сессий пользователя
+func (s *Server) setPresence(chat, key string, presence entities.PresenceStatus) bool {
 	s.readersMutex.Lock()
-	delete(s.readers[req.GetChannel()], req.GetLogin())
+
+	r, ok := s.readers[chat][key]
+	if !ok || !r.member {
+		s.readersMutex.Unlock()
+
+		return false
+	}
+
+	r.presence = presence
+
+	current, online := s.userPresence(chat, r.user)
+
 	s.readersMutex.Unlock()
 
-	close(ch)
+	// Выход одной из сессий не означает выход пользователя
+	if presence == entities.PresenceLeft && online {
+		return true
+	}
 
-	// Дочитываем чтобы разблокировать другие потоки
-	for range ch {
+	if presence != entities.PresenceLeft {
+		presence = current
 	}
 
-	if sendError != nil {
-		return fmt.Errorf("send: %w", sendError)
+	s.broadcastEvent(entities.Event{
+		Kind:     entities.EventPresence,
+		Chat:     chat,
+		User:     r.user,
+		Presence: presence,
+		TS:       time.Now(),
+	})
+
+	return true
+}
+
+// userPresence - общий статус подписок пользователя на канал, false если пользователь не подписан,
+// вызывать под readersMutex
+func (s *Server) userPresence(chat, user string) (entities.PresenceStatus, bool) {
+	presence, ok := entities.PresenceAway, false
+
+	for _, r := range s.readers[chat] {
+		if r.user != user || !r.member || r.presence == entities.PresenceLeft {
+			continue
+		}
+
+		ok = true
+
+		if r.presence != entities.PresenceAway {
+			presence = entities.PresenceOnline
+		}
 	}
 
-	return nil
+	return presence, ok
+}
+
+// members - подписанные на канал участники, отсортированы по логину
+func (s *Server) members(chat string) []entities.Member {
+	s.readersMutex.RLock()
+	defer s.readersMutex.RUnlock()
+
+	result := make([]entities.Member, 0, len(s.readers[chat]))
+	seen := make(map[string]struct{}, len(s.readers[chat]))
+
+	for _, r := range s.readers[chat] {
+		if _, ok := seen[r.user]; ok {
+			continue
+		}
+
+		if presence, ok := s.userPresence(chat, r.user); ok {
+			seen[r.user] = struct{}{}
+			result = append(result, entities.Member{User: r.user, Presence: presence})
+		}
+	}
+
+	slices.SortFunc(result, func(a, b entities.Member) int {
+		return strings.Compare(a.User, b.User)
+	})
+
+	return result
+}
+
+// replay - отправляет сохраненные сообщения и изменения после указанного номера, возвращает номер последнего отправленного
+func (s *Server) replay(ctx context.Context, chat string, after uint64, l listener) (uint64, error) {
+	messages, err := s.store.MessagesAfter(ctx, chat, after, 0)
+	if err != nil {
+		s.logger.Error("replay history", "chan", chat, "error", err)
+		return after, storeError("history", err)
+	}
+
+	last := after
+
+	for _, msg := range messages {
+		err = l.deliver(msg, after)
+		if err != nil {
+			return last, fmt.Errorf("send: %w", err)
+		}
+
+		last = max(last, msg.Position())
+	}
+
+	return last, nil
+}
+
+// deliver - отправка читателю, который уже получил все до номера after включительно:
+// более ранние сообщения приходят изменением, более поздние - новым сообщением в текущем состоянии
+func (l listener) deliver(msg entities.Message, after uint64) error {
+	if msg.Seq > after {
+		return l.send(msg)
+	}
+
+	return l.change(msg)
 }
 
 func (s *Server) SendMessage(ctx context.Context, req *gen.SendMessageRequest) (*gen.SendMessageResponse, error) {
+	// Логин из запроса игнорируется, отправитель определяется по сессии
+	login, err := loginFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	msg, err := s.newMessage(ctx, login, req)
+	if err != nil {
+		return nil, err
+	}
+
+	if msg.To != "" {
+		return s.sendDirect(ctx, msg)
+	}
+
+	if s.isRemote(msg.Chat) {
+		return s.sendRemote(ctx, msg)
+	}
+
+	s.sendMutex.Lock()
+	defer s.sendMutex.Unlock()
+
+	// Идентификатор от клиента служит ключом идемпотентности, повторная отправка
+	// после потери ответа возвращает уже сохраненное сообщение
+	if req.GetId() != "" {
+		existing, err := s.store.MessageByID(ctx, msg.Chat, msg.ID)
+		switch {
+		case err == nil && existing.User == login:
+			return &gen.SendMessageResponse{
+				Ts:  timestamppb.New(existing.TS),
+				Id:  existing.ID,
+				Seq: existing.Seq,
+			}, nil
+		case err == nil:
+			return nil, status.Error(codes.AlreadyExists, "message id already used")
+		case !errors.Is(err, entities.ErrNotFound):
+			s.logger.Error("find message", "chan", msg.Chat, "error", err)
+			return nil, status.Error(codes.Internal, "find message")
+		}
+	}
+
+	err = s.checkEncryption(ctx, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	msg, err = s.publish(ctx, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.relay != nil {
+		s.relay.Relay(msg)
+	}
+
+	return &gen.SendMessageResponse{
+		Ts:  timestamppb.New(msg.TS),
+		Id:  msg.ID,
+		Seq: msg.Seq,
+	}, nil
+}
+
+// sendRemote - сообщение канала другого сервера сохраняется у себя только после
+// возврата от сервера канала, чтобы порядок сообщений был единым для всей федерации
+func (s *Server) sendRemote(ctx context.Context, msg entities.Message) (*gen.SendMessageResponse, error) {
+	if len(msg.Ciphertext) > 0 {
+		return nil, status.Error(codes.InvalidArgument, "encrypted channels are not federated")
+	}
+
+	seq, err := s.federation.SendRemote(ctx, msg)
+	if err != nil {
+		s.logger.Warn("send remote", "chan", msg.Chat, "error", err)
+
+		if _, ok := status.FromError(err); ok {
+			return nil, err
+		}
+
+		return nil, status.Error(codes.Unavailable, "channel server unavailable")
+	}
+
+	return &gen.SendMessageResponse{
+		Ts:  timestamppb.New(msg.TS),
+		Id:  msg.ID,
+		Seq: seq,
+	}, nil
+}
+
+// Accept - прием сообщения пересланного другим сервером федерации в канал этого сервера,
+// повторная пересылка того же сообщения возвращает уже сохраненное
+func (s *Server) Accept(ctx context.Context, msg entities.Message) (entities.Message, error) {
+	if isDirect(msg.Chat) {
+		return entities.Message{}, errDirectForbidden
+	}
+
+	s.sendMutex.Lock()
+	defer s.sendMutex.Unlock()
+
+	existing, err := s.store.MessageByID(ctx, msg.Chat, msg.ID)
+	switch {
+	case err == nil && existing.User == msg.User && existing.Domain == msg.Domain:
+		return existing, nil
+	case err == nil:
+		return entities.Message{}, status.Error(codes.AlreadyExists, "message id already used")
+	case !errors.Is(err, entities.ErrNotFound):
+		s.logger.Error("find message", "chan", msg.Chat, "error", err)
+		return entities.Message{}, status.Error(codes.Internal, "find message")
+	}
+
+	err = s.checkReply(ctx, msg.User, msg)
+	if err != nil {
+		return entities.Message{}, err
+	}
+
+	msg, err = s.publish(ctx, msg)
+	if err != nil {
+		return entities.Message{}, err
+	}
+
+	if s.relay != nil {
+		s.relay.Relay(msg)
+	}
+
+	return msg, nil
+}
+
+// DeliverSeq - сохранение сообщения или изменения канала другого сервера с номером назначенным сервером канала,
+// уже известные номера пропускаются
+func (s *Server) DeliverSeq(ctx context.Context, msg entities.Message) error {
+	s.sendMutex.Lock()
+	defer s.sendMutex.Unlock()
+
+	last, err := s.lastSeq(ctx, msg.Chat)
+	if err != nil {
+		return err
+	}
+
+	if msg.Seq > last {
+		return s.commit(ctx, msg)
+	}
+
+	if msg.ChangeSeq <= last {
+		return nil
+	}
+
+	_, err = s.store.MessageByID(ctx, msg.Chat, msg.ID)
+	// Сообщение могло не сохраниться, например вытесненное из памяти, изменение тогда только сдвигает номер
+	if errors.Is(err, entities.ErrNotFound) {
+		s.seqs[msg.Chat] = msg.ChangeSeq
+
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return s.commitChange(ctx, msg)
+}
+
+// LastSeq - номер последнего сохраненного сообщения канала
+func (s *Server) LastSeq(ctx context.Context, chat string) (uint64, error) {
+	s.sendMutex.Lock()
+	defer s.sendMutex.Unlock()
+
+	return s.lastSeq(ctx, chat)
+}
+
+// Deliver - прием сообщения, пришедшего не от клиента этого сервера (например от соседнего узла),
+// false если сообщение с таким идентификатором уже есть
+func (s *Server) Deliver(ctx context.Context, msg entities.Message) (bool, error) {
+	if isDirect(msg.Chat) {
+		return false, errDirectForbidden
+	}
+
+	s.sendMutex.Lock()
+	defer s.sendMutex.Unlock()
+
+	_, err := s.store.MessageByID(ctx, msg.Chat, msg.ID)
+	if err == nil {
+		return false, nil
+	}
+
+	if !errors.Is(err, entities.ErrNotFound) {
+		return false, err
+	}
+
+	_, err = s.publish(ctx, msg)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// publish - назначение номера, сохранение и рассылка, вызывать только под sendMutex
+func (s *Server) publish(ctx context.Context, msg entities.Message) (entities.Message, error) {
+	seq, err := s.nextSeq(ctx, msg.Chat)
+	if err != nil {
+		s.logger.Error("next seq", "chan", msg.Chat, "error", err)
+		return entities.Message{}, status.Error(codes.Internal, "next seq")
+	}
+
+	msg.Seq = seq
+
+	err = s.commit(ctx, msg)
+	if err != nil {
+		return entities.Message{}, err
+	}
+
+	return msg, nil
+}
+
+// commit - сохранение и рассылка сообщения с назначенным номером, вызывать только под sendMutex
+func (s *Server) commit(ctx context.Context, msg entities.Message) error {
+	err := s.store.AddMessage(ctx, msg)
+	if err != nil {
+		s.logger.Error("store message", "chan", msg.Chat, "user", msg.User, "error", err)
+		return storeError("store message", err)
+	}
+
+	s.seqs[msg.Chat] = msg.Position()
+	s.push(msg)
+
+	return nil
+}
+
+// commitChange - сохранение и рассылка изменения с назначенным номером, вызывать только под sendMutex
+func (s *Server) commitChange(ctx context.Context, msg entities.Message) error {
+	err := s.store.UpdateMessage(ctx, msg)
+	if err != nil {
+		s.logger.Error("update message", "chan", msg.Chat, "error", err)
+		return storeError("update message", err)
+	}
+
+	s.seqs[msg.Chat] = msg.ChangeSeq
+	s.push(msg)
+
+	return nil
+}
+
+// storeError - ошибка хранилища для клиента, слишком большое для хранилища сообщение - ошибка запроса,
+// истекший или отмененный контекст запроса сообщается клиенту как есть
+func storeError(action string, err error) error {
+	switch {
+	case errors.Is(err, entities.ErrTooLarge):
+		return status.Error(codes.InvalidArgument, "message too large")
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, action+": deadline exceeded")
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, action+": canceled")
+	default:
+		return status.Error(codes.Internal, action)
+	}
+}
+
+// push - рассылка сохраненного сообщения или изменения читателям канала
+func (s *Server) push(msg entities.Message) {
 	s.readersMutex.RLock()
 	defer s.readersMutex.RUnlock()
 
-	users, ok := s.readers[req.GetChannel()]
-	if !ok {
-		s.logger.Info("missing channel", "chan", req.GetChannel(), "user", req.GetLogin())
-		return nil, fmt.Errorf("missing channel")
+	for _, r := range s.readers[msg.Chat] {
+		r.push(msg, s.limits.OverflowPolicy)
 	}
+}
 
+func (s *Server) newMessage(ctx context.Context, login string, req *gen.SendMessageRequest) (entities.Message, error) {
 	msg := entities.Message{
-		Chat: req.GetChannel(),
-		User: req.GetLogin(),
+		ID:   req.GetId(),
+		Chat: s.localChannel(req.GetChannel()),
+		User: login,
 		Text: req.GetMessage(),
 		TS:   time.Now(),
+
+		Ciphertext: req.GetCiphertext(),
+		KeyEpoch:   req.GetKeyEpoch(),
+
+		ReplyTo: req.GetReplyTo(),
 	}
 
-	for _, ch := range users {
-		ch <- msg
+	kind, ok := protoconv.KindFromProto(req.GetKind())
+	if !ok {
+		return entities.Message{}, status.Error(codes.InvalidArgument, "unknown message kind")
 	}
 
-	return &gen.SendMessageResponse{
-		Ts: timestamppb.Now(),
+	msg.Kind = kind
+
+	// Личное сообщение подписывается с каналом получателя
+	if peer, ok := entities.DirectPeer(req.GetChannel()); ok {
+		err := s.checkDirectPeer(ctx, peer)
+		if err != nil {
+			return entities.Message{}, err
+		}
+
+		msg.Chat = entities.DirectChannel(peer)
+		msg.To = peer
+	}
+
+	if msg.ID == "" {
+		msg.ID = ulid.New()
+	} else if len(msg.ID) != messageIDLen {
+		return entities.Message{}, status.Error(codes.InvalidArgument, "invalid message id")
+	}
+
+	if req.Ts != nil {
+		ts := req.GetTs().AsTime()
+
+		if ts.Sub(msg.TS).Abs() > maxClockSkew {
+			return entities.Message{}, status.Error(codes.InvalidArgument, "message time too far from server time")
+		}
+
+		msg.TS = ts
+	}
+
+	err := s.checkReply(ctx, login, msg)
+	if err != nil {
+		return entities.Message{}, err
+	}
+
+	err = s.checkSignature(ctx, login, msg.Chat, &msg, msg.TS, req.GetSignature())
+	if err != nil {
+		return entities.Message{}, err
+	}
+
+	return msg, nil
+}
+
+// checkSignature - проверка подписи сообщения сделанной на момент ts, подпись и ключ сохраняются в сообщении
+func (s *Server) checkSignature(
+	ctx context.Context,
+	login, channel string,
+	msg *entities.Message,
+	ts time.Time,
+	signature []byte,
+) error {
+	// Пользователь mTLS может не иметь учетной записи
+	publicKey, err := s.accounts.PublicKey(ctx, login)
+	if err != nil && !errors.Is(err, entities.ErrNotFound) {
+		s.logger.Error("get public key", "user", login, "error", err)
+		return status.Error(codes.Internal, "get public key")
+	}
+
+	// Пользователи без привязанного ключа могут отправлять только неподписанные сообщения
+	if len(publicKey) == 0 {
+		msg.Signature = nil
+		msg.PublicKey = nil
+
+		return nil
+	}
+
+	signedText := identity.SignedText(msg.Text, msg.Ciphertext)
+
+	if !identity.VerifyMessage(publicKey, channel, signedText, msg.Kind, ts, msg.ID, signature) {
+		return status.Error(codes.PermissionDenied, "invalid signature")
+	}
+
+	msg.Signature = signature
+	msg.PublicKey = publicKey
+
+	return nil
+}
+
+// nextSeq - номер для нового сообщения канала, вызывать только под sendMutex
+func (s *Server) nextSeq(ctx context.Context, chat string) (uint64, error) {
+	seq, err := s.lastSeq(ctx, chat)
+	if err != nil {
+		return 0, err
+	}
+
+	return seq + 1, nil
+}
+
+// lastSeq - последний выданный номер канала, вызывать только под sendMutex
+func (s *Server) lastSeq(ctx context.Context, chat string) (uint64, error) {
+	seq, ok := s.seqs[chat]
+	if !ok {
+		var err error
+
+		// После перезапуска продолжаем нумерацию с последнего сохраненного сообщения
+		seq, err = s.store.LastSeq(ctx, chat)
+		if err != nil {
+			return 0, err
+		}
+
+		s.seqs[chat] = seq
+	}
+
+	return seq, nil
+}
+
+// localChannel - канал этого сервера указанный с его доменом хранится без домена
+func (s *Server) localChannel(chat string) string {
+	name, domain := entities.SplitChannel(chat)
+	if domain != "" && domain == s.domain {
+		return name
+	}
+
+	return chat
+}
+
+// isRemote - канал размещен на другом сервере федерации
+func (s *Server) isRemote(chat string) bool {
+	_, domain := entities.SplitChannel(chat)
+
+	return domain != "" && s.federation != nil && domain != s.domain
+}
+
+func (s *Server) GetHistory(ctx context.Context, req *gen.GetHistoryRequest) (*gen.GetHistoryResponse, error) {
+	login, err := loginFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	limit := int(req.GetLimit())
+
+	switch {
+	case limit == 0:
+		limit = min(defaultHistoryLimit, s.limits.MaxHistory)
+	case limit > s.limits.MaxHistory:
+		return nil, status.Errorf(codes.InvalidArgument, "limit exceeds %d", s.limits.MaxHistory)
+	}
+
+	var messages []entities.Message
+
+	if peer, ok := entities.DirectPeer(req.GetChannel()); ok && peer != "" {
+		messages, err = s.directHistory(ctx, login, peer, req.GetBefore(), limit)
+	} else {
+		messages, err = s.store.MessagesBefore(ctx, s.readChat(login, req.GetChannel()), req.GetBefore(), limit)
+	}
+
+	if err != nil {
+		s.logger.Error("get history", "chan", req.GetChannel(), "error", err)
+		return nil, storeError("history", err)
+	}
+
+	res := &gen.GetHistoryResponse{
+		Messages: make([]*gen.ReadMessagesResponse, 0, len(messages)),
+	}
+
+	for _, msg := range messages {
+		res.Messages = append(res.Messages, messageToResponse(msg))
+	}
+
+	return res, nil
+}
+
+// ListMembers - участники подключенные к каналу на этом сервере
+func (s *Server) ListMembers(ctx context.Context, req *gen.ListMembersRequest) (*gen.ListMembersResponse, error) {
+	// Участники личной переписки известны из ее имени
+	if isDirect(req.GetChannel()) {
+		return &gen.ListMembersResponse{}, nil
+	}
+
+	members := s.members(s.localChannel(req.GetChannel()))
+
+	res := &gen.ListMembersResponse{
+		Members: make([]*gen.Member, 0, len(members)),
+	}
+
+	for _, member := range members {
+		res.Members = append(res.Members, &gen.Member{
+			Login:  member.User,
+			Status: presenceToProto(member.Presence),
+		})
+	}
+
+	return res, nil
+}
+
+func (s *Server) Register(ctx context.Context, req *gen.RegisterRequest) (*gen.RegisterResponse, error) {
+	err := checkCertLogin(ctx, req.GetLogin())
+	if err != nil {
+		return nil, err
+	}
+
+	token, expiresAt, err := s.accounts.Register(ctx, req.GetLogin(), req.GetPassword(), req.GetPublicKey())
+	if err != nil {
+		return nil, s.accountError("register", req.GetLogin(), err)
+	}
+
+	s.logger.Info("register user", "user", req.GetLogin())
+
+	return &gen.RegisterResponse{
+		Token:     token,
+		ExpiresAt: timestamppb.New(expiresAt),
+	}, nil
+}
+
+func (s *Server) Login(ctx context.Context, req *gen.LoginRequest) (*gen.LoginResponse, error) {
+	err := checkCertLogin(ctx, req.GetLogin())
+	if err != nil {
+		return nil, err
+	}
+
+	token, expiresAt, err := s.accounts.Login(ctx, req.GetLogin(), req.GetPassword())
+	if err != nil {
+		return nil, s.accountError("login", req.GetLogin(), err)
+	}
+
+	return &gen.LoginResponse{
+		Token:     token,
+		ExpiresAt: timestamppb.New(expiresAt),
 	}, nil
 }
+
+func (s *Server) accountError(action, login string, err error) error {
+	switch {
+	case errors.Is(err, auth.ErrInvalidLogin),
+		errors.Is(err, auth.ErrWeakPassword),
+		errors.Is(err, auth.ErrInvalidPublicKey):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, auth.ErrInvalidSignature):
+		return status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, auth.ErrMissingIdentity):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, entities.ErrNotFound):
+		return status.Error(codes.FailedPrecondition, "user not registered")
+	case errors.Is(err, auth.ErrInvalidCredentials):
+		return status.Error(codes.Unauthenticated, err.Error())
+	case errors.Is(err, entities.ErrAlreadyExists):
+		return status.Error(codes.AlreadyExists, "user already exists")
+	}
+
+	s.logger.Error(action, "user", login, "error", err)
+
+	return status.Error(codes.Internal, action+" failed")
+}
+
+// checkCertLogin - при mTLS логин задан сертификатом и не может быть другим
+func checkCertLogin(ctx context.Context, login string) error {
+	certLogin, ok := auth.LoginFromContext(ctx)
+	if ok && certLogin != login {
+		return status.Error(codes.PermissionDenied, "login does not match client certificate")
+	}
+
+	return nil
+}
+
+func loginFromContext(ctx context.Context) (string, error) {
+	login, ok := auth.LoginFromContext(ctx)
+	if !ok {
+		return "", status.Error(codes.Unauthenticated, "unauthenticated")
+	}
+
+	return login, nil
+}
+
+func messageToResponse(msg entities.Message) *gen.ReadMessagesResponse {
+	res := &gen.ReadMessagesResponse{
+		Login:     msg.User,
+		Message:   msg.Text,
+		Ts:        timestamppb.New(msg.TS),
+		Seq:       msg.Seq,
+		Id:        msg.ID,
+		Signature: msg.Signature,
+		PublicKey: msg.PublicKey,
+
+		Ciphertext: msg.Ciphertext,
+		KeyEpoch:   msg.KeyEpoch,
+		Domain:     msg.Domain,
+		To:         msg.To,
+		Deleted:    msg.Deleted,
+		Reactions:  reactionsToProto(msg.Reactions),
+		ReplyTo:    msg.ReplyTo,
+		ChangeSeq:  msg.ChangeSeq,
+		Kind:       protoconv.KindToProto(msg.Kind),
+	}
+
+	if !msg.Edited.IsZero() {
+		res.Edited = timestamppb.New(msg.Edited)
+	}
+
+	return res
+}
@@ -0,0 +1,89 @@
+package server_test
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+
+	"github.com/gbh007/p2p-chat/internal/auth"
+	"github.com/gbh007/p2p-chat/internal/entities"
+	"github.com/gbh007/p2p-chat/internal/server"
+	"github.com/gbh007/p2p-chat/internal/storage"
+	"github.com/gbh007/p2p-chat/internal/ulid"
+	"github.com/gbh007/p2p-chat/proto/gen"
+)
+
+const testPassword = "password"
+
+type testServer struct {
+	*server.Server
+	store    *storage.Memory
+	accounts *auth.Service
+}
+
+func newServer(t *testing.T) *testServer {
+	t.Helper()
+
+	store := storage.NewMemory(100)
+	accounts := auth.New(store)
+
+	return &testServer{
+		Server:   server.New(store, store, accounts, server.DefaultLimits()),
+		store:    store,
+		accounts: accounts,
+	}
+}
+
+// register - пользователь с ключом подписи, без ключа сообщения пользователя не подписываются
+func (ts *testServer) register(t *testing.T, login string, key ed25519.PrivateKey) {
+	t.Helper()
+
+	var publicKey []byte
+	if key != nil {
+		publicKey = key.Public().(ed25519.PublicKey)
+	}
+
+	_, _, err := ts.accounts.Register(context.Background(), login, testPassword, publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// send - неподписанное сообщение пользователя, возвращает его идентификатор
+func (ts *testServer) send(t *testing.T, login, channel, text string) string {
+	t.Helper()
+
+	res, err := ts.SendMessage(as(login), &gen.SendMessageRequest{Channel: channel, Id: ulid.New(), Message: text})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return res.GetId()
+}
+
+func (ts *testServer) message(t *testing.T, chat, id string) entities.Message {
+	t.Helper()
+
+	msg, err := ts.store.MessageByID(context.Background(), chat, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return msg
+}
+
+func as(login string) context.Context {
+	return auth.WithLogin(context.Background(), login)
+}
+
+func newKey(t *testing.T) ed25519.PrivateKey {
+	t.Helper()
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key
+}
@@ -0,0 +1,432 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/gbh007/p2p-chat/internal/entities"
+	"github.com/gbh007/p2p-chat/proto/gen"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// sessionBufferSize - очередь исходящих кадров сессии, отправка в поток идет из одной горутины
+const sessionBufferSize = 256
+
+// session - подписки и запросы одного клиента поверх общего двунаправленного потока
+type session struct {
+	server *Server
+	stream grpc.BidiStreamingServer[gen.ClientFrame, gen.ServerFrame]
+	login  string
+	// key - ключ подписок сессии, отличает ее от других сессий того же пользователя
+	key string
+
+	out chan *gen.ServerFrame
+
+	subs map[string]context.CancelFunc
+	// acks - последние подтвержденные сессией сообщения, канал -> номер, живут пока открыт поток
+	acks      map[string]uint64
+	subsMutex *sync.Mutex
+	wg        *sync.WaitGroup
+}
+
+// Session - мультиплексирование подписок, отправки, подтверждений и эфемерных событий в одном потоке
+func (s *Server) Session(stream grpc.BidiStreamingServer[gen.ClientFrame, gen.ServerFrame]) error {
+	login, err := loginFromContext(stream.Context())
+	if err != nil {
+		return err
+	}
+
+	// Заголовки сообщают клиенту что сессия установлена
+	err = stream.SendHeader(metadata.MD{})
+	if err != nil {
+		return err
+	}
+
+	sess := &session{
+		server:    s,
+		stream:    stream,
+		login:     login,
+		key:       readerKey(login),
+		out:       make(chan *gen.ServerFrame, sessionBufferSize),
+		subs:      make(map[string]context.CancelFunc),
+		acks:      make(map[string]uint64),
+		subsMutex: &sync.Mutex{},
+		wg:        &sync.WaitGroup{},
+	}
+
+	return sess.run()
+}
+
+func (sess *session) run() error {
+	ctx, cancel := context.WithCancel(sess.stream.Context())
+	defer cancel()
+
+	writeErr := make(chan error, 1)
+
+	go func() {
+		writeErr <- sess.write(ctx)
+	}()
+
+	readErr := sess.read(ctx)
+
+	cancel()
+	sess.wg.Wait()
+
+	if readErr != nil {
+		return readErr
+	}
+
+	err := <-writeErr
+	if errors.Is(err, context.Canceled) {
+		return nil
+	}
+
+	return err
+}
+
+func (sess *session) write(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case frame := <-sess.out:
+			err := sess.stream.Send(frame)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// push - постановка кадра в очередь, false если сессия завершена
+func (sess *session) push(ctx context.Context, frame *gen.ServerFrame) bool {
+	select {
+	case sess.out <- frame:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (sess *session) read(ctx context.Context) error {
+	for {
+		frame, err := sess.stream.Recv()
+		if err != nil {
+			if ctx.Err() != nil || status.Code(err) == codes.Canceled {
+				return nil
+			}
+
+			return err
+		}
+
+		switch f := frame.GetFrame().(type) {
+		case *gen.ClientFrame_Subscribe:
+			sess.subscribe(ctx, frame.GetRequestId(), f.Subscribe)
+		case *gen.ClientFrame_Unsubscribe:
+			sess.unsubscribe(ctx, frame.GetRequestId(), f.Unsubscribe.GetChannel())
+		case *gen.ClientFrame_Send:
+			// Отправка может ждать сервер федерации, поэтому не задерживает чтение остальных кадров
+			sess.wg.Add(1)
+
+			go func() {
+				defer sess.wg.Done()
+
+				sess.send(ctx, frame.GetRequestId(), f.Send)
+			}()
+		case *gen.ClientFrame_Ack:
+			sess.ack(sess.server.readChat(sess.login, f.Ack.GetChannel()), f.Ack.GetSeq())
+		case *gen.ClientFrame_Typing:
+			sess.typing(f.Typing.GetChannel())
+		case *gen.ClientFrame_Presence:
+			sess.presence(f.Presence)
+		default:
+			sess.fail(ctx, frame.GetRequestId(), "", status.Error(codes.InvalidArgument, "unknown frame"))
+		}
+	}
+}
+
+func (sess *session) subscribe(ctx context.Context, requestID uint64, req *gen.SubscribeFrame) {
+	chat, err := sess.server.subscribeChat(sess.login, req.GetChannel())
+	if err != nil {
+		sess.fail(ctx, requestID, req.GetChannel(), err)
+
+		return
+	}
+
+	sess.subsMutex.Lock()
+	defer sess.subsMutex.Unlock()
+
+	if _, ok := sess.subs[chat]; ok {
+		sess.fail(ctx, requestID, req.GetChannel(), status.Error(codes.AlreadyExists, "already subscribed"))
+
+		return
+	}
+
+	// Без курсора чтение продолжается с последнего подтвержденного сообщения
+	since := req.Since
+	if since == nil {
+		if seq, ok := sess.acks[chat]; ok {
+			since = &seq
+		}
+	}
+
+	subCtx, cancel := context.WithCancel(ctx)
+	sess.subs[chat] = cancel
+
+	// Имя канала в кадрах такое же как в запросе клиента
+	channel := req.GetChannel()
+
+	sess.wg.Add(1)
+
+	go func() {
+		defer sess.wg.Done()
+
+		err := sess.server.listen(subCtx, chat, sess.login, sess.key, since, listener{
+			ready: func() error {
+				sess.push(subCtx, &gen.ServerFrame{
+					RequestId: requestID,
+					Frame:     &gen.ServerFrame_Subscribed{Subscribed: &gen.SubscribedFrame{Channel: channel}},
+				})
+
+				return nil
+			},
+			send: func(msg entities.Message) error {
+				if !sess.push(subCtx, &gen.ServerFrame{
+					Frame: &gen.ServerFrame_Message{Message: &gen.ChannelMessage{
+						Channel: channel,
+						Message: messageToResponse(msg),
+					}},
+				}) {
+					return subCtx.Err()
+				}
+
+				return nil
+			},
+			change: func(msg entities.Message) error {
+				if !sess.push(subCtx, &gen.ServerFrame{
+					Frame: &gen.ServerFrame_Changed{Changed: &gen.ChannelMessage{
+						Channel: channel,
+						Message: messageToResponse(msg),
+					}},
+				}) {
+					return subCtx.Err()
+				}
+
+				return nil
+			},
+			event: func(event entities.Event) error {
+				if !sess.push(subCtx, eventToFrame(channel, event)) {
+					return subCtx.Err()
+				}
+
+				return nil
+			},
+			member: true,
+		})
+
+		sess.subsMutex.Lock()
+		// Подписка могла быть заменена после отписки
+		if subCtx.Err() == nil {
+			delete(sess.subs, chat)
+		}
+		sess.subsMutex.Unlock()
+
+		cancel()
+
+		if err != nil && ctx.Err() == nil {
+			sess.fail(ctx, requestID, channel, err)
+		}
+	}()
+}
+
+func (sess *session) unsubscribe(ctx context.Context, requestID uint64, channel string) {
+	chat := sess.server.readChat(sess.login, channel)
+
+	sess.subsMutex.Lock()
+	cancel, ok := sess.subs[chat]
+	delete(sess.subs, chat)
+	delete(sess.acks, chat)
+	sess.subsMutex.Unlock()
+
+	if !ok {
+		sess.fail(ctx, requestID, channel, status.Error(codes.NotFound, "not subscribed"))
+
+		return
+	}
+
+	// Отписка - явный выход из канала, в отличие от обрыва сессии
+	sess.server.setPresence(chat, sess.key, entities.PresenceLeft)
+
+	cancel()
+
+	sess.server.logger.Info("user left", "chan", chat, "user", sess.login)
+
+	sess.push(ctx, &gen.ServerFrame{
+		RequestId: requestID,
+		Frame:     &gen.ServerFrame_Unsubscribed{Unsubscribed: &gen.UnsubscribedFrame{Channel: channel}},
+	})
+}
+
+func (sess *session) send(ctx context.Context, requestID uint64, req *gen.SendMessageRequest) {
+	res, err := sess.server.SendMessage(ctx, req)
+	if err != nil {
+		sess.fail(ctx, requestID, req.GetChannel(), err)
+
+		return
+	}
+
+	sess.push(ctx, &gen.ServerFrame{
+		RequestId: requestID,
+		Frame:     &gen.ServerFrame_Sent{Sent: res},
+	})
+}
+
+// typing - в личной переписке набор показывается в ящике собеседника
+func (sess *session) typing(channel string) {
+	peer, ok := entities.DirectPeer(channel)
+	if !ok {
+		sess.event(entities.Event{
+			Kind: entities.EventTyping,
+			Chat: sess.server.localChannel(channel),
+		})
+
+		return
+	}
+
+	if peer == "" || peer == sess.login {
+		return
+	}
+
+	sess.server.broadcastEvent(entities.Event{
+		Kind: entities.EventTyping,
+		Chat: inbox(peer),
+		User: sess.login,
+		TS:   time.Now(),
+	})
+}
+
+// event - эфемерные события рассылаются только в каналы на которые подписана сессия
+func (sess *session) event(event entities.Event) {
+	sess.subsMutex.Lock()
+	_, ok := sess.subs[event.Chat]
+	sess.subsMutex.Unlock()
+
+	if !ok {
+		return
+	}
+
+	event.User = sess.login
+	event.TS = time.Now()
+
+	sess.server.broadcastEvent(event)
+}
+
+// presence - статус без канала относится ко всем подпискам сессии
+func (sess *session) presence(req *gen.PresenceFrame) {
+	presence := presenceFromProto(req.GetStatus())
+
+	// Вход и выход из канала определяются подпиской
+	if presence != entities.PresenceOnline && presence != entities.PresenceAway {
+		return
+	}
+
+	chats := []string{sess.server.readChat(sess.login, req.GetChannel())}
+
+	if req.GetChannel() == "" {
+		sess.subsMutex.Lock()
+
+		chats = make([]string, 0, len(sess.subs))
+		for chat := range sess.subs {
+			chats = append(chats, chat)
+		}
+
+		sess.subsMutex.Unlock()
+	}
+
+	for _, chat := range chats {
+		sess.subsMutex.Lock()
+		_, ok := sess.subs[chat]
+		sess.subsMutex.Unlock()
+
+		if ok {
+			sess.server.setPresence(chat, sess.key, presence)
+		}
+	}
+}
+
+func (sess *session) fail(ctx context.Context, requestID uint64, channel string, err error) {
+	st, ok := status.FromError(err)
+	if !ok {
+		st = status.New(codes.Internal, err.Error())
+	}
+
+	sess.push(ctx, &gen.ServerFrame{
+		RequestId: requestID,
+		Frame: &gen.ServerFrame_Error{Error: &gen.ErrorFrame{
+			Code:    uint32(st.Code()),
+			Message: st.Message(),
+			Channel: channel,
+		}},
+	})
+}
+
+// ack - запоминает последнее подтвержденное клиентом сообщение канала
+func (sess *session) ack(chat string, seq uint64) {
+	sess.subsMutex.Lock()
+	defer sess.subsMutex.Unlock()
+
+	if seq > sess.acks[chat] {
+		sess.acks[chat] = seq
+	}
+}
+
+func eventToFrame(channel string, event entities.Event) *gen.ServerFrame {
+	switch event.Kind {
+	case entities.EventPresence:
+		return &gen.ServerFrame{Frame: &gen.ServerFrame_Presence{Presence: &gen.PresenceFrame{
+			Channel: channel,
+			Login:   event.User,
+			Status:  presenceToProto(event.Presence),
+		}}}
+	default:
+		return &gen.ServerFrame{Frame: &gen.ServerFrame_Typing{Typing: &gen.TypingFrame{
+			Channel: channel,
+			Login:   event.User,
+		}}}
+	}
+}
+
+func presenceFromProto(status gen.PresenceStatus) entities.PresenceStatus {
+	switch status {
+	case gen.PresenceStatus_PRESENCE_AWAY:
+		return entities.PresenceAway
+	case gen.PresenceStatus_PRESENCE_LEFT:
+		return entities.PresenceLeft
+	case gen.PresenceStatus_PRESENCE_JOINED:
+		return entities.PresenceJoined
+	case gen.PresenceStatus_PRESENCE_OFFLINE:
+		return entities.PresenceOffline
+	default:
+		return entities.PresenceOnline
+	}
+}
+
+func presenceToProto(status entities.PresenceStatus) gen.PresenceStatus {
+	switch status {
+	case entities.PresenceAway:
+		return gen.PresenceStatus_PRESENCE_AWAY
+	case entities.PresenceLeft:
+		return gen.PresenceStatus_PRESENCE_LEFT
+	case entities.PresenceJoined:
+		return gen.PresenceStatus_PRESENCE_JOINED
+	case entities.PresenceOffline:
+		return gen.PresenceStatus_PRESENCE_OFFLINE
+	default:
+		return gen.PresenceStatus_PRESENCE_ONLINE
+	}
+}
@@ -0,0 +1,194 @@
+package server_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gbh007/p2p-chat/internal/auth"
+	"github.com/gbh007/p2p-chat/internal/ulid"
+	"github.com/gbh007/p2p-chat/proto/gen"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/proto"
+)
+
+// startGRPC - сервер на свободном порту localhost с проверкой токенов как в cmd/server
+func (ts *testServer) startGRPC(t *testing.T) string {
+	t.Helper()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	grpcServer := grpc.NewServer(
+		grpc.UnaryInterceptor(auth.UnaryServerInterceptor(ts.accounts)),
+		grpc.StreamInterceptor(auth.StreamServerInterceptor(ts.accounts)),
+	)
+	gen.RegisterServerServer(grpcServer, ts.Server)
+
+	go func() {
+		_ = grpcServer.Serve(lis)
+	}()
+
+	t.Cleanup(grpcServer.Stop)
+
+	return lis.Addr().String()
+}
+
+type testSession struct {
+	t      *testing.T
+	stream grpc.BidiStreamingClient[gen.ClientFrame, gen.ServerFrame]
+}
+
+func (ts *testServer) session(t *testing.T, addr, login string) *testSession {
+	t.Helper()
+
+	token, _, err := ts.accounts.Login(context.Background(), login, testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	creds := auth.NewTokenCredentials()
+	creds.SetToken(token)
+
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithPerRPCCredentials(creds))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { _ = conn.Close() })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+
+	stream, err := gen.NewServerClient(conn).Session(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Заголовки приходят после установки сессии на сервере
+	_, err = stream.Header()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testSession{t: t, stream: stream}
+}
+
+func (s *testSession) send(frame *gen.ClientFrame) {
+	s.t.Helper()
+
+	err := s.stream.Send(frame)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+}
+
+func (s *testSession) recv() *gen.ServerFrame {
+	s.t.Helper()
+
+	frame, err := s.stream.Recv()
+	if err != nil {
+		s.t.Fatal(err)
+	}
+
+	return frame
+}
+
+func TestSessionRequestIDs(t *testing.T) {
+	ts := newServer(t)
+	ts.register(t, "alice", nil)
+	sess := ts.session(t, ts.startGRPC(t), "alice")
+
+	id := ulid.New()
+
+	sess.send(&gen.ClientFrame{RequestId: 1, Frame: &gen.ClientFrame_Subscribe{Subscribe: &gen.SubscribeFrame{Channel: "room"}}})
+
+	if frame := sess.recv(); frame.GetRequestId() != 1 || frame.GetSubscribed().GetChannel() != "room" {
+		t.Fatalf("unexpected frame: %v", frame)
+	}
+
+	// Ответы на запросы приходят в любом порядке и различаются по request_id
+	sess.send(&gen.ClientFrame{RequestId: 2, Frame: &gen.ClientFrame_Subscribe{Subscribe: &gen.SubscribeFrame{Channel: "room"}}})
+	sess.send(&gen.ClientFrame{RequestId: 3, Frame: &gen.ClientFrame_Unsubscribe{Unsubscribe: &gen.UnsubscribeFrame{Channel: "other"}}})
+	sess.send(&gen.ClientFrame{RequestId: 4, Frame: &gen.ClientFrame_Send{Send: &gen.SendMessageRequest{Channel: "room", Id: "bad", Message: "hello"}}})
+	sess.send(&gen.ClientFrame{RequestId: 5, Frame: &gen.ClientFrame_Send{Send: &gen.SendMessageRequest{Channel: "room", Id: id, Message: "hello"}}})
+
+	want := map[uint64]func(*gen.ServerFrame) bool{
+		2: func(f *gen.ServerFrame) bool { return f.GetError().GetCode() == uint32(codes.AlreadyExists) },
+		3: func(f *gen.ServerFrame) bool { return f.GetError().GetCode() == uint32(codes.NotFound) },
+		4: func(f *gen.ServerFrame) bool { return f.GetError().GetCode() == uint32(codes.InvalidArgument) },
+		5: func(f *gen.ServerFrame) bool { return f.GetSent().GetId() == id },
+	}
+
+	var message *gen.ChannelMessage
+
+	for len(want) > 0 || message == nil {
+		frame := sess.recv()
+
+		if frame.GetMessage() != nil {
+			message = frame.GetMessage()
+
+			continue
+		}
+
+		check, ok := want[frame.GetRequestId()]
+		if !ok || !check(frame) {
+			t.Fatalf("unexpected frame: %v", frame)
+		}
+
+		delete(want, frame.GetRequestId())
+	}
+
+	if message.GetChannel() != "room" || message.GetMessage().GetId() != id {
+		t.Fatalf("unexpected message: %v", message)
+	}
+}
+
+func TestSessionAcks(t *testing.T) {
+	ts := newServer(t)
+	ts.register(t, "alice", nil)
+	addr := ts.startGRPC(t)
+
+	for range 3 {
+		ts.send(t, "alice", "room", "hello")
+	}
+
+	first := ts.session(t, addr, "alice")
+	first.send(&gen.ClientFrame{RequestId: 1, Frame: &gen.ClientFrame_Subscribe{Subscribe: &gen.SubscribeFrame{Channel: "room", Since: proto.Uint64(0)}}})
+
+	for received := 0; received < 3; {
+		if first.recv().GetMessage() != nil {
+			received++
+		}
+	}
+
+	first.send(&gen.ClientFrame{Frame: &gen.ClientFrame_Ack{Ack: &gen.AckFrame{Channel: "room", Seq: 2}}})
+
+	// Подтверждения одной сессии не сдвигают чтение другой сессии того же пользователя
+	second := ts.session(t, addr, "alice")
+	second.send(&gen.ClientFrame{RequestId: 1, Frame: &gen.ClientFrame_Subscribe{Subscribe: &gen.SubscribeFrame{Channel: "room"}}})
+
+	if second.recv().GetSubscribed() == nil {
+		t.Fatal("expected subscribed frame")
+	}
+
+	id := ts.send(t, "alice", "room", "new")
+
+	for {
+		msg := second.recv().GetMessage()
+		if msg == nil {
+			continue
+		}
+
+		if msg.GetMessage().GetId() != id {
+			t.Fatalf("session replayed acks of another session: %v", msg)
+		}
+
+		break
+	}
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"context"
+	"errors"
+
+	"github.com/gbh007/p2p-chat/internal/entities"
+	"github.com/gbh007/p2p-chat/proto/gen"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// GetThread - сообщение и ответы на него в порядке отправки
+func (s *Server) GetThread(ctx context.Context, req *gen.GetThreadRequest) (*gen.GetThreadResponse, error) {
+	login, err := loginFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.GetChannel() == "" || req.GetChannel() == inbox("") {
+		return nil, status.Error(codes.InvalidArgument, "empty channel")
+	}
+
+	chat := s.readChat(login, req.GetChannel())
+
+	root, err := s.findMessage(ctx, chat, req.GetId())
+	if err != nil {
+		return nil, err
+	}
+
+	// Для длинных тредов возвращаются последние ответы
+	messages, err := s.store.Replies(ctx, chat, root.ID, s.limits.MaxHistory)
+	if err != nil {
+		s.logger.Error("get thread", "chan", chat, "error", err)
+		return nil, status.Error(codes.Internal, "get thread")
+	}
+
+	replies := make([]*gen.ReadMessagesResponse, 0, len(messages))
+
+	for _, msg := range messages {
+		replies = append(replies, messageToResponse(msg))
+	}
+
+	return &gen.GetThreadResponse{
+		Root:    messageToResponse(root),
+		Replies: replies,
+	}, nil
+}
+
+// checkReply - ответить можно только на существующее сообщение того же чата,
+// в личной переписке - только на сообщение той же переписки
+func (s *Server) checkReply(ctx context.Context, login string, msg entities.Message) error {
+	if msg.ReplyTo == "" {
+		return nil
+	}
+
+	if len(msg.ReplyTo) != messageIDLen {
+		return status.Error(codes.InvalidArgument, "invalid reply message id")
+	}
+
+	// История канала другого сервера может отставать, проверку выполнит сервер канала
+	if s.isRemote(msg.Chat) {
+		return nil
+	}
+
+	chat := msg.Chat
+	if msg.To != "" {
+		chat = inbox(login)
+	}
+
+	target, err := s.store.MessageByID(ctx, chat, msg.ReplyTo)
+	if errors.Is(err, entities.ErrNotFound) {
+		return status.Error(codes.InvalidArgument, "reply target not found")
+	}
+
+	if err != nil {
+		s.logger.Error("find message", "chan", chat, "error", err)
+		return status.Error(codes.Internal, "find message")
+	}
+
+	if msg.To != "" && directPeer(login, target) != msg.To {
+		return status.Error(codes.InvalidArgument, "reply target not found")
+	}
+
+	return nil
+}
@@ -0,0 +1,284 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gbh007/p2p-chat/internal/entities"
+)
+
+const (
+	usersFileName    = "users.log"
+	sessionsFileName = "sessions.log"
+)
+
+type userRecord struct {
+	Login        string    `json:"login"`
+	PasswordHash string    `json:"password_hash"`
+	PublicKey    []byte    `json:"public_key,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+
+	EncryptionKey          []byte `json:"encryption_key,omitempty"`
+	EncryptionKeySignature []byte `json:"encryption_key_signature,omitempty"`
+}
+
+type sessionRecord struct {
+	TokenHash string    `json:"token_hash"`
+	Login     string    `json:"login"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func (f *File) AddUser(_ context.Context, user entities.User) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if _, ok := f.users[user.Login]; ok {
+		return entities.ErrAlreadyExists
+	}
+
+	err := f.writeUser(user)
+	if err != nil {
+		return err
+	}
+
+	f.users[user.Login] = user
+
+	return nil
+}
+
+func (f *File) SetEncryptionKey(_ context.Context, login string, key, signature []byte) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	user, ok := f.users[login]
+	if !ok {
+		return entities.ErrNotFound
+	}
+
+	user.EncryptionKey = key
+	user.EncryptionKeySignature = signature
+
+	// Лог пользователей дописывается, при загрузке последняя запись заменяет предыдущие
+	err := f.writeUser(user)
+	if err != nil {
+		return err
+	}
+
+	f.users[login] = user
+
+	return nil
+}
+
+func (f *File) writeUser(user entities.User) error {
+	return appendRecord(f.usersFile, userRecord{
+		Login:        user.Login,
+		PasswordHash: user.PasswordHash,
+		PublicKey:    user.PublicKey,
+		CreatedAt:    user.CreatedAt,
+
+		EncryptionKey:          user.EncryptionKey,
+		EncryptionKeySignature: user.EncryptionKeySignature,
+	})
+}
+
+func (f *File) GetUser(_ context.Context, login string) (entities.User, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	user, ok := f.users[login]
+	if !ok {
+		return entities.User{}, entities.ErrNotFound
+	}
+
+	return user, nil
+}
+
+func (f *File) AddSession(_ context.Context, session entities.Session) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	err := appendRecord(f.sessionsFile, sessionRecord{
+		TokenHash: session.TokenHash,
+		Login:     session.Login,
+		ExpiresAt: session.ExpiresAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	f.sessions[session.TokenHash] = session
+
+	return nil
+}
+
+func (f *File) GetSession(_ context.Context, tokenHash string) (entities.Session, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	session, ok := f.sessions[tokenHash]
+	if !ok {
+		return entities.Session{}, entities.ErrNotFound
+	}
+
+	return session, nil
+}
+
+// loadAccounts - читает пользователей и сессии, открывает их логи на запись
+func (f *File) loadAccounts() error {
+	now := time.Now()
+
+	err := readRecords(filepath.Join(f.dir, usersFileName), func(rec userRecord) {
+		f.users[rec.Login] = entities.User{
+			Login:        rec.Login,
+			PasswordHash: rec.PasswordHash,
+			PublicKey:    rec.PublicKey,
+			CreatedAt:    rec.CreatedAt,
+
+			EncryptionKey:          rec.EncryptionKey,
+			EncryptionKeySignature: rec.EncryptionKeySignature,
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("users: %w", err)
+	}
+
+	err = readRecords(filepath.Join(f.dir, sessionsFileName), func(rec sessionRecord) {
+		if rec.ExpiresAt.Before(now) {
+			return
+		}
+
+		f.sessions[rec.TokenHash] = entities.Session{
+			TokenHash: rec.TokenHash,
+			Login:     rec.Login,
+			ExpiresAt: rec.ExpiresAt,
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("sessions: %w", err)
+	}
+
+	f.usersFile, err = openLog(filepath.Join(f.dir, usersFileName))
+	if err != nil {
+		return fmt.Errorf("users: %w", err)
+	}
+
+	f.sessionsFile, err = openLog(filepath.Join(f.dir, sessionsFileName))
+	if err != nil {
+		return fmt.Errorf("sessions: %w", err)
+	}
+
+	return nil
+}
+
+// openLog - открытие лога на дозапись, недописанная последняя строка отрезается,
+// иначе следующая запись склеится с ней и тоже будет потеряна
+func openLog(path string) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_RDWR, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("open: %w", err)
+	}
+
+	err = truncateTorn(file)
+	if err != nil {
+		_ = file.Close()
+
+		return nil, err
+	}
+
+	return file, nil
+}
+
+// truncateTorn - отрезание хвоста лога после последнего перевода строки
+func truncateTorn(file *os.File) error {
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("stat: %w", err)
+	}
+
+	end := info.Size()
+	buf := make([]byte, 4096)
+
+	for end > 0 {
+		start := max(end-int64(len(buf)), 0)
+		chunk := buf[:end-start]
+
+		_, err = file.ReadAt(chunk, start)
+		if err != nil {
+			return fmt.Errorf("read: %w", err)
+		}
+
+		if i := bytes.LastIndexByte(chunk, '\n'); i >= 0 {
+			end = start + int64(i) + 1
+
+			break
+		}
+
+		end = start
+	}
+
+	if end == info.Size() {
+		return nil
+	}
+
+	err = file.Truncate(end)
+	if err != nil {
+		return fmt.Errorf("truncate: %w", err)
+	}
+
+	return nil
+}
+
+func appendRecord(file *os.File, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("marshal: %w", err)
+	}
+
+	_, err = file.Write(append(data, '\n'))
+	if err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+
+	return nil
+}
+
+func readRecords[T any](path string, handle func(T)) error {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("open: %w", err)
+	}
+
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxRecordSize)
+
+	for scanner.Scan() {
+		var rec T
+
+		// Последняя строка может быть недописана при аварийном завершении
+		if json.Unmarshal(scanner.Bytes(), &rec) != nil {
+			continue
+		}
+
+		handle(rec)
+	}
+
+	err = scanner.Err()
+	if err != nil {
+		return fmt.Errorf("read: %w", err)
+	}
+
+	return nil
+}
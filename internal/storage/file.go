@@ -0,0 +1,572 @@
+package storage
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gbh007/p2p-chat/internal/entities"
+)
+
+// maxRecordSize - наибольшая запись лога, более длинные строки при открытии считаются поврежденными,
+// поэтому такие записи не пишутся
+const maxRecordSize = 1024 * 1024
+
+type record struct {
+	ID     string    `json:"id,omitempty"`
+	Seq    uint64    `json:"seq"`
+	User   string    `json:"user"`
+	Domain string    `json:"domain,omitempty"`
+	To     string    `json:"to,omitempty"`
+	Text   string    `json:"text"`
+	TS     time.Time `json:"ts"`
+
+	Signature []byte `json:"signature,omitempty"`
+	PublicKey []byte `json:"public_key,omitempty"`
+
+	Ciphertext []byte `json:"ciphertext,omitempty"`
+	KeyEpoch   uint32 `json:"key_epoch,omitempty"`
+
+	ReplyTo string               `json:"reply_to,omitempty"`
+	Kind    entities.MessageKind `json:"kind,omitempty"`
+
+	Edited    *time.Time       `json:"edited,omitempty"`
+	ChangeSeq uint64           `json:"change_seq,omitempty"`
+	Deleted   bool             `json:"deleted,omitempty"`
+	Reactions []reactionRecord `json:"reactions,omitempty"`
+
+	// Update - запись изменения, заменяет ранее записанное сообщение с тем же идентификатором
+	Update bool `json:"update,omitempty"`
+}
+
+type reactionRecord struct {
+	Emoji string   `json:"emoji"`
+	Users []string `json:"users"`
+}
+
+type File struct {
+	dir string
+
+	// logs - открытые логи каналов с индексом сообщений, все обращения к ним под блокировкой
+	logs  map[string]*chatLog
+	mutex *sync.Mutex
+
+	users        map[string]entities.User
+	sessions     map[string]entities.Session
+	usersFile    *os.File
+	sessionsFile *os.File
+
+	keys     channelKeys
+	keysFile *os.File
+}
+
+// chatLog - лог канала и индекс его сообщений, строится один раз при открытии лога,
+// сами сообщения читаются с диска по смещению
+type chatLog struct {
+	file *os.File
+	// size - конец последней полной записи, следующая запись пишется с него
+	size int64
+
+	// entries - сообщения в порядке номеров, смещение указывает на последнюю версию сообщения
+	entries []logEntry
+	// ids - идентификатор сообщения -> индекс в entries
+	ids map[string]int
+	// replies - идентификатор сообщения -> индексы ответов на него в entries
+	replies map[string][]int
+	// changes - индексы измененных сообщений в порядке номеров изменений,
+	// сообщение измененное повторно встречается несколько раз
+	changes []logChange
+	last    uint64
+}
+
+type logEntry struct {
+	seq       uint64
+	changeSeq uint64
+	offset    int64
+	size      int
+}
+
+type logChange struct {
+	changeSeq uint64
+	index     int
+}
+
+func NewFile(dir string) (*File, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, fmt.Errorf("create dir: %w", err)
+	}
+
+	f := &File{
+		dir:      dir,
+		logs:     make(map[string]*chatLog),
+		mutex:    &sync.Mutex{},
+		users:    make(map[string]entities.User),
+		sessions: make(map[string]entities.Session),
+		keys:     make(channelKeys),
+	}
+
+	err = f.loadAccounts()
+	if err != nil {
+		return nil, err
+	}
+
+	err = f.loadKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+func (f *File) AddMessage(_ context.Context, msg entities.Message) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	log, err := f.log(msg.Chat)
+	if err != nil {
+		return err
+	}
+
+	return log.append(messageRecord(msg))
+}
+
+// UpdateMessage - изменение дописывается в лог отдельной записью, индекс указывает на нее вместо исходного сообщения
+func (f *File) UpdateMessage(_ context.Context, msg entities.Message) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	log, err := f.log(msg.Chat)
+	if err != nil {
+		return err
+	}
+
+	i, ok := log.ids[msg.ID]
+	if !ok {
+		return entities.ErrNotFound
+	}
+
+	msg.Seq = log.entries[i].seq
+
+	rec := messageRecord(msg)
+	rec.Update = true
+
+	return log.append(rec)
+}
+
+func (f *File) LastSeq(_ context.Context, chat string) (uint64, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	log, err := f.log(chat)
+	if err != nil {
+		return 0, err
+	}
+
+	return log.last, nil
+}
+
+// MessagesAfter - см. changedAfter, кандидаты отбираются по индексу, с диска читаются только попавшие в limit
+func (f *File) MessagesAfter(_ context.Context, chat string, after uint64, limit int) ([]entities.Message, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	log, err := f.log(chat)
+	if err != nil {
+		return nil, err
+	}
+
+	start := log.firstAfter(after)
+	indexes := make([]int, 0)
+	seen := make(map[int]struct{})
+
+	// Изменения идут по возрастанию номеров, поэтому достаточно пройти с конца до after
+	for i := len(log.changes) - 1; i >= 0 && log.changes[i].changeSeq > after; i-- {
+		index := log.changes[i].index
+		if _, ok := seen[index]; ok || index >= start || log.entries[index].changeSeq <= after {
+			continue
+		}
+
+		seen[index] = struct{}{}
+		indexes = append(indexes, index)
+	}
+
+	slices.Reverse(indexes)
+
+	for i := start; i < len(log.entries); i++ {
+		indexes = append(indexes, i)
+	}
+
+	position := func(index int) uint64 {
+		if log.entries[index].seq > after {
+			return log.entries[index].seq
+		}
+
+		return log.entries[index].changeSeq
+	}
+
+	slices.SortStableFunc(indexes, func(a, b int) int {
+		return cmp.Compare(position(a), position(b))
+	})
+
+	if limit > 0 && len(indexes) > limit {
+		indexes = indexes[:limit]
+	}
+
+	return log.messages(chat, indexes)
+}
+
+func (f *File) MessagesBefore(_ context.Context, chat string, before uint64, limit int) ([]entities.Message, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	log, err := f.log(chat)
+	if err != nil {
+		return nil, err
+	}
+
+	end := len(log.entries)
+	if before > 0 {
+		end = log.firstAfter(before - 1)
+	}
+
+	start := 0
+	if limit > 0 && end-limit > 0 {
+		start = end - limit
+	}
+
+	indexes := make([]int, 0, end-start)
+
+	for i := start; i < end; i++ {
+		indexes = append(indexes, i)
+	}
+
+	return log.messages(chat, indexes)
+}
+
+func (f *File) MessageByID(_ context.Context, chat, id string) (entities.Message, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	log, err := f.log(chat)
+	if err != nil {
+		return entities.Message{}, err
+	}
+
+	i, ok := log.ids[id]
+	if !ok {
+		return entities.Message{}, entities.ErrNotFound
+	}
+
+	return log.message(chat, i)
+}
+
+// Replies - ответы находятся по индексу, с диска читаются только последние limit
+func (f *File) Replies(_ context.Context, chat, id string, limit int) ([]entities.Message, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	log, err := f.log(chat)
+	if err != nil {
+		return nil, err
+	}
+
+	indexes := log.replies[id]
+	if limit > 0 && len(indexes) > limit {
+		indexes = indexes[len(indexes)-limit:]
+	}
+
+	return log.messages(chat, indexes)
+}
+
+func (f *File) Close() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	var resultErr error
+
+	for chat, log := range f.logs {
+		err := log.file.Close()
+		if err != nil && resultErr == nil {
+			resultErr = fmt.Errorf("close %s: %w", chat, err)
+		}
+
+		delete(f.logs, chat)
+	}
+
+	for _, file := range []*os.File{f.usersFile, f.sessionsFile, f.keysFile} {
+		err := file.Close()
+		if err != nil && resultErr == nil {
+			resultErr = fmt.Errorf("close %s: %w", file.Name(), err)
+		}
+	}
+
+	return resultErr
+}
+
+// log - возвращает лог канала, при первом обращении строит его индекс, вызывать только под блокировкой
+func (f *File) log(chat string) (*chatLog, error) {
+	log, ok := f.logs[chat]
+	if ok {
+		return log, nil
+	}
+
+	log, err := openChatLog(f.path(chat))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", chat, err)
+	}
+
+	f.logs[chat] = log
+
+	return log, nil
+}
+
+// openChatLog - открытие лога и построение индекса,
+// недописанная при аварийном завершении последняя строка отрезается, чтобы не склеиться со следующей записью
+func openChatLog(path string) (*chatLog, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("open: %w", err)
+	}
+
+	log := &chatLog{
+		file:    file,
+		ids:     make(map[string]int),
+		replies: make(map[string][]int),
+	}
+
+	reader := bufio.NewReader(file)
+
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			_ = file.Close()
+
+			return nil, fmt.Errorf("read: %w", err)
+		}
+
+		var rec record
+
+		// Поврежденные полные строки пропускаются, как и при чтении других логов
+		if len(line) <= maxRecordSize && json.Unmarshal(line, &rec) == nil {
+			log.index(rec, log.size, len(line))
+		}
+
+		log.size += int64(len(line))
+	}
+
+	err = file.Truncate(log.size)
+	if err != nil {
+		_ = file.Close()
+
+		return nil, fmt.Errorf("truncate: %w", err)
+	}
+
+	return log, nil
+}
+
+// append - запись в конец лога и в индекс
+func (l *chatLog) append(rec record) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("marshal: %w", err)
+	}
+
+	data = append(data, '\n')
+
+	if len(data) > maxRecordSize {
+		return fmt.Errorf("record of %d bytes: %w", len(data), entities.ErrTooLarge)
+	}
+
+	// Недописанная запись будет перезаписана следующей, поэтому размер меняется только после успешной записи
+	_, err = l.file.WriteAt(data, l.size)
+	if err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+
+	l.index(rec, l.size, len(data))
+	l.size += int64(len(data))
+
+	return nil
+}
+
+// index - учет записи лога, записанной по смещению offset
+func (l *chatLog) index(rec record, offset int64, size int) {
+	if rec.Update {
+		i, ok := l.ids[rec.ID]
+		if !ok {
+			return
+		}
+
+		l.entries[i].changeSeq = rec.ChangeSeq
+		l.entries[i].offset = offset
+		l.entries[i].size = size
+
+		if rec.ChangeSeq > 0 {
+			l.changes = append(l.changes, logChange{changeSeq: rec.ChangeSeq, index: i})
+			l.last = max(l.last, rec.ChangeSeq)
+		}
+
+		return
+	}
+
+	seq := rec.Seq
+
+	// Записи ранних версий хранились без номера
+	if seq == 0 {
+		seq = 1
+
+		if n := len(l.entries); n > 0 {
+			seq = l.entries[n-1].seq + 1
+		}
+	}
+
+	if rec.ID != "" {
+		l.ids[rec.ID] = len(l.entries)
+	}
+
+	if rec.ReplyTo != "" {
+		l.replies[rec.ReplyTo] = append(l.replies[rec.ReplyTo], len(l.entries))
+	}
+
+	l.entries = append(l.entries, logEntry{
+		seq:       seq,
+		changeSeq: rec.ChangeSeq,
+		offset:    offset,
+		size:      size,
+	})
+	l.last = max(l.last, seq, rec.ChangeSeq)
+}
+
+// firstAfter - индекс первого сообщения с номером больше seq
+func (l *chatLog) firstAfter(seq uint64) int {
+	i, _ := slices.BinarySearchFunc(l.entries, seq+1, func(entry logEntry, target uint64) int {
+		return cmp.Compare(entry.seq, target)
+	})
+
+	return i
+}
+
+func (l *chatLog) messages(chat string, indexes []int) ([]entities.Message, error) {
+	result := make([]entities.Message, 0, len(indexes))
+
+	for _, i := range indexes {
+		msg, err := l.message(chat, i)
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, msg)
+	}
+
+	return result, nil
+}
+
+// message - чтение последней версии сообщения по смещению из индекса
+func (l *chatLog) message(chat string, i int) (entities.Message, error) {
+	entry := l.entries[i]
+	data := make([]byte, entry.size)
+
+	_, err := l.file.ReadAt(data, entry.offset)
+	if err != nil {
+		return entities.Message{}, fmt.Errorf("read: %w", err)
+	}
+
+	var rec record
+
+	err = json.Unmarshal(data, &rec)
+	if err != nil {
+		return entities.Message{}, fmt.Errorf("unmarshal: %w", err)
+	}
+
+	msg := rec.message(chat)
+	msg.Seq = entry.seq
+
+	return msg, nil
+}
+
+func (rec record) message(chat string) entities.Message {
+	msg := entities.Message{
+		ID:      rec.ID,
+		Chat:    chat,
+		Seq:     rec.Seq,
+		User:    rec.User,
+		Domain:  rec.Domain,
+		To:      rec.To,
+		Text:    rec.Text,
+		TS:      rec.TS,
+		Deleted: rec.Deleted,
+
+		ChangeSeq: rec.ChangeSeq,
+
+		Signature: rec.Signature,
+		PublicKey: rec.PublicKey,
+
+		Ciphertext: rec.Ciphertext,
+		KeyEpoch:   rec.KeyEpoch,
+
+		ReplyTo: rec.ReplyTo,
+		Kind:    rec.Kind,
+	}
+
+	if rec.Edited != nil {
+		msg.Edited = *rec.Edited
+	}
+
+	for _, reaction := range rec.Reactions {
+		msg.Reactions = append(msg.Reactions, entities.Reaction{Emoji: reaction.Emoji, Users: reaction.Users})
+	}
+
+	return msg
+}
+
+func messageRecord(msg entities.Message) record {
+	rec := record{
+		ID:        msg.ID,
+		Seq:       msg.Seq,
+		User:      msg.User,
+		Domain:    msg.Domain,
+		To:        msg.To,
+		Text:      msg.Text,
+		TS:        msg.TS,
+		Signature: msg.Signature,
+		PublicKey: msg.PublicKey,
+		Deleted:   msg.Deleted,
+		ChangeSeq: msg.ChangeSeq,
+
+		Ciphertext: msg.Ciphertext,
+		KeyEpoch:   msg.KeyEpoch,
+
+		ReplyTo: msg.ReplyTo,
+		Kind:    msg.Kind,
+	}
+
+	if !msg.Edited.IsZero() {
+		rec.Edited = &msg.Edited
+	}
+
+	for _, reaction := range msg.Reactions {
+		rec.Reactions = append(rec.Reactions, reactionRecord{Emoji: reaction.Emoji, Users: reaction.Users})
+	}
+
+	return rec
+}
+
+func (f *File) path(chat string) string {
+	// Имя канала может содержать любые символы, поэтому кодируем его
+	return filepath.Join(f.dir, hex.EncodeToString([]byte(chat))+".log")
+}
@@ -0,0 +1,265 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gbh007/p2p-chat/internal/entities"
+)
+
+const testChat = "room"
+
+func openFile(t *testing.T, dir string) *File {
+	t.Helper()
+
+	f, err := NewFile(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { _ = f.Close() })
+
+	return f
+}
+
+// fill - одинаковая история в обоих хранилищах: сообщения, правки и удаление с номерами изменений
+func fill(t *testing.T, stores ...interface {
+	AddMessage(ctx context.Context, msg entities.Message) error
+	UpdateMessage(ctx context.Context, msg entities.Message) error
+},
+) {
+	t.Helper()
+
+	ctx := context.Background()
+	ts := time.Unix(1700000000, 0).UTC()
+	seq := uint64(0)
+
+	for i := range 10 {
+		seq++
+
+		msg := entities.Message{ID: fmt.Sprint("m", i), Chat: testChat, Seq: seq, User: "alice", Text: fmt.Sprint("text ", i), TS: ts}
+
+		// Каждое третье сообщение - ответ на первое
+		if i > 0 && i%3 == 0 {
+			msg.ReplyTo = "m0"
+		}
+
+		for _, s := range stores {
+			if err := s.AddMessage(ctx, msg); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	changes := []entities.Message{
+		{ID: "m2", Text: "edited", Edited: ts},
+		{ID: "m5", Deleted: true},
+		{ID: "m2", Text: "edited twice", Edited: ts},
+	}
+
+	for _, change := range changes {
+		seq++
+
+		change.Chat = testChat
+		change.User = "alice"
+		change.ChangeSeq = seq
+
+		for _, s := range stores {
+			if err := s.UpdateMessage(ctx, change); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestFileMatchesMemory(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	memory := NewMemory(100)
+	file := openFile(t, dir)
+
+	fill(t, memory, file)
+
+	err := file.UpdateMessage(ctx, entities.Message{ID: "unknown", Chat: testChat})
+	if !errors.Is(err, entities.ErrNotFound) {
+		t.Fatalf("update of an unknown message: %v", err)
+	}
+
+	// Индекс после повторного открытия совпадает с построенным при записи
+	reopened := openFile(t, dir)
+
+	for _, f := range []*File{file, reopened} {
+		compare(t, "last seq", memory.LastSeq, f.LastSeq)
+
+		for _, after := range []uint64{0, 3, 10, 11, 12, 13} {
+			for _, limit := range []int{0, 1, 4} {
+				compare(t, fmt.Sprint("after ", after, " limit ", limit),
+					func(ctx context.Context, chat string) ([]entities.Message, error) {
+						return memory.MessagesAfter(ctx, chat, after, limit)
+					},
+					func(ctx context.Context, chat string) ([]entities.Message, error) {
+						return f.MessagesAfter(ctx, chat, after, limit)
+					},
+				)
+			}
+		}
+
+		for _, before := range []uint64{0, 1, 5, 11} {
+			compare(t, fmt.Sprint("before ", before),
+				func(ctx context.Context, chat string) ([]entities.Message, error) {
+					return memory.MessagesBefore(ctx, chat, before, 3)
+				},
+				func(ctx context.Context, chat string) ([]entities.Message, error) {
+					return f.MessagesBefore(ctx, chat, before, 3)
+				},
+			)
+		}
+
+		for _, limit := range []int{0, 2} {
+			compare(t, fmt.Sprint("replies limit ", limit),
+				func(ctx context.Context, chat string) ([]entities.Message, error) {
+					return memory.Replies(ctx, chat, "m0", limit)
+				},
+				func(ctx context.Context, chat string) ([]entities.Message, error) {
+					return f.Replies(ctx, chat, "m0", limit)
+				},
+			)
+		}
+
+		compare(t, "by id",
+			func(ctx context.Context, chat string) (entities.Message, error) {
+				return memory.MessageByID(ctx, chat, "m2")
+			},
+			func(ctx context.Context, chat string) (entities.Message, error) {
+				return f.MessageByID(ctx, chat, "m2")
+			},
+		)
+	}
+}
+
+func TestFileTornLine(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	f := openFile(t, dir)
+	fill(t, f)
+
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Аварийное завершение посреди записи
+	tear(t, f.path(testChat), `{"id":"torn","seq":11,"te`)
+	tear(t, filepath.Join(dir, usersFileName), `{"login":"tor`)
+
+	f = openFile(t, dir)
+
+	err := f.AddMessage(ctx, entities.Message{ID: "m10", Chat: testChat, Seq: 14, User: "bob", Text: "after crash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = f.AddUser(ctx, entities.User{Login: "bob"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reopened := openFile(t, dir)
+
+	msg, err := reopened.MessageByID(ctx, testChat, "m10")
+	if err != nil || msg.Text != "after crash" || msg.Seq != 14 {
+		t.Fatalf("record after the torn line is lost: %+v %v", msg, err)
+	}
+
+	_, err = reopened.MessageByID(ctx, testChat, "torn")
+	if !errors.Is(err, entities.ErrNotFound) {
+		t.Fatalf("torn record must be dropped: %v", err)
+	}
+
+	_, err = reopened.GetUser(ctx, "bob")
+	if err != nil {
+		t.Fatalf("user after the torn line is lost: %v", err)
+	}
+}
+
+func TestFileRejectsOversizedRecord(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	f := openFile(t, dir)
+	fill(t, f)
+
+	large := strings.Repeat("x", maxRecordSize)
+
+	err := f.AddMessage(ctx, entities.Message{ID: "large", Chat: testChat, Seq: 14, User: "bob", Text: large})
+	if !errors.Is(err, entities.ErrTooLarge) {
+		t.Fatalf("oversized message must be rejected: %v", err)
+	}
+
+	err = f.UpdateMessage(ctx, entities.Message{ID: "m3", Chat: testChat, ChangeSeq: 14, User: "alice", Text: large})
+	if !errors.Is(err, entities.ErrTooLarge) {
+		t.Fatalf("oversized change must be rejected: %v", err)
+	}
+
+	err = f.AddMessage(ctx, entities.Message{ID: "m10", Chat: testChat, Seq: 14, User: "bob", Text: "after"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Все принятые записи читаются после повторного открытия, номер не идет назад
+	reopened := openFile(t, dir)
+
+	for _, store := range []*File{f, reopened} {
+		last, err := store.LastSeq(ctx, testChat)
+		if err != nil || last != 14 {
+			t.Fatalf("unexpected last seq: %d %v", last, err)
+		}
+
+		msg, err := store.MessageByID(ctx, testChat, "m3")
+		if err != nil || msg.Text != "text 3" {
+			t.Fatalf("rejected change must not replace the message: %+v %v", msg, err)
+		}
+	}
+}
+
+func tear(t *testing.T, path, partial string) {
+	t.Helper()
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer file.Close()
+
+	_, err = file.WriteString(partial)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func compare[T any](t *testing.T, name string, expected, actual func(ctx context.Context, chat string) (T, error)) {
+	t.Helper()
+
+	ctx := context.Background()
+
+	want, err := expected(ctx, testChat)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := actual(ctx, testChat)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fmt.Sprintf("%+v", want) != fmt.Sprintf("%+v", got) {
+		t.Fatalf("%s:\nwant %+v\ngot  %+v", name, want, got)
+	}
+}
@@ -0,0 +1,147 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	"github.com/gbh007/p2p-chat/internal/entities"
+)
+
+const keysFileName = "keys.log"
+
+type keyShareRecord struct {
+	Chat      string `json:"chat"`
+	Epoch     uint32 `json:"epoch"`
+	Owner     string `json:"owner"`
+	Login     string `json:"login"`
+	Key       []byte `json:"key"`
+	Signature []byte `json:"signature"`
+}
+
+// channelKeys - индекс ключей каналов в памяти, общий для всех хранилищ
+type channelKeys map[string][]entities.ChannelKeyShare
+
+func (ck channelKeys) add(shares []entities.ChannelKeyShare) {
+	for _, share := range shares {
+		ck[share.Chat] = append(ck[share.Chat], share)
+	}
+}
+
+func (ck channelKeys) forLogin(chat, login string) []entities.ChannelKeyShare {
+	result := make([]entities.ChannelKeyShare, 0)
+
+	for _, share := range ck[chat] {
+		if share.Login == login {
+			result = append(result, share)
+		}
+	}
+
+	return result
+}
+
+func (ck channelKeys) encryption(chat string) entities.ChannelEncryption {
+	var result entities.ChannelEncryption
+
+	for _, share := range ck[chat] {
+		if share.Epoch > result.Epoch {
+			result = entities.ChannelEncryption{
+				Owner: share.Owner,
+				Epoch: share.Epoch,
+			}
+		}
+	}
+
+	for _, share := range ck[chat] {
+		if share.Epoch == result.Epoch {
+			result.Members = append(result.Members, share.Login)
+		}
+	}
+
+	return result
+}
+
+func (m *Memory) AddChannelKeys(_ context.Context, shares []entities.ChannelKeyShare) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.keys.add(shares)
+
+	return nil
+}
+
+func (m *Memory) ChannelKeys(_ context.Context, chat, login string) ([]entities.ChannelKeyShare, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	return m.keys.forLogin(chat, login), nil
+}
+
+func (m *Memory) ChannelEncryption(_ context.Context, chat string) (entities.ChannelEncryption, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	return m.keys.encryption(chat), nil
+}
+
+func (f *File) AddChannelKeys(_ context.Context, shares []entities.ChannelKeyShare) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	for _, share := range shares {
+		err := appendRecord(f.keysFile, keyShareRecord{
+			Chat:      share.Chat,
+			Epoch:     share.Epoch,
+			Owner:     share.Owner,
+			Login:     share.Login,
+			Key:       share.Key,
+			Signature: share.Signature,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	f.keys.add(shares)
+
+	return nil
+}
+
+func (f *File) ChannelKeys(_ context.Context, chat, login string) ([]entities.ChannelKeyShare, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return f.keys.forLogin(chat, login), nil
+}
+
+func (f *File) ChannelEncryption(_ context.Context, chat string) (entities.ChannelEncryption, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return f.keys.encryption(chat), nil
+}
+
+func (f *File) loadKeys() error {
+	path := filepath.Join(f.dir, keysFileName)
+
+	err := readRecords(path, func(rec keyShareRecord) {
+		f.keys.add([]entities.ChannelKeyShare{{
+			Chat:      rec.Chat,
+			Epoch:     rec.Epoch,
+			Owner:     rec.Owner,
+			Login:     rec.Login,
+			Key:       rec.Key,
+			Signature: rec.Signature,
+		}})
+	})
+	if err != nil {
+		return fmt.Errorf("keys: %w", err)
+	}
+
+	f.keysFile, err = openLog(path)
+	if err != nil {
+		return fmt.Errorf("keys: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,305 @@
+package storage
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"sync"
+
+	"github.com/gbh007/p2p-chat/internal/entities"
+)
+
+type Memory struct {
+	size int
+
+	chats    map[string]*ring
+	users    map[string]entities.User
+	sessions map[string]entities.Session
+	keys     channelKeys
+	mutex    *sync.RWMutex
+}
+
+type ring struct {
+	messages []entities.Message
+	next     int
+	full     bool
+}
+
+func NewMemory(size int) *Memory {
+	return &Memory{
+		size:     size,
+		chats:    make(map[string]*ring),
+		users:    make(map[string]entities.User),
+		sessions: make(map[string]entities.Session),
+		keys:     make(channelKeys),
+		mutex:    &sync.RWMutex{},
+	}
+}
+
+func (m *Memory) AddMessage(_ context.Context, msg entities.Message) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	r, ok := m.chats[msg.Chat]
+	if !ok {
+		r = &ring{
+			messages: make([]entities.Message, m.size),
+		}
+		m.chats[msg.Chat] = r
+	}
+
+	r.messages[r.next] = msg
+	r.next = (r.next + 1) % len(r.messages)
+
+	if r.next == 0 {
+		r.full = true
+	}
+
+	return nil
+}
+
+// UpdateMessage - замена сохраненного сообщения с тем же идентификатором, номер сообщения не меняется
+func (m *Memory) UpdateMessage(_ context.Context, msg entities.Message) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	r, ok := m.chats[msg.Chat]
+	if !ok {
+		return entities.ErrNotFound
+	}
+
+	for i := range r.messages {
+		if r.messages[i].ID == msg.ID && msg.ID != "" {
+			msg.Seq = r.messages[i].Seq
+			r.messages[i] = msg
+
+			return nil
+		}
+	}
+
+	return entities.ErrNotFound
+}
+
+func (m *Memory) LastSeq(_ context.Context, chat string) (uint64, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	r, ok := m.chats[chat]
+	if !ok {
+		return 0, nil
+	}
+
+	return lastPosition(r.ordered()), nil
+}
+
+func (m *Memory) MessagesAfter(_ context.Context, chat string, after uint64, limit int) ([]entities.Message, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	r, ok := m.chats[chat]
+	if !ok {
+		return nil, nil
+	}
+
+	return changedAfter(r.ordered(), after, limit), nil
+}
+
+func (m *Memory) MessagesBefore(_ context.Context, chat string, before uint64, limit int) ([]entities.Message, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	r, ok := m.chats[chat]
+	if !ok {
+		return nil, nil
+	}
+
+	return lastBefore(r.ordered(), before, limit), nil
+}
+
+func (m *Memory) MessageByID(_ context.Context, chat, id string) (entities.Message, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	r, ok := m.chats[chat]
+	if !ok {
+		return entities.Message{}, entities.ErrNotFound
+	}
+
+	return findByID(r.ordered(), id)
+}
+
+func (m *Memory) Replies(_ context.Context, chat, id string, limit int) ([]entities.Message, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	r, ok := m.chats[chat]
+	if !ok {
+		return nil, nil
+	}
+
+	result := make([]entities.Message, 0)
+
+	for _, msg := range r.ordered() {
+		if msg.ReplyTo == id {
+			result = append(result, msg)
+		}
+	}
+
+	if limit > 0 && len(result) > limit {
+		result = result[len(result)-limit:]
+	}
+
+	return result, nil
+}
+
+func (m *Memory) AddUser(_ context.Context, user entities.User) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, ok := m.users[user.Login]; ok {
+		return entities.ErrAlreadyExists
+	}
+
+	m.users[user.Login] = user
+
+	return nil
+}
+
+func (m *Memory) GetUser(_ context.Context, login string) (entities.User, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	user, ok := m.users[login]
+	if !ok {
+		return entities.User{}, entities.ErrNotFound
+	}
+
+	return user, nil
+}
+
+func (m *Memory) SetEncryptionKey(_ context.Context, login string, key, signature []byte) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	user, ok := m.users[login]
+	if !ok {
+		return entities.ErrNotFound
+	}
+
+	user.EncryptionKey = key
+	user.EncryptionKeySignature = signature
+	m.users[login] = user
+
+	return nil
+}
+
+func (m *Memory) AddSession(_ context.Context, session entities.Session) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.sessions[session.TokenHash] = session
+
+	return nil
+}
+
+func (m *Memory) GetSession(_ context.Context, tokenHash string) (entities.Session, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	session, ok := m.sessions[tokenHash]
+	if !ok {
+		return entities.Session{}, entities.ErrNotFound
+	}
+
+	return session, nil
+}
+
+func (m *Memory) Close() error {
+	return nil
+}
+
+// ordered - сообщения от старых к новым, вызывать только под блокировкой
+func (r *ring) ordered() []entities.Message {
+	if !r.full {
+		return r.messages[:r.next]
+	}
+
+	result := make([]entities.Message, 0, len(r.messages))
+	result = append(result, r.messages[r.next:]...)
+	result = append(result, r.messages[:r.next]...)
+
+	return result
+}
+
+// lastPosition - последний номер канала с учетом номеров изменений
+func lastPosition(messages []entities.Message) uint64 {
+	var last uint64
+
+	for _, msg := range messages {
+		last = max(last, msg.Position())
+	}
+
+	return last
+}
+
+// changedAfter - сообщения с номером больше after и ранние сообщения, измененные после after,
+// в порядке номеров (для изменения - номера изменения), 0 в limit означает без ограничения
+func changedAfter(messages []entities.Message, after uint64, limit int) []entities.Message {
+	result := make([]entities.Message, 0)
+
+	for _, msg := range messages {
+		if msg.Seq > after || msg.ChangeSeq > after {
+			result = append(result, msg)
+		}
+	}
+
+	position := func(msg entities.Message) uint64 {
+		if msg.Seq > after {
+			return msg.Seq
+		}
+
+		return msg.ChangeSeq
+	}
+
+	slices.SortStableFunc(result, func(a, b entities.Message) int {
+		return cmp.Compare(position(a), position(b))
+	})
+
+	if limit > 0 && len(result) > limit {
+		result = result[:limit]
+	}
+
+	return result
+}
+
+// lastBefore - последние limit сообщений с номером меньше before, 0 означает без ограничения
+func lastBefore(messages []entities.Message, before uint64, limit int) []entities.Message {
+	end := len(messages)
+
+	if before > 0 {
+		for end > 0 && messages[end-1].Seq >= before {
+			end--
+		}
+	}
+
+	start := 0
+
+	if limit > 0 && end-limit > 0 {
+		start = end - limit
+	}
+
+	result := make([]entities.Message, end-start)
+	copy(result, messages[start:end])
+
+	return result
+}
+
+func findByID(messages []entities.Message, id string) (entities.Message, error) {
+	for _, msg := range messages {
+		if msg.ID == id {
+			return msg, nil
+		}
+	}
+
+	return entities.Message{}, entities.ErrNotFound
+}
@@ -0,0 +1,91 @@
+package ulid
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"sync"
+	"time"
+)
+
+// Алфавит Crockford base32, сохраняет лексикографический порядок
+const alphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+var generator = &monotonic{
+	mutex: &sync.Mutex{},
+}
+
+type monotonic struct {
+	mutex *sync.Mutex
+
+	lastMS  uint64
+	entropy [10]byte
+}
+
+// New - возвращает сортируемый по времени идентификатор в формате ULID,
+// идентификаторы созданные в одну миллисекунду возрастают монотонно
+func New() string {
+	return generator.next(time.Now())
+}
+
+func (m *monotonic) next(now time.Time) string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	ms := uint64(now.UnixMilli())
+
+	if ms > m.lastMS || !m.increment() {
+		// Ошибка чтения случайных данных в go не возвращается
+		_, _ = rand.Read(m.entropy[:])
+	}
+
+	if ms > m.lastMS {
+		m.lastMS = ms
+	}
+
+	var data [16]byte
+
+	binary.BigEndian.PutUint16(data[0:], uint16(m.lastMS>>32))
+	binary.BigEndian.PutUint32(data[2:], uint32(m.lastMS))
+	copy(data[6:], m.entropy[:])
+
+	return encode(data)
+}
+
+// increment - увеличивает случайную часть, false при переполнении
+func (m *monotonic) increment() bool {
+	for i := len(m.entropy) - 1; i >= 0; i-- {
+		m.entropy[i]++
+
+		if m.entropy[i] != 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+func encode(data [16]byte) string {
+	var (
+		result [26]byte
+		acc    uint64
+		bits   uint
+		pos    = len(result) - 1
+	)
+
+	// 128 бит кодируются с младших разрядов, старший символ содержит только 3 бита
+	for i := len(data) - 1; i >= 0; i-- {
+		acc |= uint64(data[i]) << bits
+		bits += 8
+
+		for bits >= 5 {
+			result[pos] = alphabet[acc&31]
+			pos--
+			acc >>= 5
+			bits -= 5
+		}
+	}
+
+	result[pos] = alphabet[acc&31]
+
+	return string(result[:])
+}